@@ -1,10 +1,14 @@
 package collector
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
+	"github.com/kckecheng/osprobe/config"
+	"github.com/kckecheng/osprobe/credential"
 	"github.com/kckecheng/osprobe/probe"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
@@ -35,47 +39,178 @@ var descs = map[string]*prometheus.Desc{
 		[]string{"host", "type"},
 		nil,
 	),
+	"nic_received_bytes": prometheus.NewDesc(
+		"nic_received_bytes_total",
+		"bytes received by all NICs except loopback since boot",
+		[]string{"host", "type"},
+		nil,
+	),
+	"nic_sent_bytes": prometheus.NewDesc(
+		"nic_sent_bytes_total",
+		"bytes sent by all NICs except loopback since boot",
+		[]string{"host", "type"},
+		nil,
+	),
+	"probe_error": prometheus.NewDesc(
+		"probe_error",
+		"why a metric cannot be probed: auth, timeout, parse, unsupported, offline or unknown",
+		[]string{"host", "type", "metric", "reason"},
+		nil,
+	),
+	"probe_timestamp": prometheus.NewDesc(
+		"probe_timestamp",
+		"unix time when the server was probed",
+		[]string{"host", "type"},
+		nil,
+	),
+}
+
+// valueKeys metrics which are only exported when they are probed successfully
+var valueKeys = []string{"cpu_utilization", "mem_utilization", "nic_received_bytes", "nic_sent_bytes"}
+
+// counterKeys values which are cumulative since boot, they are exported as counters
+var counterKeys = map[string]bool{"nic_received_bytes": true, "nic_sent_bytes": true}
+
+// reservedLabels labels which cannot be overwritten by user defined labels
+var reservedLabels = map[string]bool{"host": true, "type": true}
+
+// summaryStats statistics exported for each summarized metric
+var summaryStats = []string{"min", "mean", "max", "quantile"}
+
+func init() {
+	for _, k := range summaryKeys {
+		for _, stat := range summaryStats {
+			name := k + "_" + stat
+			labels := []string{"host", "type"}
+			help := fmt.Sprintf("%s of %s across the samples of the last probe round", stat, k)
+			if stat == "quantile" {
+				labels = append(labels, "quantile")
+			}
+			descs[name] = prometheus.NewDesc(name, help, labels, nil)
+		}
+	}
 }
 
 // ServerCollector prometheus collector
 type ServerCollector struct {
 	Servers []probe.Server
-	Stat    map[string]map[string]float64
+	// Results latest result of each server by Key
+	Results map[string]Result
 	Mutex   sync.Mutex
+
+	// Quantile computed for summaries, e.g., 0.95
+	Quantile float64
+	// samples taken during the current probe round by Key
+	samples map[string]map[string][]float64
+	// summaries of the last probe round during which each server was probed, by Key
+	summaries map[string]map[string]Summary
+
+	// server_info is built based on the union of user defined labels
+	infoDesc   *prometheus.Desc
+	infoLabels []string
 }
 
-// NewServerCollector init collector
+// NewServerCollector init collector with a configuration file in JSON, YAML or TOML
 func NewServerCollector(path string) *ServerCollector {
-	contents, err := ioutil.ReadFile(path)
+	cfg, err := config.Load(path)
 	if err != nil {
-		log.Fatalf("Fail to read file %s due to %s", path, err)
+		log.Fatalf("Fail to load configuration %s due to %s", path, err)
 	}
-
-	var servers []probe.Server
-	err = json.Unmarshal(contents, &servers)
+	servers, err := cfg.Expand()
 	if err != nil {
-		log.Fatal("Fail to decode json", err)
+		log.Fatalf("Fail to expand configuration %s due to %s", path, err)
 	}
+	return New(servers)
+}
+
+// New init collector with servers, a server defined more than once (same host and type) is kept once
+func New(servers []probe.Server) *ServerCollector {
+	var unique []probe.Server
+	seen := map[string]bool{}
 	for _, server := range servers {
-		if server.Type != "linux" && server.Type != "windows" && server.Type != "esxi" {
-			// log.Fatalf("Server type %s is not supported, please check the configuration", server.Type)
+		if _, ok := probe.Lookup(server.Type); !ok {
 			log.Errorf("Server type %s is not supported (%s), please check the configuration", server.Type, server.Host)
 		}
+		key := Key(server.Host, server.Type)
+		if seen[key] {
+			log.Warnf("Server %s (%s) is defined more than once, only the first definition is used", server.Host, server.Type)
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, server)
 	}
 
 	collector := ServerCollector{
-		Servers: servers,
-		Stat:    map[string]map[string]float64{},
-		Mutex:   sync.Mutex{},
+		Servers:   unique,
+		Results:   map[string]Result{},
+		Mutex:     sync.Mutex{},
+		Quantile:  0.95,
+		samples:   map[string]map[string][]float64{},
+		summaries: map[string]map[string]Summary{},
 	}
+	collector.buildInfoDesc()
 	return &collector
 }
 
+// ResolveCredentials fill user, password and key of servers referring to a credential by name
+func (sc *ServerCollector) ResolveCredentials(db *credential.DB) {
+	for i, server := range sc.Servers {
+		if server.Credential == "" {
+			continue
+		}
+
+		c, ok := db.Get(server.Credential)
+		if !ok {
+			log.Errorf("Credential %s is not defined (%s), please check the configuration", server.Credential, server.Host)
+			continue
+		}
+		sc.Servers[i].User = c.User
+		sc.Servers[i].Password = c.Password
+		sc.Servers[i].Key = c.Key
+	}
+}
+
 // Describe implement prometheus collector required interface
 func (sc *ServerCollector) Describe(ch chan<- *prometheus.Desc) {
 	for _, v := range descs {
 		ch <- v
 	}
+	ch <- sc.infoDesc
+}
+
+// Update save the latest probe result of a server and keep its values as samples of the current round
+func (sc *ServerCollector) Update(r Result) {
+	sc.Mutex.Lock()
+	defer sc.Mutex.Unlock()
+	key := Key(r.Host, r.Type)
+	sc.Results[key] = r
+
+	if _, ok := sc.samples[key]; !ok {
+		sc.samples[key] = map[string][]float64{}
+	}
+	for _, k := range summaryKeys {
+		if v, ok := r.Values[k]; ok {
+			sc.samples[key][k] = append(sc.samples[key][k], v.Value)
+		}
+	}
+}
+
+// Summarize complete the current probe round: summaries are computed and samples are cleared. Servers not probed
+// during the round (a longer interval) keep the summaries of their last probe.
+func (sc *ServerCollector) Summarize() {
+	sc.Mutex.Lock()
+	defer sc.Mutex.Unlock()
+
+	for key, samples := range sc.samples {
+		sc.summaries[key] = map[string]Summary{}
+		for k, values := range samples {
+			if len(values) == 0 {
+				continue
+			}
+			sc.summaries[key][k] = Summarize(values, sc.Quantile)
+		}
+	}
+	sc.samples = map[string]map[string][]float64{}
 }
 
 // Collect implement prometheus collector required interface
@@ -83,27 +218,162 @@ func (sc *ServerCollector) Collect(ch chan<- prometheus.Metric) {
 	sc.Mutex.Lock()
 	defer sc.Mutex.Unlock()
 
-	descKeys := []string{"online", "accessible", "cpu_utilization", "mem_utilization"}
-	for k, v := range sc.Stat {
-		target := sc.findServer(k)
+	for _, s := range sc.Servers {
+		values := []string{s.Host, s.Type}
+		for _, l := range sc.infoLabels {
+			values = append(values, s.Labels[l])
+		}
+		ch <- prometheus.MustNewConstMetric(sc.infoDesc, prometheus.GaugeValue, 1, values...)
+	}
+
+	// A duplicate series or a metric with different labels fails the whole gather, such series are dropped
+	seen := map[string]bool{}
+	dims := map[string]string{}
+
+	quantile := strconv.FormatFloat(sc.Quantile, 'f', -1, 64)
+	for key, r := range sc.Results {
+		target := sc.findServer(r.Host, r.Type)
+
+		ch <- prometheus.MustNewConstMetric(descs["online"], prometheus.GaugeValue, boolToFloat(r.Online), target.Host, target.Type)
+		ch <- prometheus.MustNewConstMetric(descs["accessible"], prometheus.GaugeValue, boolToFloat(r.Accessible), target.Host, target.Type)
+		ch <- prometheus.MustNewConstMetric(descs["probe_timestamp"], prometheus.GaugeValue, float64(r.Timestamp.Unix()), target.Host, target.Type)
+
+		// Values not known are omitted instead of being reported as 0
+		for _, vk := range valueKeys {
+			v, ok := r.Values[vk]
+			if !ok {
+				continue
+			}
+			vt := prometheus.GaugeValue
+			if counterKeys[vk] {
+				vt = prometheus.CounterValue
+			}
+			ch <- prometheus.MustNewConstMetric(descs[vk], vt, v.Value, target.Host, target.Type)
+		}
+
+		for k, summary := range sc.summaries[key] {
+			ch <- prometheus.MustNewConstMetric(descs[k+"_min"], prometheus.GaugeValue, summary.Min, target.Host, target.Type)
+			ch <- prometheus.MustNewConstMetric(descs[k+"_mean"], prometheus.GaugeValue, summary.Mean, target.Host, target.Type)
+			ch <- prometheus.MustNewConstMetric(descs[k+"_max"], prometheus.GaugeValue, summary.Max, target.Host, target.Type)
+			ch <- prometheus.MustNewConstMetric(descs[k+"_quantile"], prometheus.GaugeValue, summary.Quantile, target.Host, target.Type, quantile)
+		}
+
+		for metric, f := range r.Failures {
+			ch <- prometheus.MustNewConstMetric(descs["probe_error"], prometheus.GaugeValue, 1, target.Host, target.Type, metric, f.Reason)
+		}
+
+		// Backend specific metrics are described on the fly, they are not known in advance
+		for _, m := range r.Extra {
+			names := []string{"host", "type"}
+			values := []string{sc.matchHost(target.Host, m.Hosts), target.Type}
+			var keys []string
+			for k := range m.Labels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				names = append(names, k)
+				values = append(values, m.Labels[k])
+			}
+
+			vt := prometheus.GaugeValue
+			if m.Type == probe.Counter {
+				vt = prometheus.CounterValue
+			}
+			dim := strings.Join(append([]string{m.Help, strconv.Itoa(int(vt))}, names...), "\xff")
+			if d, ok := dims[m.Name]; ok && d != dim {
+				log.Warnf("Metric %s of %s is dropped since its labels or help differ from other series", m.Name, target.Host)
+				continue
+			}
+			dims[m.Name] = dim
+			id := m.Name + "\xff" + strings.Join(values, "\xff")
+			if seen[id] {
+				log.Warnf("Metric %s %v of %s is dropped since it is reported more than once", m.Name, m.Labels, target.Host)
+				continue
+			}
+			seen[id] = true
 
-		for _, dk := range descKeys {
-			ch <- prometheus.MustNewConstMetric(
-				descs[dk],
-				prometheus.GaugeValue,
-				v[dk],
-				target.Host,
-				target.Type,
-			)
+			desc := prometheus.NewDesc(m.Name, m.Help, names, nil)
+			ch <- prometheus.MustNewConstMetric(desc, vt, m.Value, values...)
 		}
 	}
 }
 
-func (sc *ServerCollector) findServer(host string) probe.Server {
+// buildInfoDesc server_info carries user defined labels, it can be joined with other metrics on host
+func (sc *ServerCollector) buildInfoDesc() {
+	seen := map[string]bool{}
+	for i, server := range sc.Servers {
+		labels := map[string]string{}
+		for k, v := range server.Labels {
+			name := sanitizeLabel(k)
+			if reservedLabels[name] || name == "" || strings.HasPrefix(name, "__") {
+				log.Errorf("Label %s is reserved (%s), please check the configuration", k, server.Host)
+				continue
+			}
+			labels[name] = v
+			seen[name] = true
+		}
+		sc.Servers[i].Labels = labels
+	}
+
+	sc.infoLabels = []string{}
+	for k := range seen {
+		sc.infoLabels = append(sc.infoLabels, k)
+	}
+	sort.Strings(sc.infoLabels)
+
+	sc.infoDesc = prometheus.NewDesc(
+		"server_info",
+		"user defined metadata of the server, the value is always 1",
+		append([]string{"host", "type"}, sc.infoLabels...),
+		nil,
+	)
+}
+
+// sanitizeLabel convert a user defined key to a valid prometheus label name
+func sanitizeLabel(key string) string {
+	var b strings.Builder
+	for i, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+			b.WriteRune(c)
+		case c >= '0' && c <= '9' && i > 0:
+			b.WriteRune(c)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// matchHost the host label of a metric about another machine, so that it can be joined with metrics of the machine
+// probed as a server of its own, e.g., a Kubernetes node probed as a linux server
+func (sc *ServerCollector) matchHost(host string, candidates []string) string {
+	if len(candidates) == 0 {
+		return host
+	}
+	for _, c := range candidates {
+		for _, s := range sc.Servers {
+			if strings.EqualFold(s.Host, c) {
+				return s.Host
+			}
+		}
+	}
+	return candidates[0]
+}
+
+func (sc *ServerCollector) findServer(host, typ string) probe.Server {
 	for _, s := range sc.Servers {
-		if s.Host == host {
+		if s.Host == host && s.Type == typ {
 			return s
 		}
 	}
 	return probe.Server{}
 }
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gather series of the named metrics as sorted `name{label="value",...} value` lines
+func gather(t *testing.T, sc *ServerCollector, names ...string) []string {
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(sc)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wanted := map[string]bool{}
+	for _, n := range names {
+		wanted[n] = true
+	}
+	var ret []string
+	for _, f := range families {
+		if !wanted[f.GetName()] {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			ret = append(ret, fmt.Sprintf("%s{%s} %g", f.GetName(), strings.Join(labels, ","), m.GetGauge().GetValue()))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func TestSameHostSeveralTypes(t *testing.T) {
+	sc := New([]probe.Server{
+		{Host: "h1", Type: "linux", Labels: map[string]string{"role": "guest"}},
+		{Host: "h1", Type: "libvirt", Labels: map[string]string{"role": "hypervisor"}},
+		{Host: "h1", Type: "linux", Labels: map[string]string{"role": "duplicate"}},
+	})
+	if len(sc.Servers) != 2 {
+		t.Fatalf("servers = %d, want 2", len(sc.Servers))
+	}
+
+	for i, s := range sc.Servers {
+		for _, v := range []float64{10, 30} {
+			r := NewResult(s)
+			r.Online = true
+			r.Set("cpu_utilization", v*float64(i+1))
+			sc.Update(r)
+		}
+	}
+	if len(sc.Results) != 2 {
+		t.Fatalf("results = %d, want 2", len(sc.Results))
+	}
+	sc.Summarize()
+
+	got := gather(t, sc, "server_info", "cpu_utilization", "cpu_utilization_mean")
+	want := []string{
+		`cpu_utilization_mean{host="h1",type="libvirt"} 40`,
+		`cpu_utilization_mean{host="h1",type="linux"} 20`,
+		`cpu_utilization{host="h1",type="libvirt"} 60`,
+		`cpu_utilization{host="h1",type="linux"} 30`,
+		`server_info{host="h1",role="guest",type="linux"} 1`,
+		`server_info{host="h1",role="hypervisor",type="libvirt"} 1`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+// Value a probed value and when it was sampled
+type Value struct {
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Failure why a value could not be probed
+type Failure struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Result of one round of probe against a server, values which cannot be probed are omitted
+type Result struct {
+	Host       string             `json:"host"`
+	Type       string             `json:"type"`
+	Labels     map[string]string  `json:"labels,omitempty"`
+	Timestamp  time.Time          `json:"timestamp"`
+	Online     bool               `json:"online"`
+	Accessible bool               `json:"accessible"`
+	Values     map[string]Value   `json:"values"`
+	Failures   map[string]Failure `json:"failures,omitempty"`
+	// Extra backend specific metrics
+	Extra []probe.Metric `json:"extra,omitempty"`
+}
+
+// Key identify a server by host and type, a host can be probed as several types, e.g., linux and libvirt
+func Key(host, typ string) string {
+	return host + "/" + typ
+}
+
+// NewResult init an empty result for a server
+func NewResult(server probe.Server) Result {
+	return Result{
+		Host:      server.Host,
+		Type:      server.Type,
+		Labels:    server.Labels,
+		Timestamp: time.Now(),
+		Values:    map[string]Value{},
+		Failures:  map[string]Failure{},
+	}
+}
+
+// Set record a probed value
+func (r *Result) Set(name string, v float64) {
+	r.Values[name] = Value{
+		Value:     v,
+		Timestamp: time.Now(),
+	}
+}
+
+// Fail record why a value cannot be probed
+func (r *Result) Fail(name, reason string, err error) {
+	f := Failure{Reason: reason}
+	if err != nil {
+		f.Message = err.Error()
+	}
+	r.Failures[name] = f
+}
+
+// Failed if any value cannot be probed, values not supported by the server type are not failures
+func (r Result) Failed() bool {
+	for _, f := range r.Failures {
+		if f.Reason != probe.ReasonUnsupported {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"math"
+	"sort"
+)
+
+// summaryKeys metrics which are summarized across samples of a probe round
+var summaryKeys = []string{"cpu_utilization", "mem_utilization"}
+
+// Summary statistics of the samples taken during a probe round
+type Summary struct {
+	Count    int     `json:"count"`
+	Min      float64 `json:"min"`
+	Mean     float64 `json:"mean"`
+	Max      float64 `json:"max"`
+	Quantile float64 `json:"quantile"`
+}
+
+// Summarize compute statistics of samples, q is the quantile to compute
+func Summarize(values []float64, q float64) Summary {
+	s := Summary{Count: len(values)}
+	if len(values) == 0 {
+		return s
+	}
+
+	s.Min = math.Inf(1)
+	s.Max = math.Inf(-1)
+	var total float64
+	for _, v := range values {
+		s.Min = math.Min(s.Min, v)
+		s.Max = math.Max(s.Max, v)
+		total += v
+	}
+	s.Mean = total / float64(len(values))
+	s.Quantile = Quantile(values, q)
+	return s
+}
+
+// Quantile compute the q-quantile with linear interpolation, NaN is returned for no value
+func Quantile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	pos := q * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
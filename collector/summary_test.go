@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"math"
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+func TestQuantile(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		q      float64
+		want   float64
+	}{
+		{"one sample", []float64{7}, 0.95, 7},
+		{"minimum", []float64{3, 1, 2}, 0, 1},
+		{"maximum", []float64{3, 1, 2}, 1, 3},
+		{"median of odd samples", []float64{5, 1, 3}, 0.5, 3},
+		{"interpolated median", []float64{4, 1, 3, 2}, 0.5, 2.5},
+		{"interpolated quantile", []float64{10, 20, 30, 40, 50}, 0.95, 48},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Quantile(tt.values, tt.q); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Quantile(%v, %v) = %v, want %v", tt.values, tt.q, got, tt.want)
+			}
+		})
+	}
+
+	if got := Quantile(nil, 0.5); !math.IsNaN(got) {
+		t.Errorf("Quantile of no sample = %v, want NaN", got)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   Summary
+	}{
+		{"no sample", nil, Summary{}},
+		{"one sample", []float64{42}, Summary{Count: 1, Min: 42, Mean: 42, Max: 42, Quantile: 42}},
+		{"several samples", []float64{30, 10, 20}, Summary{Count: 3, Min: 10, Mean: 20, Max: 30, Quantile: 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Summarize(tt.values, 1); got != tt.want {
+				t.Errorf("Summarize(%v) = %+v, want %+v", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummariesKeptUntilProbed(t *testing.T) {
+	fast := probe.Server{Host: "h1", Type: "linux"}
+	slow := probe.Server{Host: "h2", Type: "linux", Interval: 7200}
+	sc := New([]probe.Server{fast, slow})
+
+	update := func(s probe.Server, v float64) {
+		r := NewResult(s)
+		r.Set("cpu_utilization", v)
+		sc.Update(r)
+	}
+
+	update(fast, 10)
+	update(slow, 50)
+	sc.Summarize()
+
+	// Only the fast server is probed during the next round
+	update(fast, 20)
+	sc.Summarize()
+
+	if got := sc.summaries[Key("h1", "linux")]["cpu_utilization"].Mean; got != 20 {
+		t.Errorf("mean of the probed server = %v, want 20", got)
+	}
+	if got := sc.summaries[Key("h2", "linux")]["cpu_utilization"].Mean; got != 50 {
+		t.Errorf("mean of the server not probed = %v, want 50 from its last probe", got)
+	}
+
+	update(slow, 70)
+	sc.Summarize()
+	if got := sc.summaries[Key("h2", "linux")]["cpu_utilization"]; got.Count != 1 || got.Mean != 70 {
+		t.Errorf("summary of the server probed again = %+v, want a new window with 70", got)
+	}
+}
@@ -0,0 +1,495 @@
+package config
+
+/*
+	Ansible inventories in INI or YAML are mapped to servers as below:
+	- ansible_host (or the inventory hostname), ansible_port, ansible_user, ansible_password and
+	  ansible_ssh_private_key_file: host, port, user, password and key;
+	- osprobe_type, osprobe_credential: type and credential;
+	- ansible_connection winrm or psrp: windows; membership of a group named linux, windows or esxi: the type;
+	- group membership: the ansible_groups label (comma separated), hosts are put into the first group
+	  of the configuration with the same name as one of their groups;
+	- osprobe_label_<name>: the <name> label.
+
+	Variables are applied from the all group to child groups, host variables win.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kckecheng/osprobe/probe"
+	"gopkg.in/yaml.v3"
+)
+
+// ansibleGroup hosts, child groups and variables of an Ansible group
+type ansibleGroup struct {
+	hosts    []string
+	children []string
+	vars     map[string]string
+}
+
+// ansibleInventory parsed Ansible inventory
+type ansibleInventory struct {
+	groups map[string]*ansibleGroup
+	vars   map[string]map[string]string // host variables
+	order  []string                     // hosts in the order of their first appearance
+}
+
+func newAnsibleInventory() *ansibleInventory {
+	ai := &ansibleInventory{
+		groups: map[string]*ansibleGroup{},
+		vars:   map[string]map[string]string{},
+	}
+	ai.group("all")
+	return ai
+}
+
+func (ai *ansibleInventory) group(name string) *ansibleGroup {
+	g, ok := ai.groups[name]
+	if !ok {
+		g = &ansibleGroup{vars: map[string]string{}}
+		ai.groups[name] = g
+	}
+	return g
+}
+
+// addHost add a host to a group with its variables, later variables win
+func (ai *ansibleInventory) addHost(group, host string, vars map[string]string) {
+	if _, ok := ai.vars[host]; !ok {
+		ai.vars[host] = map[string]string{}
+		ai.order = append(ai.order, host)
+	}
+	for k, v := range vars {
+		ai.vars[host][k] = v
+	}
+
+	g := ai.group(group)
+	for _, h := range g.hosts {
+		if h == host {
+			return
+		}
+	}
+	g.hosts = append(g.hosts, host)
+}
+
+// parseAnsibleINI parse an Ansible inventory in INI format
+func parseAnsibleINI(contents []byte) (*ansibleInventory, error) {
+	ai := newAnsibleInventory()
+	section, kind := "ungrouped", "hosts"
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section, kind = line[1:len(line)-1], "hosts"
+			if i := strings.LastIndex(section, ":"); i > 0 {
+				section, kind = section[:i], section[i+1:]
+			}
+			if kind != "hosts" && kind != "vars" && kind != "children" {
+				return nil, fmt.Errorf("line %d: section type %s is not supported", n, kind)
+			}
+			ai.group(section)
+			continue
+		}
+
+		switch kind {
+		case "hosts":
+			fields, err := splitINI(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			vars := map[string]string{}
+			for _, f := range fields[1:] {
+				kv := strings.SplitN(f, "=", 2)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("line %d: host variable %s is not key=value", n, f)
+				}
+				vars[kv[0]] = kv[1]
+			}
+			hosts, err := expandAnsiblePattern(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			for _, host := range hosts {
+				ai.addHost(section, host, vars)
+			}
+		case "vars":
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("line %d: group variable %s is not key=value", n, line)
+			}
+			ai.group(section).vars[strings.TrimSpace(kv[0])] = unquote(strings.TrimSpace(kv[1]))
+		case "children":
+			ai.group(line)
+			g := ai.group(section)
+			g.children = append(g.children, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ai, nil
+}
+
+// splitINI split a host line on white spaces, quoted values may contain white spaces
+func splitINI(line string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ' ' || r == '\t':
+			if cur.Len() > 0 {
+				fields = append(fields, cur.String())
+				cur.Reset()
+			}
+		case r == '#' && cur.Len() == 0:
+			// Trailing comment
+			return fields, nil
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if cur.Len() > 0 {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// maxExpand the max. num. of hosts a host pattern can be expanded to, the same as the scanner
+const maxExpand = 65536
+
+// expandAnsiblePattern e.g. www[01:50].example.com, db-[a:f].example.com or node[1:10:2], zero padding is kept
+func expandAnsiblePattern(pattern string) ([]string, error) {
+	start := strings.Index(pattern, "[")
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	end := strings.Index(pattern[start:], "]")
+	if end < 0 {
+		return nil, fmt.Errorf("invalid host pattern %s", pattern)
+	}
+	end += start
+	prefix, body, suffix := pattern[:start], pattern[start+1:end], pattern[end+1:]
+
+	bounds := strings.Split(body, ":")
+	if len(bounds) != 2 && len(bounds) != 3 {
+		return nil, fmt.Errorf("invalid host pattern %s", pattern)
+	}
+	step := 1
+	if len(bounds) == 3 {
+		var err error
+		step, err = strconv.Atoi(bounds[2])
+		if err != nil || step <= 0 {
+			return nil, fmt.Errorf("invalid host pattern %s", pattern)
+		}
+	}
+
+	first, err1 := strconv.Atoi(bounds[0])
+	last, err2 := strconv.Atoi(bounds[1])
+	format := func(i int) string { return string(rune(i)) }
+	switch {
+	case err1 == nil && err2 == nil:
+		width := 0
+		if strings.HasPrefix(bounds[0], "0") {
+			width = len(bounds[0])
+		}
+		format = func(i int) string { return fmt.Sprintf("%0*d", width, i) }
+	case len(bounds[0]) == 1 && len(bounds[1]) == 1:
+		first, last = int(bounds[0][0]), int(bounds[1][0])
+	default:
+		return nil, fmt.Errorf("invalid host pattern %s", pattern)
+	}
+	if last >= first && (last-first)/step+1 > maxExpand {
+		return nil, fmt.Errorf("host pattern %s contains more than %d hosts", pattern, maxExpand)
+	}
+	var values []string
+	for i := first; i <= last; i += step {
+		values = append(values, format(i))
+	}
+
+	rests, err := expandAnsiblePattern(suffix)
+	if err != nil {
+		return nil, err
+	}
+	// The size is checked before the product is built
+	if len(values)*len(rests) > maxExpand {
+		return nil, fmt.Errorf("host pattern %s contains more than %d hosts", pattern, maxExpand)
+	}
+	var ret []string
+	for _, v := range values {
+		for _, rest := range rests {
+			ret = append(ret, prefix+v+rest)
+		}
+	}
+	return ret, nil
+}
+
+// yamlGroup an Ansible group in YAML format
+type yamlGroup struct {
+	Hosts    map[string]map[string]interface{} `yaml:"hosts"`
+	Vars     map[string]interface{}            `yaml:"vars"`
+	Children map[string]*yamlGroup             `yaml:"children"`
+}
+
+// parseAnsibleYAML parse an Ansible inventory in YAML (or JSON) format
+func parseAnsibleYAML(contents []byte) (*ansibleInventory, error) {
+	var top map[string]*yamlGroup
+	if err := yaml.Unmarshal(contents, &top); err != nil {
+		return nil, err
+	}
+
+	ai := newAnsibleInventory()
+	var add func(name string, g *yamlGroup)
+	add = func(name string, g *yamlGroup) {
+		ag := ai.group(name)
+		if g == nil {
+			return
+		}
+		for k, v := range g.Vars {
+			ag.vars[k] = fmt.Sprint(v)
+		}
+
+		// Hosts are added in a stable order
+		var hosts []string
+		for host := range g.Hosts {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, pattern := range hosts {
+			vars := map[string]string{}
+			for k, v := range g.Hosts[pattern] {
+				vars[k] = fmt.Sprint(v)
+			}
+			expanded, err := expandAnsiblePattern(pattern)
+			if err != nil {
+				expanded = []string{pattern}
+			}
+			for _, host := range expanded {
+				ai.addHost(name, host, vars)
+			}
+		}
+
+		var children []string
+		for child := range g.Children {
+			children = append(children, child)
+		}
+		sort.Strings(children)
+		for _, child := range children {
+			ag = ai.group(name)
+			ag.children = append(ag.children, child)
+			add(child, g.Children[child])
+		}
+	}
+
+	var names []string
+	for name := range top {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(name, top[name])
+	}
+	return ai, nil
+}
+
+// depths depth of groups from the all group, variables of deeper groups win
+func (ai *ansibleInventory) depths() map[string]int {
+	depths := map[string]int{}
+	var walk func(name string, depth int, seen map[string]bool)
+	walk = func(name string, depth int, seen map[string]bool) {
+		if seen[name] {
+			return
+		}
+		if d, ok := depths[name]; !ok || depth > d {
+			depths[name] = depth
+		}
+		seen[name] = true
+		if g, ok := ai.groups[name]; ok {
+			for _, child := range g.children {
+				walk(child, depth+1, seen)
+			}
+		}
+		delete(seen, name)
+	}
+
+	// Groups which are not children of any other group are children of all
+	isChild := map[string]bool{}
+	for _, g := range ai.groups {
+		for _, child := range g.children {
+			isChild[child] = true
+		}
+	}
+	for name := range ai.groups {
+		if name == "all" {
+			continue
+		}
+		if !isChild[name] {
+			walk(name, 1, map[string]bool{})
+		}
+	}
+	depths["all"] = 0
+	return depths
+}
+
+// memberOf groups a host belongs to, directly or through child groups
+func (ai *ansibleInventory) memberOf(host string) []string {
+	member := map[string]bool{"all": true}
+	for name, g := range ai.groups {
+		for _, h := range g.hosts {
+			if h == host {
+				member[name] = true
+			}
+		}
+	}
+
+	// Parents of member groups until nothing changes
+	for changed := true; changed; {
+		changed = false
+		for name, g := range ai.groups {
+			if member[name] {
+				continue
+			}
+			for _, child := range g.children {
+				if member[child] {
+					member[name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	var ret []string
+	for name := range member {
+		ret = append(ret, name)
+	}
+	return ret
+}
+
+// servers map hosts of the inventory to servers
+func (ai *ansibleInventory) servers(groups map[string]Group) []Server {
+	depths := ai.depths()
+
+	var ret []Server
+	for _, host := range ai.order {
+		member := ai.memberOf(host)
+		sort.Slice(member, func(i, j int) bool {
+			if depths[member[i]] != depths[member[j]] {
+				return depths[member[i]] < depths[member[j]]
+			}
+			return member[i] < member[j]
+		})
+
+		vars := map[string]string{}
+		for _, name := range member {
+			for k, v := range ai.groups[name].vars {
+				vars[k] = v
+			}
+		}
+		for k, v := range ai.vars[host] {
+			vars[k] = v
+		}
+
+		var names []string
+		for _, name := range member {
+			if name != "all" && name != "ungrouped" {
+				names = append(names, name)
+			}
+		}
+		ret = append(ret, ansibleServer(host, names, vars, groups))
+	}
+	return ret
+}
+
+// inferredTypes types inferred from group names, groups such as local or kubernetes are common names for other things
+var inferredTypes = map[string]bool{"linux": true, "windows": true, "esxi": true}
+
+// ansibleServer map variables and groups of an Ansible host to a server
+func ansibleServer(name string, names []string, vars map[string]string, groups map[string]Group) Server {
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v, ok := vars[k]; ok {
+				return v
+			}
+		}
+		return ""
+	}
+
+	s := Server{Server: probe.Server{Host: name}}
+	if host := first("ansible_host", "ansible_ssh_host"); host != "" {
+		s.Host = host
+	}
+	s.User = first("ansible_user", "ansible_ssh_user")
+	s.Password = first("ansible_password", "ansible_ssh_pass")
+	s.Key = first("ansible_ssh_private_key_file", "ansible_private_key_file")
+	s.Credential = first("osprobe_credential")
+	if port, err := strconv.Atoi(first("ansible_port", "ansible_ssh_port")); err == nil {
+		s.Port = port
+	}
+
+	s.Type = first("osprobe_type")
+	if s.Type == "" {
+		switch first("ansible_connection") {
+		case "winrm", "psrp":
+			s.Type = "windows"
+		}
+	}
+	if s.Type == "" {
+		sorted := append([]string{}, names...)
+		sort.Strings(sorted)
+		for _, n := range sorted {
+			if inferredTypes[n] {
+				s.Type = n
+				break
+			}
+		}
+	}
+	s.Group = hostGroup(names, groups)
+
+	labels := map[string]string{}
+	if len(names) > 0 {
+		sorted := append([]string{}, names...)
+		sort.Strings(sorted)
+		labels["ansible_groups"] = strings.Join(sorted, ",")
+	}
+	if s.Host != name {
+		labels["inventory_hostname"] = name
+	}
+	for k, v := range vars {
+		if strings.HasPrefix(k, "osprobe_label_") {
+			labels[labelName(strings.TrimPrefix(k, "osprobe_label_"))] = v
+		}
+	}
+	if len(labels) > 0 {
+		s.Labels = labels
+	}
+	return s
+}
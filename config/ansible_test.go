@@ -0,0 +1,149 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+func TestExpandAnsiblePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+		err     bool
+	}{
+		{pattern: "web.example.com", want: []string{"web.example.com"}},
+		{pattern: "www[01:03].example.com", want: []string{"www01.example.com", "www02.example.com", "www03.example.com"}},
+		{pattern: "db-[a:c]", want: []string{"db-a", "db-b", "db-c"}},
+		{pattern: "node[1:6:2]", want: []string{"node1", "node3", "node5"}},
+		{pattern: "r[1:2]n[8:9]", want: []string{"r1n8", "r1n9", "r2n8", "r2n9"}},
+		{pattern: "node[3:1]", want: nil},
+		{pattern: "node[1:2", err: true},
+		{pattern: "node[a:bc]", err: true},
+		{pattern: "node[1:5:0]", err: true},
+		{pattern: "node[1]", err: true},
+		{pattern: "node[0:99999]", err: true},
+		{pattern: "r[1:300]n[1:300]", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := expandAnsiblePattern(tt.pattern)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+const iniInventory = `# Hosts outside of any group
+bastion ansible_host=10.0.0.1
+
+[linux]
+web[01:02].example.com ansible_user=admin   # Trailing comment
+
+[windows]
+win1 ansible_password="p w"
+
+[dc1:children]
+linux
+
+[dc1:vars]
+ansible_port=2222
+osprobe_label_Rack = "r1"
+
+[all:vars]
+ansible_user=root
+ansible_port=22
+`
+
+const yamlInventory = `all:
+  vars:
+    ansible_user: root
+  children:
+    esxi:
+      hosts:
+        esx[1:2]:
+          ansible_port: 443
+    local:
+      hosts:
+        db1:
+          osprobe_type: linux
+          ansible_host: 10.0.0.5
+        pc1:
+          ansible_connection: winrm
+        printer:
+`
+
+func TestAnsibleInventory(t *testing.T) {
+	groups := map[string]Group{"linux": {}, "local": {}}
+	server := func(host, user, password string, port int, typ, group string, labels map[string]string) Server {
+		return Server{
+			Server: probe.Server{Host: host, User: user, Password: password, Port: port, Type: typ, Labels: labels},
+			Group:  group,
+		}
+	}
+
+	tests := []struct {
+		name  string
+		parse func([]byte) (*ansibleInventory, error)
+		input string
+		want  []Server
+		err   string
+	}{
+		{
+			name:  "INI",
+			parse: parseAnsibleINI,
+			input: iniInventory,
+			want: []Server{
+				server("10.0.0.1", "root", "", 22, "", "", map[string]string{"inventory_hostname": "bastion"}),
+				server("web01.example.com", "admin", "", 2222, "linux", "linux", map[string]string{"ansible_groups": "dc1,linux", "rack": "r1"}),
+				server("web02.example.com", "admin", "", 2222, "linux", "linux", map[string]string{"ansible_groups": "dc1,linux", "rack": "r1"}),
+				server("win1", "root", "p w", 22, "windows", "", map[string]string{"ansible_groups": "windows"}),
+			},
+		},
+		{
+			name:  "YAML",
+			parse: parseAnsibleYAML,
+			input: yamlInventory,
+			want: []Server{
+				server("esx1", "root", "", 443, "esxi", "", map[string]string{"ansible_groups": "esxi"}),
+				server("esx2", "root", "", 443, "esxi", "", map[string]string{"ansible_groups": "esxi"}),
+				server("10.0.0.5", "root", "", 0, "linux", "local", map[string]string{"ansible_groups": "local", "inventory_hostname": "db1"}),
+				server("pc1", "root", "", 0, "windows", "local", map[string]string{"ansible_groups": "local"}),
+				// local is not a type
+				server("printer", "root", "", 0, "", "local", map[string]string{"ansible_groups": "local"}),
+			},
+		},
+		{name: "INI unsupported section", parse: parseAnsibleINI, input: "[web:meta]\n", err: "line 1"},
+		{name: "INI unterminated quote", parse: parseAnsibleINI, input: "[web]\nweb1 ansible_password='secret\n", err: "line 2"},
+		{name: "INI host variable without a value", parse: parseAnsibleINI, input: "web1 ansible_user\n", err: "line 1"},
+		{name: "INI group variable without a value", parse: parseAnsibleINI, input: "[web:vars]\nansible_user\n", err: "line 2"},
+		{name: "INI invalid host pattern", parse: parseAnsibleINI, input: "\n\nweb[a:bc]\n", err: "line 3"},
+		{name: "invalid YAML", parse: parseAnsibleYAML, input: "all: [", err: "yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ai, err := tt.parse([]byte(tt.input))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want an error with %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ai.servers(groups)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
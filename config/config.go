@@ -0,0 +1,341 @@
+package config
+
+/*
+	osprobe configuration in JSON, YAML or TOML (based on the file extension), e.g. in YAML:
+
+	job: osprobe
+	gateway: http://127.0.0.1:9091
+	interval: 3600
+	defaults:
+	  labels:
+	    lab: lab1
+	types:
+	  linux:
+	    user: root
+	    password: file:/etc/osprobe-secrets/linux-password
+	groups:
+	  storage:
+	    type: esxi
+	    credential: esxi-root
+	    labels:
+	      team: storage
+	    hosts: [192.168.68.231, 192.168.68.232]
+	servers:
+	  - host: 192.168.68.185
+	    type: linux
+	    labels:
+	      owner: alice
+	  - host: 192.168.68.233
+	    group: storage
+	    metrics: [cpu, mem]
+	inventories:
+	  - path: /etc/ansible/hosts
+
+	Servers inherit settings from the global defaults, the defaults of their type and their group in order,
+	settings of servers themselves win. The legacy format, a flat JSON array of servers, is still accepted.
+	Ansible INI inventories (.ini) and CSV files (.csv) can also be used directly as configurations.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kckecheng/osprobe/probe"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults settings inherited by servers
+type Defaults struct {
+	User       string            `json:"user,omitempty" yaml:"user,omitempty" toml:"user,omitempty"`
+	Password   string            `json:"password,omitempty" yaml:"password,omitempty" toml:"password,omitempty"`
+	Key        string            `json:"key,omitempty" yaml:"key,omitempty" toml:"key,omitempty"`
+	Credential string            `json:"credential,omitempty" yaml:"credential,omitempty" toml:"credential,omitempty"`
+	Port       int               `json:"port,omitempty" yaml:"port,omitempty" toml:"port,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty" yaml:"labels,omitempty" toml:"labels,omitempty"`
+	Interval   int64             `json:"interval,omitempty" yaml:"interval,omitempty" toml:"interval,omitempty"`
+	Metrics    []string          `json:"metrics,omitempty" yaml:"metrics,omitempty" toml:"metrics,omitempty"`
+	Options    map[string]string `json:"options,omitempty" yaml:"options,omitempty" toml:"options,omitempty"`
+}
+
+// Group named group of servers sharing settings
+type Group struct {
+	Defaults `yaml:",inline"`
+	Type     string   `json:"type,omitempty" yaml:"type,omitempty" toml:"type,omitempty"`
+	Hosts    []string `json:"hosts,omitempty" yaml:"hosts,omitempty" toml:"hosts,omitempty"`
+}
+
+// Server a server definition, settings not specified are inherited
+type Server struct {
+	probe.Server `yaml:",inline"`
+	Group        string `json:"group,omitempty" yaml:"group,omitempty" toml:"group,omitempty"`
+}
+
+// Config osprobe configuration
+type Config struct {
+	Job      string              `json:"job,omitempty" yaml:"job,omitempty" toml:"job,omitempty"`
+	Gateway  string              `json:"gateway,omitempty" yaml:"gateway,omitempty" toml:"gateway,omitempty"`
+	Interval int64               `json:"interval,omitempty" yaml:"interval,omitempty" toml:"interval,omitempty"`
+	Defaults Defaults            `json:"defaults,omitempty" yaml:"defaults,omitempty" toml:"defaults,omitempty"`
+	Types    map[string]Defaults `json:"types,omitempty" yaml:"types,omitempty" toml:"types,omitempty"`
+	Groups   map[string]Group    `json:"groups,omitempty" yaml:"groups,omitempty" toml:"groups,omitempty"`
+	Servers  []Server            `json:"servers,omitempty" yaml:"servers,omitempty" toml:"servers,omitempty"`
+	// Inventories Ansible inventories or CSV files servers are imported from
+	Inventories []Inventory `json:"inventories,omitempty" yaml:"inventories,omitempty" toml:"inventories,omitempty"`
+
+	// dir directory of the configuration file, relative inventory paths are based on it
+	dir string
+}
+
+// Format config format based on the file extension: json, yaml, toml, or inventories used directly:
+// ansible (.ini) and csv
+func Format(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	case ".ini":
+		return "ansible"
+	case ".csv":
+		return "csv"
+	}
+	return "json"
+}
+
+// Load load a configuration file
+func Load(path string) (*Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Parse(contents, Format(path))
+	if err != nil {
+		return nil, err
+	}
+	cfg.dir = filepath.Dir(path)
+	return cfg, nil
+}
+
+// Parse parse a configuration in a format
+func Parse(contents []byte, format string) (*Config, error) {
+	var cfg Config
+	var err error
+	switch format {
+	case "yaml":
+		err = yaml.Unmarshal(contents, &cfg)
+	case "toml":
+		err = toml.Unmarshal(contents, &cfg)
+	case "json":
+		// Legacy format: a flat array of servers
+		if bytes.HasPrefix(bytes.TrimSpace(contents), []byte("[")) {
+			err = json.Unmarshal(contents, &cfg.Servers)
+		} else {
+			err = json.Unmarshal(contents, &cfg)
+		}
+	case "ansible":
+		var ai *ansibleInventory
+		ai, err = parseAnsibleINI(contents)
+		if err == nil {
+			cfg.Servers = defaultType(ai.servers(nil), nil, "")
+		}
+	case "csv":
+		cfg.Servers, err = parseCSV(contents, nil)
+		cfg.Servers = defaultType(cfg.Servers, nil, "")
+	default:
+		err = fmt.Errorf("Config format %s is not supported", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Expand list all servers with inherited settings applied
+func (cfg *Config) Expand() ([]probe.Server, error) {
+	var ret []probe.Server
+
+	// Servers listed in groups directly
+	listed := map[string]bool{}
+	for _, s := range cfg.Servers {
+		listed[s.Host] = true
+	}
+	var groupNames []string
+	for name := range cfg.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+	for _, name := range groupNames {
+		for _, host := range cfg.Groups[name].Hosts {
+			// Explicit server definitions win
+			if listed[host] {
+				continue
+			}
+			listed[host] = true
+			s, err := cfg.inherit(Server{Server: probe.Server{Host: host}, Group: name})
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, s)
+		}
+	}
+
+	// Servers imported from inventories, the first definition of a host wins
+	for _, inv := range cfg.Inventories {
+		servers, err := inv.load(cfg.dir, cfg.Groups)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range servers {
+			if listed[s.Host] {
+				continue
+			}
+			listed[s.Host] = true
+			server, err := cfg.inherit(s)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, server)
+		}
+	}
+
+	for _, s := range cfg.Servers {
+		server, err := cfg.inherit(s)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, server)
+	}
+	return ret, nil
+}
+
+// inherit apply global, type and group settings in order, settings of the server itself win
+func (cfg *Config) inherit(s Server) (probe.Server, error) {
+	var layers []Defaults
+	layers = append(layers, cfg.Defaults)
+
+	var group Group
+	if s.Group != "" {
+		var ok bool
+		group, ok = cfg.Groups[s.Group]
+		if !ok {
+			return probe.Server{}, fmt.Errorf("Group %s of server %s is not defined", s.Group, s.Host)
+		}
+		if s.Type == "" {
+			s.Type = group.Type
+		}
+	}
+	if td, ok := cfg.Types[s.Type]; ok {
+		layers = append(layers, td)
+	}
+	if s.Group != "" {
+		layers = append(layers, group.Defaults)
+	}
+
+	var merged Defaults
+	for _, layer := range layers {
+		merged = overlay(merged, layer)
+	}
+
+	server := s.Server
+	merged = overlay(merged, Defaults{User: server.User, Password: server.Password, Key: server.Key, Credential: server.Credential})
+	server.User = merged.User
+	server.Password = merged.Password
+	server.Key = merged.Key
+	server.Credential = merged.Credential
+	if server.Port == 0 {
+		server.Port = merged.Port
+	}
+	if b, ok := probe.Lookup(server.Type); ok && server.Port == 0 {
+		// Default port of the backend is used if neither the server nor its defaults specify one
+		server.Port = b.Port
+	}
+	if server.Interval == 0 {
+		server.Interval = merged.Interval
+	}
+	if len(server.Metrics) == 0 {
+		server.Metrics = merged.Metrics
+	}
+
+	labels := map[string]string{}
+	for k, v := range merged.Labels {
+		labels[k] = v
+	}
+	for k, v := range s.Labels {
+		labels[k] = v
+	}
+	if len(labels) > 0 {
+		server.Labels = labels
+	} else {
+		server.Labels = nil
+	}
+
+	options := map[string]string{}
+	for k, v := range merged.Options {
+		options[k] = v
+	}
+	for k, v := range s.Options {
+		options[k] = v
+	}
+	if len(options) > 0 {
+		server.Options = options
+	} else {
+		server.Options = nil
+	}
+	return server, nil
+}
+
+// overlay apply settings of upper over lower. User, password and key are inherited separately, e.g., a server
+// setting its user only keeps the password of its group, while a credential referred by name replaces them.
+func overlay(lower, upper Defaults) Defaults {
+	ret := lower
+	switch {
+	case upper.Credential != "":
+		ret.User, ret.Password, ret.Key = "", "", ""
+		ret.Credential = upper.Credential
+	case upper.User != "" || upper.Password != "" || upper.Key != "":
+		ret.Credential = ""
+	}
+	if upper.User != "" {
+		ret.User = upper.User
+	}
+	if upper.Password != "" {
+		ret.Password = upper.Password
+	}
+	if upper.Key != "" {
+		ret.Key = upper.Key
+	}
+	if upper.Port != 0 {
+		ret.Port = upper.Port
+	}
+	if upper.Interval != 0 {
+		ret.Interval = upper.Interval
+	}
+	if len(upper.Metrics) > 0 {
+		ret.Metrics = upper.Metrics
+	}
+
+	labels := map[string]string{}
+	for k, v := range lower.Labels {
+		labels[k] = v
+	}
+	for k, v := range upper.Labels {
+		labels[k] = v
+	}
+	ret.Labels = labels
+
+	options := map[string]string{}
+	for k, v := range lower.Options {
+		options[k] = v
+	}
+	for k, v := range upper.Options {
+		options[k] = v
+	}
+	ret.Options = options
+	return ret
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestInheritCredentials(t *testing.T) {
+	cfg, err := Parse([]byte(`
+defaults:
+  user: root
+  password: default-password
+types:
+  windows:
+    user: Administrator
+groups:
+  db:
+    type: linux
+    password: db-password
+    key: /keys/db
+  vault:
+    type: linux
+    credential: lab-root
+servers:
+  - host: user-only
+    group: db
+    user: dba
+  - host: password-only
+    type: linux
+    password: own-password
+  - host: type-user
+    type: windows
+  - host: named-credential
+    group: db
+    credential: db-root
+  - host: inline-over-named
+    group: vault
+    password: own-password
+`), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers, err := cfg.Expand()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type creds struct{ User, Password, Key, Credential string }
+	want := map[string]creds{
+		"user-only":         {"dba", "db-password", "/keys/db", ""},
+		"password-only":     {"root", "own-password", "", ""},
+		"type-user":         {"Administrator", "default-password", "", ""},
+		"named-credential":  {"", "", "", "db-root"},
+		"inline-over-named": {"", "own-password", "", ""},
+	}
+	if len(servers) != len(want) {
+		t.Fatalf("got %d servers, want %d", len(servers), len(want))
+	}
+	for _, s := range servers {
+		got := creds{s.User, s.Password, s.Key, s.Credential}
+		if got != want[s.Host] {
+			t.Errorf("%s: got %+v, want %+v", s.Host, got, want[s.Host])
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// csvFields default mapping from CSV column names (case insensitive) to server fields,
+// other columns are mapped to labels, e.g., "Reserved By" is the reserved_by label
+var csvFields = map[string]string{
+	"host":       "host",
+	"hostname":   "host",
+	"fqdn":       "host",
+	"ip":         "host",
+	"address":    "host",
+	"user":       "user",
+	"username":   "user",
+	"password":   "password",
+	"key":        "key",
+	"port":       "port",
+	"type":       "type",
+	"os":         "type",
+	"credential": "credential",
+	"group":      "group",
+	"interval":   "interval",
+	"metrics":    "metrics",
+}
+
+// parseCSV parse servers from a CSV file with a header, columns maps column names to fields or labels
+func parseCSV(contents []byte, columns map[string]string) ([]Server, error) {
+	// Spreadsheet exports may start with a byte order mark
+	contents = bytes.TrimPrefix(contents, []byte("\xef\xbb\xbf"))
+
+	r := csv.NewReader(bytes.NewReader(contents))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("header is missing")
+	}
+
+	// Map each column to a field or a label
+	header := records[0]
+	targets := make([]string, len(header))
+	hasHost := false
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		target, ok := columns[name]
+		if !ok {
+			target, ok = csvFields[strings.ToLower(name)]
+		}
+		if !ok {
+			target = "label:" + labelName(name)
+		}
+		targets[i] = target
+		hasHost = hasHost || target == "host"
+	}
+	if !hasHost {
+		return nil, errors.New("no column is mapped to host")
+	}
+
+	var servers []Server
+	for n, record := range records[1:] {
+		var s Server
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if i >= len(targets) || value == "" || targets[i] == "-" {
+				continue
+			}
+			if err := setCSVField(&s, targets[i], value); err != nil {
+				return nil, fmt.Errorf("line %d: %s: %w", n+2, header[i], err)
+			}
+		}
+		// Blank rows are skipped
+		if s.Host == "" {
+			continue
+		}
+		servers = append(servers, s)
+	}
+	return servers, nil
+}
+
+// setCSVField set a field or a label of a server
+func setCSVField(s *Server, target, value string) error {
+	switch target {
+	case "host":
+		s.Host = value
+	case "user":
+		s.User = value
+	case "password":
+		s.Password = value
+	case "key":
+		s.Key = value
+	case "type":
+		s.Type = strings.ToLower(value)
+	case "credential":
+		s.Credential = value
+	case "group":
+		s.Group = value
+	case "port":
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid port %s", value)
+		}
+		s.Port = port
+	case "interval":
+		interval, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid interval %s", value)
+		}
+		s.Interval = interval
+	case "metrics":
+		s.Metrics = strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || r == ';' || r == ' '
+		})
+	default:
+		name := strings.TrimPrefix(target, "label:")
+		if name == "" {
+			return nil
+		}
+		if s.Labels == nil {
+			s.Labels = map[string]string{}
+		}
+		s.Labels[name] = value
+	}
+	return nil
+}
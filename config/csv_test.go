@@ -0,0 +1,64 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		columns map[string]string
+		want    []Server
+		err     string
+	}{
+		{
+			name: "default columns",
+			input: "\xef\xbb\xbfHostname,OS,Port,Group,Metrics,Reserved By\n" +
+				"web1, Linux ,2222,web,\"cpu,mem\",alice\n" +
+				",,,,,\n" +
+				"win1,windows,,,,\n",
+			want: []Server{
+				{
+					Server: probe.Server{Host: "web1", Type: "linux", Port: 2222, Metrics: []string{"cpu", "mem"}, Labels: map[string]string{"reserved_by": "alice"}},
+					Group:  "web",
+				},
+				{Server: probe.Server{Host: "win1", Type: "windows"}},
+			},
+		},
+		{
+			name:    "mapped and ignored columns",
+			input:   "Name,Management IP,Notes,Credential\nweb1,10.0.0.1,spare,lab\n",
+			columns: map[string]string{"Management IP": "host", "Name": "label:name", "Notes": "-"},
+			want: []Server{
+				{Server: probe.Server{Host: "10.0.0.1", Credential: "lab", Labels: map[string]string{"name": "web1"}}},
+			},
+		},
+		{name: "invalid port", input: "host,port\nweb1,22\nweb2,ssh\n", err: "line 3: port"},
+		{name: "invalid interval", input: "host,interval\nweb1,1m\n", err: "line 2: interval"},
+		{name: "no host column", input: "name,os\nweb1,linux\n", err: "no column is mapped to host"},
+		{name: "empty file", input: "", err: "header is missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCSV([]byte(tt.input), tt.columns)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want an error with %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
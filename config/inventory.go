@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Inventory an external host list imported as servers, e.g., an Ansible inventory or a CSV export:
+//
+//	inventories:
+//	  - path: /etc/ansible/hosts
+//	  - path: booking.csv
+//	    type: linux
+//	    columns:
+//	      Hostname: host
+//	      Reserved By: owner
+//
+// Servers defined in the configuration win over the imported ones with the same host.
+type Inventory struct {
+	Path string `json:"path" yaml:"path" toml:"path"`
+	// Format ansible or csv, based on the file extension if empty: .csv is csv and others are ansible
+	Format string `json:"format,omitempty" yaml:"format,omitempty" toml:"format,omitempty"`
+	// Type of hosts whose type cannot be determined from the inventory, linux by default
+	Type string `json:"type,omitempty" yaml:"type,omitempty" toml:"type,omitempty"`
+	// Columns CSV column names mapped to server fields or label names, - means ignored
+	Columns map[string]string `json:"columns,omitempty" yaml:"columns,omitempty" toml:"columns,omitempty"`
+}
+
+// format of the inventory
+func (inv Inventory) format() string {
+	if inv.Format != "" {
+		return inv.Format
+	}
+	if strings.ToLower(filepath.Ext(inv.Path)) == ".csv" {
+		return "csv"
+	}
+	return "ansible"
+}
+
+// load read servers from the inventory, relative paths are based on dir.
+// Servers are put into groups of the configuration with the same name as their Ansible groups.
+func (inv Inventory) load(dir string, groups map[string]Group) ([]Server, error) {
+	path := inv.Path
+	if !filepath.IsAbs(path) && dir != "" {
+		path = filepath.Join(dir, path)
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []Server
+	switch inv.format() {
+	case "ansible":
+		var ai *ansibleInventory
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+			ai, err = parseAnsibleYAML(contents)
+		default:
+			ai, err = parseAnsibleINI(contents)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		servers = ai.servers(groups)
+	case "csv":
+		servers, err = parseCSV(contents, inv.Columns)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("Inventory format %s is not supported", inv.Format)
+	}
+
+	return defaultType(servers, groups, inv.Type), nil
+}
+
+// defaultType set the type of servers whose type is neither in the inventory nor inherited from their group
+func defaultType(servers []Server, groups map[string]Group, t string) []Server {
+	if t == "" {
+		t = "linux"
+	}
+	for i, s := range servers {
+		if s.Type != "" {
+			continue
+		}
+		if g, ok := groups[s.Group]; ok && g.Type != "" {
+			continue
+		}
+		servers[i].Type = t
+	}
+	return servers
+}
+
+// labelRe characters not allowed in label names
+var labelRe = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// labelName turn a column or variable name into a label name, e.g., "Reserved By" is reserved_by
+func labelName(name string) string {
+	return strings.Trim(labelRe.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "_"), "_")
+}
+
+// hostGroup pick the first group defined in the configuration, sorted by name
+func hostGroup(names []string, groups map[string]Group) string {
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		if _, ok := groups[name]; ok {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,485 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kckecheng/osprobe/credential"
+	"github.com/kckecheng/osprobe/probe"
+	"github.com/kckecheng/osprobe/secret"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem a problem found in a configuration, Line is 0 if it cannot be located
+type Problem struct {
+	Line    int    `json:"line,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	var parts []string
+	if p.Line > 0 {
+		parts = append(parts, fmt.Sprintf("line %d", p.Line))
+	}
+	if p.Field != "" {
+		parts = append(parts, p.Field)
+	}
+	return strings.Join(append(parts, p.Message), ": ")
+}
+
+// validator collect problems and locate fields in the configuration
+type validator struct {
+	lines    map[string]int
+	problems []Problem
+}
+
+// Validate check a configuration file and report every problem found with its line and field.
+// Credentials referred by name are checked against db if it is not nil.
+func Validate(path string, db *credential.DB) ([]Problem, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := Format(path)
+	v := &validator{lines: map[string]int{}}
+
+	cfg, err := Parse(contents, format)
+	if err != nil {
+		v.syntax(contents, err)
+		return v.problems, nil
+	}
+	cfg.dir = filepath.Dir(path)
+
+	legacy := format == "json" && bytes.HasPrefix(bytes.TrimSpace(contents), []byte("["))
+	switch format {
+	case "json", "yaml":
+		// JSON is a subset of YAML, the node tree provides lines of both
+		var root yaml.Node
+		if err := yaml.Unmarshal(contents, &root); err == nil && len(root.Content) > 0 {
+			v.locate(root.Content[0], "")
+			v.unknown(root.Content[0], legacy)
+		}
+	case "toml":
+		var tmp Config
+		md, err := toml.Decode(string(contents), &tmp)
+		if err == nil {
+			for _, k := range md.Undecoded() {
+				v.report(k.String(), "unknown field")
+			}
+		}
+	}
+
+	// Servers of inventories used directly are reported by host
+	prefix := "servers"
+	switch {
+	case legacy:
+		prefix = ""
+	case format == "ansible" || format == "csv":
+		prefix = "host"
+	}
+	v.check(cfg, prefix, db)
+	return v.problems, nil
+}
+
+func (v *validator) report(field, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{Line: v.line(field), Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// line find the line of a field, the line of the closest parent is used if the field is missing
+func (v *validator) line(field string) int {
+	for field != "" {
+		if l, ok := v.lines[field]; ok {
+			return l
+		}
+		i := strings.LastIndexAny(field, ".[")
+		if i < 0 {
+			break
+		}
+		field = field[:i]
+	}
+	return 0
+}
+
+var lineRe = regexp.MustCompile(`line (\d+): (.*)`)
+
+// syntax report a parse error with its line
+func (v *validator) syntax(contents []byte, err error) {
+	line := 0
+	var jsonSyntax *json.SyntaxError
+	var jsonType *json.UnmarshalTypeError
+	var tomlErr toml.ParseError
+	var yamlType *yaml.TypeError
+	switch {
+	case errors.As(err, &jsonSyntax):
+		line = bytes.Count(contents[:jsonSyntax.Offset], []byte("\n")) + 1
+	case errors.As(err, &jsonType):
+		line = bytes.Count(contents[:jsonType.Offset], []byte("\n")) + 1
+	case errors.As(err, &tomlErr):
+		line = tomlErr.Position.Line
+	case errors.As(err, &yamlType):
+		for _, e := range yamlType.Errors {
+			v.problems = append(v.problems, yamlProblem(e))
+		}
+		return
+	default:
+		p := yamlProblem(err.Error())
+		line = p.Line
+	}
+	v.problems = append(v.problems, Problem{Line: line, Message: err.Error()})
+}
+
+func yamlProblem(msg string) Problem {
+	m := lineRe.FindStringSubmatch(msg)
+	if m == nil {
+		return Problem{Message: msg}
+	}
+	line, _ := strconv.Atoi(m[1])
+	return Problem{Line: line, Message: m[2]}
+}
+
+// locate record lines of all fields, e.g., servers[0].port
+func (v *validator) locate(node *yaml.Node, path string) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			p := join(path, node.Content[i].Value)
+			v.lines[p] = node.Content[i].Line
+			v.locate(node.Content[i+1], p)
+		}
+	case yaml.SequenceNode:
+		for i, c := range node.Content {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			v.lines[p] = c.Line
+			v.locate(c, p)
+		}
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// unknown report fields which are ignored silently by the decoders, e.g., typos
+func (v *validator) unknown(root *yaml.Node, legacy bool) {
+	defaultKeys := fieldNames(reflect.TypeOf(Defaults{}))
+	groupKeys := fieldNames(reflect.TypeOf(Group{}))
+	serverKeys := fieldNames(reflect.TypeOf(Server{}))
+
+	if legacy {
+		v.unknownKeys(root, "", serverKeys, true)
+		return
+	}
+
+	v.unknownKeys(root, "", fieldNames(reflect.TypeOf(Config{})), false)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i].Value, root.Content[i+1]
+		switch key {
+		case "defaults":
+			v.unknownKeys(value, key, defaultKeys, false)
+		case "types":
+			v.unknownKeys(value, key, defaultKeys, true)
+		case "groups":
+			v.unknownKeys(value, key, groupKeys, true)
+		case "servers":
+			v.unknownKeys(value, key, serverKeys, true)
+		}
+	}
+}
+
+// unknownKeys check keys of a mapping, or keys of each element if nested is set
+func (v *validator) unknownKeys(node *yaml.Node, path string, known map[string]bool, nested bool) {
+	if nested {
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				v.unknownKeys(node.Content[i+1], join(path, node.Content[i].Value), known, false)
+			}
+		case yaml.SequenceNode:
+			for i, c := range node.Content {
+				v.unknownKeys(c, fmt.Sprintf("%s[%d]", path, i), known, false)
+			}
+		}
+		return
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if key := node.Content[i].Value; !known[key] {
+			v.report(join(path, key), "unknown field")
+		}
+	}
+}
+
+// fieldNames json names of struct fields, fields of embedded structs included
+func fieldNames(t reflect.Type) map[string]bool {
+	ret := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for k := range fieldNames(f.Type) {
+				ret[k] = true
+			}
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		ret[name] = true
+	}
+	return ret
+}
+
+// check validate settings and servers
+func (v *validator) check(cfg *Config, prefix string, db *credential.DB) {
+	if cfg.Interval < 0 {
+		v.report("interval", "interval %d is invalid", cfg.Interval)
+	}
+	v.checkDefaults("defaults", cfg.Defaults)
+
+	var names []string
+	for name := range cfg.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := probe.Lookup(name); !ok {
+			v.report(join("types", name), "type %s is not supported, valid types are %s", name, strings.Join(probe.Types(), ", "))
+		}
+		v.checkDefaults(join("types", name), cfg.Types[name])
+	}
+
+	names = nil
+	for name := range cfg.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Hosts are checked in the same order as they are expanded, explicit server definitions win
+	seen := map[string]string{}
+	listed := map[string]bool{}
+	for _, s := range cfg.Servers {
+		listed[s.Host] = true
+	}
+	for _, name := range names {
+		path := join("groups", name)
+		group := cfg.Groups[name]
+		v.checkDefaults(path, group.Defaults)
+		if _, ok := probe.Lookup(group.Type); group.Type != "" && !ok {
+			v.report(join(path, "type"), "type %s is not supported, valid types are %s", group.Type, strings.Join(probe.Types(), ", "))
+		}
+
+		for i, host := range group.Hosts {
+			hpath := fmt.Sprintf("%s.hosts[%d]", path, i)
+			if host == "" {
+				v.report(hpath, "host is missing")
+				continue
+			}
+			if listed[host] {
+				continue
+			}
+			v.checkDuplicate(seen, host, hpath)
+			s := Server{Server: probe.Server{Host: host}, Group: name}
+			v.checkServer(cfg, s, hpath, db)
+		}
+	}
+
+	// Imported servers are reported as a whole since their fields cannot be located
+	for i, inv := range cfg.Inventories {
+		path := fmt.Sprintf("inventories[%d]", i)
+		servers, err := inv.load(cfg.dir, cfg.Groups)
+		if err != nil {
+			v.report(join(path, "path"), "%s", err)
+			continue
+		}
+		for _, s := range servers {
+			if listed[s.Host] {
+				continue
+			}
+			hpath := fmt.Sprintf("%s[%s]", path, s.Host)
+			if !v.checkDuplicate(seen, s.Host, hpath) {
+				continue
+			}
+			v.checkDefaults(hpath, Defaults{
+				Password: s.Password,
+				Key:      s.Key,
+				Port:     s.Port,
+				Interval: s.Interval,
+				Metrics:  s.Metrics,
+				Options:  s.Options,
+			})
+			v.checkServer(cfg, s, hpath, db)
+		}
+	}
+
+	for i, s := range cfg.Servers {
+		path := fmt.Sprintf("%s[%d]", prefix, i)
+		if prefix == "host" {
+			path = fmt.Sprintf("host[%s]", s.Host)
+		}
+		if s.Host == "" {
+			v.report(path+".host", "host is missing")
+		} else {
+			v.checkDuplicate(seen, s.Host, path+".host")
+		}
+		v.checkDefaults(path, Defaults{
+			Password: s.Password,
+			Key:      s.Key,
+			Port:     s.Port,
+			Interval: s.Interval,
+			Metrics:  s.Metrics,
+			Options:  s.Options,
+		})
+		v.checkServer(cfg, s, path+".", db)
+	}
+}
+
+// checkDuplicate report hosts defined more than once, false is returned for duplicates
+func (v *validator) checkDuplicate(seen map[string]string, host, path string) bool {
+	if first, ok := seen[host]; ok {
+		if l := v.line(first); l > 0 {
+			v.report(path, "duplicate host %s, first defined at %s (line %d)", host, first, l)
+		} else {
+			v.report(path, "duplicate host %s, first defined at %s", host, first)
+		}
+		return false
+	}
+	seen[host] = path
+	return true
+}
+
+// checkServer check a server with inherited settings applied, fields are reported as <prefix><field>.
+// Servers listed in groups have no fields of their own, the host entry is reported instead.
+func (v *validator) checkServer(cfg *Config, s Server, prefix string, db *credential.DB) {
+	field := func(name string) string {
+		if strings.HasSuffix(prefix, ".") {
+			return prefix + name
+		}
+		return prefix
+	}
+
+	server, err := cfg.inherit(s)
+	if err != nil {
+		v.report(field("group"), "group %s is not defined", s.Group)
+		return
+	}
+
+	if server.Type == "" {
+		v.report(field("type"), "type is missing")
+	} else if _, ok := probe.Lookup(server.Type); !ok {
+		v.report(field("type"), "type %s is not supported, valid types are %s", server.Type, strings.Join(probe.Types(), ", "))
+	}
+	if isLocal(server.Type) {
+		return
+	}
+	if server.Port == 0 {
+		v.report(field("port"), "port is missing")
+	}
+
+	switch {
+	case server.Credential != "":
+		if db == nil {
+			break
+		}
+		c, ok := db.Get(server.Credential)
+		if !ok {
+			v.report(field("credential"), "credential %s is not defined", server.Credential)
+		} else if c.User == "" && !userOptional(server) {
+			v.report(field("credential"), "credential %s has no user, which is required", server.Credential)
+		}
+	case server.User == "" && !userOptional(server):
+		v.report(field("user"), "user is missing")
+	case server.Password == "" && server.Key == "":
+		v.report(field("password"), "password or key is missing")
+	}
+}
+
+// checkDefaults check settings which can be inherited, only fields set are checked
+func (v *validator) checkDefaults(path string, d Defaults) {
+	if d.Port < 0 || d.Port > 65535 {
+		v.report(join(path, "port"), "port %d is out of range", d.Port)
+	}
+	if d.Interval < 0 {
+		v.report(join(path, "interval"), "interval %d is invalid", d.Interval)
+	}
+	metrics := metricNames()
+	for i, m := range d.Metrics {
+		known := false
+		for _, k := range metrics {
+			known = known || m == k
+		}
+		if !known {
+			v.report(fmt.Sprintf("%s.metrics[%d]", path, i), "metric %s is not supported, valid metrics are %s", m, strings.Join(metrics, ", "))
+		}
+	}
+
+	// Secrets are resolved the same way as probing, resolved values are never reported
+	if _, err := secret.Resolve(d.Password); err != nil {
+		v.report(join(path, "password"), "%s", err)
+	}
+	var options []string
+	for k := range d.Options {
+		options = append(options, k)
+	}
+	sort.Strings(options)
+	for _, k := range options {
+		if _, err := secret.Resolve(d.Options[k]); err != nil {
+			v.report(join(join(path, "options"), k), "%s", err)
+		}
+	}
+	if d.Key != "" {
+		key, err := secret.Resolve(d.Key)
+		if err != nil {
+			v.report(join(path, "key"), "%s", err)
+		} else if !secret.IsRef(d.Key) && !strings.Contains(key, "PRIVATE KEY") {
+			if _, err := os.Stat(key); err != nil {
+				v.report(join(path, "key"), "key file %s is not accessible", key)
+			}
+		}
+	}
+}
+
+// metricNames metrics supported by any registered backend
+func metricNames() []string {
+	seen := map[string]bool{}
+	var ret []string
+	for _, b := range probe.Backends() {
+		for _, m := range b.Metrics {
+			if !seen[m] {
+				seen[m] = true
+				ret = append(ret, m)
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// userOptional check if the backend of a server authenticates it without a user, e.g., depending on its options
+func userOptional(server probe.Server) bool {
+	b, ok := probe.Lookup(server.Type)
+	return ok && b.OptionalUser(server)
+}
+
+// isLocal check if the backend of a type probes the machine osprobe runs on
+func isLocal(t string) bool {
+	b, ok := probe.Lookup(t)
+	return ok && b.Local
+}
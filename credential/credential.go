@@ -0,0 +1,251 @@
+package credential
+
+/*
+	Credential database shared by scanner and osprobe, a json array as below:
+	[
+		{
+			"name": "lab-root",
+			"type": "linux",
+			"user": "root",
+			"password": "password",
+			"scopes": ["192.168.10.0/24", "*.lab.example"]
+		},
+		{
+			"name": "lab-key",
+			"type": "linux",
+			"user": "auto",
+			"key": "/home/auto/.ssh/id_rsa"
+		}
+	]
+
+	The legacy format is still accepted, passwords are split on the first colon:
+	{
+		"linux": ["user1:password1", "user2:password2", ...],
+		"windows": [...],
+		"esxi": [...]
+	}
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+// Credential to access servers of a type
+type Credential struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"` // a registered server type, e.g., linux, windows, or esxi
+	User     string   `json:"user"`
+	Password string   `json:"password,omitempty"`
+	Key      string   `json:"key,omitempty"`    // path of a SSH private key
+	Scopes   []string `json:"scopes,omitempty"` // CIDR blocks, IPs or hostname globs, empty means all hosts
+}
+
+// Valid make sure all required fields are set, user is optional for types not requiring it (e.g., snmp)
+func (c Credential) Valid() error {
+	b, _ := probe.Lookup(c.Type)
+	switch {
+	case c.Name == "":
+		return errors.New("Credential name is required")
+	case c.Type == "":
+		return fmt.Errorf("Credential %s: type is required", c.Name)
+	case c.User == "" && !b.UserOptional:
+		return fmt.Errorf("Credential %s: user is required", c.Name)
+	case c.Password == "" && c.Key == "":
+		return fmt.Errorf("Credential %s: password or key is required", c.Name)
+	}
+
+	for _, scope := range c.Scopes {
+		if strings.Contains(scope, "/") {
+			if _, _, err := net.ParseCIDR(scope); err != nil {
+				return fmt.Errorf("Credential %s: invalid scope %s", c.Name, scope)
+			}
+		} else if _, err := path.Match(scope, ""); err != nil {
+			return fmt.Errorf("Credential %s: invalid scope %s", c.Name, scope)
+		}
+	}
+	return nil
+}
+
+// InScope check if a credential can be used for a host
+func (c Credential) InScope(host string) bool {
+	return c.specificity(host) >= 0
+}
+
+// specificity how closely a credential is scoped to a host, -1 for a host out of scope: a host or IP named
+// exactly beats a CIDR block (the longer the prefix the better), which beats a glob, which beats no scope
+func (c Credential) specificity(host string) int {
+	if len(c.Scopes) == 0 {
+		return 0
+	}
+
+	best := -1
+	ip := net.ParseIP(host)
+	for _, scope := range c.Scopes {
+		score := -1
+		switch {
+		case strings.Contains(scope, "/"):
+			_, ipnet, err := net.ParseCIDR(scope)
+			if err == nil && ip != nil && ipnet.Contains(ip) {
+				ones, _ := ipnet.Mask.Size()
+				score = 2 + ones
+			}
+		case !strings.ContainsAny(scope, "*?[\\"):
+			if strings.EqualFold(scope, host) {
+				score = 256
+			}
+		default:
+			if ok, _ := path.Match(strings.ToLower(scope), strings.ToLower(host)); ok {
+				score = 1
+			}
+		}
+		if score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// DB credential database
+type DB struct {
+	Credentials []Credential
+}
+
+// Load load a credential database json
+func Load(path string) (*DB, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(contents)
+}
+
+// Parse parse a credential database in json
+func Parse(contents []byte) (*DB, error) {
+	var creds []Credential
+	if err := json.Unmarshal(contents, &creds); err != nil {
+		var legacy map[string][]string
+		if lerr := json.Unmarshal(contents, &legacy); lerr != nil {
+			return nil, err
+		}
+		creds, err = fromLegacy(legacy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	names := map[string]bool{}
+	for _, c := range creds {
+		if err := c.Valid(); err != nil {
+			return nil, err
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("Credential %s is defined more than once", c.Name)
+		}
+		names[c.Name] = true
+	}
+	return &DB{Credentials: creds}, nil
+}
+
+// fromLegacy convert the "user:password" format, credentials are named as <type>-<index>
+func fromLegacy(legacy map[string][]string) ([]Credential, error) {
+	var types []string
+	for t := range legacy {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	var creds []Credential
+	for _, t := range types {
+		for i, upcomb := range legacy[t] {
+			// Passwords may contain colons but user names do not
+			up := strings.SplitN(upcomb, ":", 2)
+			if len(up) != 2 {
+				return nil, fmt.Errorf("Credential %d of %s is not in the format user:password", i+1, t)
+			}
+			creds = append(creds, Credential{
+				Name:     fmt.Sprintf("%s-%d", t, i+1),
+				Type:     t,
+				User:     up[0],
+				Password: up[1],
+			})
+		}
+	}
+	return creds, nil
+}
+
+// Get find a credential by name
+func (db *DB) Get(name string) (Credential, bool) {
+	for _, c := range db.Credentials {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Credential{}, false
+}
+
+// Match list credentials of a type which can be used for a host, the most specific scope first, credentials
+// equally specific are kept in the order they are defined
+func (db *DB) Match(osType, host string) []Credential {
+	type scored struct {
+		Credential
+		score int
+	}
+	var matched []scored
+	for _, c := range db.Credentials {
+		if score := c.specificity(host); c.Type == osType && score >= 0 {
+			matched = append(matched, scored{c, score})
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].score > matched[j].score })
+
+	var ret []Credential
+	for _, m := range matched {
+		ret = append(ret, m.Credential)
+	}
+	return ret
+}
+
+// Put add a credential or replace the one with the same name
+func (db *DB) Put(c Credential) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+
+	for i := range db.Credentials {
+		if db.Credentials[i].Name == c.Name {
+			db.Credentials[i] = c
+			return nil
+		}
+	}
+	db.Credentials = append(db.Credentials, c)
+	return nil
+}
+
+// Merge add credentials of another database which are not defined in db, credentials of db win
+func (db *DB) Merge(other *DB) {
+	for _, c := range other.Credentials {
+		if _, ok := db.Get(c.Name); !ok {
+			db.Credentials = append(db.Credentials, c)
+		}
+	}
+}
+
+// Remove delete a credential by name, false is returned if it does not exist
+func (db *DB) Remove(name string) bool {
+	for i, c := range db.Credentials {
+		if c.Name == name {
+			db.Credentials = append(db.Credentials[:i], db.Credentials[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
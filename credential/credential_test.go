@@ -0,0 +1,86 @@
+package credential
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		host   string
+		want   bool
+	}{
+		{"no scope", nil, "10.0.0.1", true},
+		{"exact IP", []string{"10.0.0.1"}, "10.0.0.1", true},
+		{"other IP", []string{"10.0.0.1"}, "10.0.0.2", false},
+		{"exact host ignoring case", []string{"DB1.lab.example"}, "db1.LAB.example", true},
+		{"in CIDR", []string{"192.168.10.0/24"}, "192.168.10.7", true},
+		{"out of CIDR", []string{"192.168.10.0/24"}, "192.168.11.7", false},
+		{"hostname against CIDR", []string{"192.168.10.0/24"}, "db1.lab.example", false},
+		{"glob", []string{"*.lab.example"}, "db1.lab.example", true},
+		{"glob of another domain", []string{"*.lab.example"}, "db1.prod.example", false},
+		{"any of several scopes", []string{"192.168.10.0/24", "*.lab.example"}, "db1.lab.example", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Credential{Name: "c", Type: "linux", User: "root", Password: "p", Scopes: tt.scopes}
+			if got := c.InScope(tt.host); got != tt.want {
+				t.Errorf("InScope(%s) with %v = %v, want %v", tt.host, tt.scopes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	db, err := Parse([]byte(`[
+		{"name": "any", "type": "linux", "user": "root", "password": "p"},
+		{"name": "glob", "type": "linux", "user": "root", "password": "p", "scopes": ["*.lab.example"]},
+		{"name": "subnet", "type": "linux", "user": "root", "password": "p", "scopes": ["192.168.0.0/16"]},
+		{"name": "rack", "type": "linux", "user": "root", "password": "p", "scopes": ["192.168.10.0/24"]},
+		{"name": "host", "type": "linux", "user": "root", "password": "p", "scopes": ["192.168.10.7", "db1.lab.example"]},
+		{"name": "any-2", "type": "linux", "user": "root", "password": "p"},
+		{"name": "windows", "type": "windows", "user": "admin", "password": "p"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		typ  string
+		host string
+		want []string
+	}{
+		{"host before CIDR blocks, longer prefix first", "linux", "192.168.10.7", []string{"host", "rack", "subnet", "any", "any-2"}},
+		{"CIDR block", "linux", "192.168.20.7", []string{"subnet", "any", "any-2"}},
+		{"host before glob", "linux", "db1.lab.example", []string{"host", "glob", "any", "any-2"}},
+		{"glob", "linux", "db2.lab.example", []string{"glob", "any", "any-2"}},
+		{"unscoped only", "linux", "10.0.0.1", []string{"any", "any-2"}},
+		{"other type", "windows", "192.168.10.7", []string{"windows"}},
+		{"no credential", "esxi", "192.168.10.7", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, c := range db.Match(tt.typ, tt.host) {
+				got = append(got, c.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match(%s, %s) = %v, want %v", tt.typ, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	db := &DB{Credentials: []Credential{{Name: "lab-root", Type: "linux", User: "root", Password: "p"}}}
+
+	if c, ok := db.Get("lab-root"); !ok || c.User != "root" {
+		t.Errorf("Get(lab-root) = %+v, %v", c, ok)
+	}
+	if c, ok := db.Get("missing"); ok || !reflect.DeepEqual(c, Credential{}) {
+		t.Errorf("Get(missing) = %+v, %v, want no credential", c, ok)
+	}
+}
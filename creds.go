@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kckecheng/osprobe/credential"
+	"github.com/kckecheng/osprobe/probe"
+	"github.com/kckecheng/osprobe/secret"
+	"github.com/kckecheng/osprobe/vault"
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const defaultVault = "osprobe.vault"
+
+// openVault decrypt the vault with the passphrase from the environment or a prompt,
+// the prompt is confirmed if the vault is created
+func openVault(path string) (*vault.Vault, error) {
+	passphrase, err := vault.Passphrase(!vault.Exists(path))
+	if err != nil {
+		return nil, err
+	}
+	return vault.Open(path, passphrase)
+}
+
+// registerVault resolve vault:<name> secret references with passwords in the vault
+func registerVault(v *vault.Vault) {
+	secret.Register("vault", func(name string) (string, error) {
+		c, ok := v.DB().Get(name)
+		if !ok {
+			return "", errors.New("credential is not defined in the vault")
+		}
+		if c.Password != "" {
+			return c.Password, nil
+		}
+		return c.Key, nil
+	})
+}
+
+// runCreds manage the encrypted credential vault, the return value is used as the exit code
+func runCreds(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "Usage: osprobe creds add|list|rm [options]")
+	}
+	if len(args) == 0 {
+		usage()
+		return 1
+	}
+
+	var path string
+	var stdin bool
+	var c credential.Credential
+	fs := flag.NewFlagSet("creds "+args[0], flag.ExitOnError)
+	fs.StringVarP(&path, "vault", "V", defaultVault, "Encrypted credential vault, can be overwritten by setting OSPROBE_VAULT")
+	if args[0] == "add" {
+		fs.StringVarP(&c.Name, "name", "n", "", "Credential name")
+		fs.StringVarP(&c.Type, "type", "t", "", "Server type: "+strings.Join(probe.Types(), ", "))
+		fs.StringVarP(&c.User, "user", "u", "", "User name")
+		fs.BoolVar(&stdin, "password-stdin", false, "Read the password from stdin, it is prompted otherwise unless a key is specified")
+		fs.StringVarP(&c.Key, "key", "k", "", "Path of a SSH private key")
+		fs.StringSliceVarP(&c.Scopes, "scope", "s", nil, "CIDR blocks, IPs or hostname globs the credential is limited to")
+	}
+	fs.Parse(args[1:])
+
+	epath := getEnvVar("OSPROBE_VAULT")
+	if epath != "" {
+		path = epath
+	}
+
+	v, err := openVault(path)
+	if err != nil {
+		log.Errorf("Fail to open vault %s due to %s", path, err)
+		return 1
+	}
+
+	switch args[0] {
+	case "add":
+		// Passwords are never taken from arguments which are visible to other users, e.g., with ps
+		if stdin {
+			password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && err != io.EOF {
+				log.Errorf("Fail to read the password due to %s", err)
+				return 1
+			}
+			c.Password = strings.TrimRight(password, "\r\n")
+		} else if c.Key == "" {
+			fd := int(os.Stdin.Fd())
+			if terminal.IsTerminal(fd) {
+				fmt.Fprintf(os.Stderr, "Password for %s: ", c.Name)
+				password, err := terminal.ReadPassword(fd)
+				fmt.Fprintln(os.Stderr)
+				if err != nil {
+					log.Errorf("Fail to read the password due to %s", err)
+					return 1
+				}
+				c.Password = string(password)
+			}
+		}
+		if err := v.DB().Put(c); err != nil {
+			log.Error(err)
+			return 1
+		}
+	case "rm":
+		if fs.NArg() == 0 {
+			usage()
+			return 1
+		}
+		for _, name := range fs.Args() {
+			if !v.DB().Remove(name) {
+				log.Errorf("Credential %s does not exist", name)
+				return 1
+			}
+		}
+	case "list":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tTYPE\tUSER\tAUTH\tSCOPES")
+		for _, c := range v.DB().Credentials {
+			auth := "password"
+			if c.Key != "" {
+				auth = "key"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Name, c.Type, c.User, auth, strings.Join(c.Scopes, ","))
+		}
+		tw.Flush()
+		return 0
+	default:
+		usage()
+		return 1
+	}
+
+	if err := v.Save(); err != nil {
+		log.Errorf("Fail to save vault %s due to %s", path, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kckecheng/osprobe/credential"
+	"github.com/kckecheng/osprobe/secret"
+	"github.com/kckecheng/osprobe/vault"
+)
+
+func TestRegisterVault(t *testing.T) {
+	v, err := vault.Open(filepath.Join(t.TempDir(), defaultVault), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []credential.Credential{
+		{Name: "lab-root", Type: "linux", User: "root", Password: "pass:word"},
+		{Name: "lab-key", Type: "linux", User: "auto", Key: "/home/auto/.ssh/id_rsa"},
+	} {
+		if err := v.DB().Put(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registerVault(v)
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{"vault:lab-root", "pass:word", false},
+		{"vault:lab-key", "/home/auto/.ssh/id_rsa", false},
+		{"vault:missing", "", true},
+	}
+	for _, tt := range tests {
+		got, err := secret.Resolve(tt.ref)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Resolve(%s) = %q, %v, want %q, error %v", tt.ref, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
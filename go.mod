@@ -3,11 +3,15 @@ module github.com/kckecheng/osprobe
 go 1.15
 
 require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/gosnmp/gosnmp v1.32.0
 	github.com/masterzen/winrm v0.0.0-20200910070334-9a59535f8f2a
 	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/common v0.10.0
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/pflag v1.0.5
 	github.com/vmware/govmomi v0.23.1
 	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
 	golang.org/x/text v0.3.2 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -3,8 +3,10 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -12,11 +14,13 @@ import (
 	"time"
 
 	"github.com/kckecheng/osprobe/collector"
+	"github.com/kckecheng/osprobe/config"
+	"github.com/kckecheng/osprobe/credential"
 	"github.com/kckecheng/osprobe/probe"
-	"github.com/kckecheng/osprobe/probe/linux"
-	"github.com/kckecheng/osprobe/probe/vmware"
-	"github.com/kckecheng/osprobe/probe/windows"
+	_ "github.com/kckecheng/osprobe/probe/all"
+	"github.com/kckecheng/osprobe/report"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/client_golang/prometheus/push"
 	log "github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
@@ -30,6 +34,54 @@ func getEnvVar(name string) string {
 	return strings.TrimSpace(v)
 }
 
+// newServerCollector init the collector and resolve credentials referred by name,
+// credentials in the vault are decrypted at startup
+func newServerCollector(conf *config.Config, creds, vpath string) *collector.ServerCollector {
+	servers, err := conf.Expand()
+	if err != nil {
+		log.Fatalf("Fail to expand the configuration due to %s", err)
+	}
+
+	sc := collector.New(servers)
+	if creds == "" && vpath == "" {
+		return sc
+	}
+
+	// Credentials are resolved once against both stores, the credential file wins over the vault
+	db := &credential.DB{}
+	if creds != "" {
+		db, err = credential.Load(creds)
+		if err != nil {
+			log.Fatalf("Fail to load credentials %s due to %s", creds, err)
+		}
+	}
+	if vpath != "" {
+		v, err := openVault(vpath)
+		if err != nil {
+			log.Fatalf("Fail to open vault %s due to %s", vpath, err)
+		}
+		registerVault(v)
+		db.Merge(v.DB())
+	}
+	sc.ResolveCredentials(db)
+	return sc
+}
+
+// localConfig probe the machine osprobe runs on instead of the configured servers, settings such as
+// the job and the defaults of the local type still apply
+func localConfig(conf *config.Config) *config.Config {
+	host, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("Fail to get the hostname due to %s", err)
+	}
+
+	local := *conf
+	local.Groups = nil
+	local.Inventories = nil
+	local.Servers = []config.Server{{Server: probe.Server{Host: host, Type: "local"}}}
+	return &local
+}
+
 func deleteJob(pusher *push.Pusher, gateway, job string) {
 	log.Debugf("Delete job %s from pushgateway %s", job, gateway)
 
@@ -39,101 +91,236 @@ func deleteJob(pusher *push.Pusher, gateway, job string) {
 	}
 }
 
-func refreshMetrics(sc *collector.ServerCollector, interval int64, pdone chan int) {
-	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+// probeServer run one round of probe against a server
+func probeServer(server probe.Server) collector.Result {
+	result := collector.NewResult(server)
+
+	// The machine osprobe runs on is always online
+	backend, _ := probe.Lookup(server.Type)
+	if !backend.Local && !server.Online() {
+		log.Errorf("Server %s is offline", server.Host)
+		result.Fail("online", probe.ReasonOffline, errors.New("Server is offline"))
+		return result
+	}
+	result.Online = true
+
+	// Secrets are resolved right before connecting and never logged
+	server, err := server.Resolve()
+	if err != nil {
+		log.Errorf("Fail to resolve secrets for server %s: %s", server.Host, err)
+		result.Fail("accessible", probe.Reason(err), err)
+		return result
+	}
+
+	log.Debug("Create connection to server:", server.Host)
+	p, err := probe.Connect(server)
+	if err != nil {
+		log.Error("Fail to connect to server:", server.Host)
+		result.Fail("accessible", probe.Reason(err), err)
+		return result
+	}
+	defer p.Close()
+	result.Accessible = true
+
+	// Only metrics enabled for the server and supported by its backend are gathered
+	if server.Enabled("cpu") && backend.Supports("cpu") {
+		log.Debug("Gather CPU usage for server:", server.Host)
+		cpuUsage, err := p.GetCPUUsage()
+		if err != nil {
+			log.Error("Fail to probe CPU usage", err)
+			result.Fail("cpu_utilization", probe.Reason(err), err)
+		} else {
+			result.Set("cpu_utilization", cpuUsage)
+		}
+	}
+
+	if server.Enabled("mem") && backend.Supports("mem") {
+		log.Debug("Gather memory usage for server:", server.Host)
+		memUsage, err := p.GetMemUsage()
+		if err != nil {
+			log.Error("Fail to probe memory usage", err)
+			result.Fail("mem_utilization", probe.Reason(err), err)
+		} else {
+			result.Set("mem_utilization", memUsage)
+		}
+	}
+
+	if server.Enabled("nic") && backend.Supports("nic") {
+		log.Debug("Gather NIC usage for server:", server.Host)
+		nics, err := p.GetNICUsage()
+		if err != nil {
+			log.Error("Fail to probe NIC usage", err)
+			result.Fail("nic_received_bytes", probe.Reason(err), err)
+			result.Fail("nic_sent_bytes", probe.Reason(err), err)
+		} else {
+			var received, sent float64
+			for name, nic := range nics {
+				if name == "lo" || strings.HasPrefix(strings.ToLower(name), "loopback") {
+					continue
+				}
+				received += nic["received"]
+				sent += nic["sent"]
+			}
+			result.Set("nic_received_bytes", received)
+			result.Set("nic_sent_bytes", sent)
+		}
+	}
+
+	if e, ok := p.(probe.Extended); ok {
+		log.Debug("Gather backend specific metrics for server:", server.Host)
+		metrics, err := e.GetMetrics()
+		if err != nil {
+			log.Error("Fail to probe backend specific metrics", err)
+			result.Fail("extra", probe.Reason(err), err)
+		}
+		result.Extra = metrics
+	}
+	return result
+}
+
+// saveHistory record the latest results to the local history and drop the expired ones. Results kept from an
+// earlier round (servers with a longer interval) are skipped, saved tracks the timestamp last saved for each server.
+func saveHistory(sc *collector.ServerCollector, history string, retention int, saved map[string]time.Time) {
+	sc.Mutex.Lock()
+	var results []collector.Result
+	for _, r := range sc.Results {
+		if !r.Timestamp.After(saved[collector.Key(r.Host, r.Type)]) {
+			continue
+		}
+		results = append(results, r)
+	}
+	sc.Mutex.Unlock()
+
+	if err := report.AppendHistory(history, results); err != nil {
+		log.Errorf("Fail to save history to %s due to %s", history, err)
+		return
+	}
+	for _, r := range results {
+		saved[collector.Key(r.Host, r.Type)] = r.Timestamp
+	}
+	if err := report.PruneHistory(history, time.Now().AddDate(0, 0, -retention)); err != nil {
+		log.Errorf("Fail to prune history %s due to %s", history, err)
+	}
+}
+
+func refreshMetrics(sc *collector.ServerCollector, interval int64, samples int, pdone chan int) {
+	// Samples are taken evenly during the interval and summarized when a round is done
+	tick := time.Duration(interval) * time.Second / time.Duration(samples)
+	ticker := time.NewTicker(tick)
 	defer ticker.Stop()
 
+	// Servers with their own interval are probed when it elapses, the latest results are kept in between
+	last := map[string]time.Time{}
+	taken := 0
 	for {
 		// Periodical probe over servers
 		select {
-		case <-ticker.C:
+		case now := <-ticker.C:
 			var wg sync.WaitGroup
 			for _, server := range sc.Servers {
+				if server.Interval > 0 {
+					period := time.Duration(server.Interval) * time.Second / time.Duration(samples)
+					key := collector.Key(server.Host, server.Type)
+					if t, ok := last[key]; ok && now.Sub(t)+tick/2 < period {
+						continue
+					}
+					last[key] = now
+				}
+
 				wg.Add(1)
 				go func(server probe.Server) {
 					log.Debug("Probe serve:", server.Host)
 					defer wg.Done()
 
-					// Initial stat for each server
-					stat := map[string]float64{
-						"online":          0,
-						"accessible":      0,
-						"cpu_utilization": 0,
-						"mem_utilization": 0,
-					}
-
-					online := server.Online()
-					if !online {
-						log.Errorf("Server %s is offline", server.Host)
-						sc.Mutex.Lock()
-						sc.Stat[server.Host] = stat
-						sc.Mutex.Unlock()
-						return
-					}
-					stat["online"] = 1
-
-					log.Debug("Create connection to server:", server.Host)
-					var p probe.Probe
-					var err error
-					switch t := server.Type; t {
-					case "linux":
-						p, err = linux.NewServer(server.Host, server.User, server.Password, server.Port)
-					case "windows":
-						p, err = windows.NewServer(server.Host, server.User, server.Password, server.Port)
-					case "esxi":
-						p, err = vmware.NewServer(server.Host, server.User, server.Password, server.Port)
-					default:
-						err = errors.New("Unsupported operating system")
-					}
-
-					if err != nil {
-						log.Error("Fail to connect to server:", server.Host)
-						sc.Mutex.Lock()
-						sc.Stat[server.Host] = stat
-						sc.Mutex.Unlock()
-						return
-					}
-					stat["accessible"] = 1
-
-					log.Debug("Gather CPU usage for server:", server.Host)
-					cpuUsage, err := p.GetCPUUsage()
-					if err != nil {
-						log.Error("Fail to probe CPU usage", err)
-					} else {
-						stat["cpu_utilization"] = cpuUsage
-					}
-
-					log.Debug("Gather memory usage for server:", server.Host)
-					memUsage, err := p.GetMemUsage()
-					if err != nil {
-						log.Error("Fail to probe memory usage", err)
-					} else {
-						stat["mem_utilization"] = memUsage
-					}
+					result := probeServer(server)
 
 					log.Debug("Update latest stat for server:", server.Host)
-					sc.Mutex.Lock()
-					sc.Stat[server.Host] = stat
-					sc.Mutex.Unlock()
+					sc.Update(result)
 				}(server)
 			}
 			wg.Wait()
+
+			taken++
+			if taken < samples {
+				continue
+			}
+			taken = 0
+
 			// Complete one round of collection
+			sc.Summarize()
 			pdone <- 1
 		}
 	}
 }
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		os.Exit(runReport(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "creds" {
+		os.Exit(runCreds(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+
 	// Parse arguments
-	var job, gateway, cfg string
+	var job, gateway, cfg, format, output, history, creds, vpath, listen string
+	var retention, samples int
+	var quantile float64
 	var interval int64
+	var once, local bool
+	var grouping map[string]string
 	flag.StringVarP(&job, "job", "j", "osprobe", "Pushgateway job name, can be overwritten by setting OSPROBE_JOB")
 	flag.StringVarP(&gateway, "gateway", "g", "http://127.0.0.1:9091", "Pushgateway URL, can be overwritten by setting OSPROBE_GATEWAY")
-	flag.StringVarP(&cfg, "config", "c", "servers.json", "Server definitions, can be overwritten by setting OSPROBE_CONFIG")
+	flag.StringVarP(&cfg, "config", "c", "servers.json", "Configuration in JSON, YAML or TOML, can be overwritten by setting OSPROBE_CONFIG")
+	flag.StringVarP(&creds, "credentials", "C", "", "Credential database for servers referring to credentials by name, can be overwritten by setting OSPROBE_CREDENTIALS")
+	flag.StringVarP(&vpath, "vault", "V", "", "Encrypted credential vault managed with the creds subcommand, can be overwritten by setting OSPROBE_VAULT")
 	flag.Int64VarP(&interval, "interval", "i", 3600, "Refresh interval(seconds), can be overwritten by setting OSPROBE_INTERVAL")
+	flag.IntVarP(&samples, "samples", "s", 1, "Samples taken evenly during each interval to compute min/mean/max/quantile, can be overwritten by setting OSPROBE_SAMPLES")
+	flag.Float64VarP(&quantile, "quantile", "q", 0.95, "Quantile exported for the samples of each interval")
+	flag.BoolVar(&once, "once", false, "Probe all servers for one round, report the results and exit without pushing")
+	flag.StringVarP(&format, "format", "f", "table", "Report format for --once: table, json or csv")
+	flag.StringVarP(&output, "output", "o", "-", "Report file for --once, - means stdout")
+	flag.StringVar(&history, "history", "", "Append probe results to a local history file for the report subcommand, can be overwritten by setting OSPROBE_HISTORY")
+	flag.IntVar(&retention, "retention", 30, "Days of local history to keep")
+	flag.BoolVar(&local, "local", false, "Probe the machine osprobe runs on as an agent instead of the configured servers, the configuration is optional")
+	flag.StringVar(&listen, "listen", "", "Serve metrics on this address, e.g., :9100, instead of pushing them, can be overwritten by setting OSPROBE_LISTEN")
+	flag.StringToStringVar(&grouping, "grouping", nil, "Pushgateway grouping key in addition to the job, e.g., instance=host1, instance=<hostname> by default with --local")
 	flag.Parse()
 
+	ecfg := getEnvVar("OSPROBE_CONFIG")
+	if ecfg != "" {
+		cfg = ecfg
+	}
+	if cfg == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// Settings in the configuration file are used unless they are set with options or environment variables,
+	// agents probing the local machine can run without the default configuration file
+	conf := &config.Config{}
+	if _, err := os.Stat(cfg); !local || err == nil || flag.CommandLine.Changed("config") || ecfg != "" {
+		conf, err = config.Load(cfg)
+		if err != nil {
+			log.Fatalf("Fail to load configuration %s due to %s", cfg, err)
+		}
+	}
+	if local {
+		conf = localConfig(conf)
+	}
+	if conf.Job != "" && !flag.CommandLine.Changed("job") {
+		job = conf.Job
+	}
+	if conf.Gateway != "" && !flag.CommandLine.Changed("gateway") {
+		gateway = conf.Gateway
+	}
+	if conf.Interval > 0 && !flag.CommandLine.Changed("interval") {
+		interval = conf.Interval
+	}
+
 	ejob := getEnvVar("OSPROBE_JOB")
 	if ejob != "" {
 		job = ejob
@@ -142,9 +329,21 @@ func main() {
 	if egateway != "" {
 		gateway = egateway
 	}
-	ecfg := getEnvVar("OSPROBE_CONFIG")
-	if ecfg != "" {
-		cfg = ecfg
+	ecreds := getEnvVar("OSPROBE_CREDENTIALS")
+	if ecreds != "" {
+		creds = ecreds
+	}
+	evpath := getEnvVar("OSPROBE_VAULT")
+	if evpath != "" {
+		vpath = evpath
+	}
+	ehistory := getEnvVar("OSPROBE_HISTORY")
+	if ehistory != "" {
+		history = ehistory
+	}
+	elisten := getEnvVar("OSPROBE_LISTEN")
+	if elisten != "" {
+		listen = elisten
 	}
 	einterval := getEnvVar("OSPROBE_INTERVAL")
 	if einterval != "" {
@@ -156,20 +355,78 @@ func main() {
 		}
 	}
 
-	if job == "" || gateway == "" || cfg == "" || interval <= 0 {
+	esamples := getEnvVar("OSPROBE_SAMPLES")
+	if esamples != "" {
+		v, e := strconv.Atoi(esamples)
+		if e == nil {
+			if v > 0 {
+				samples = v
+			}
+		}
+	}
+
+	if once {
+		if format != "table" && format != "json" && format != "csv" {
+			flag.Usage()
+			os.Exit(1)
+		}
+		sc := newServerCollector(conf, creds, vpath)
+		os.Exit(runOnce(sc.Servers, format, output, history))
+	}
+
+	if job == "" || gateway == "" || interval <= 0 || samples <= 0 || quantile <= 0 || quantile > 1 {
 		flag.Usage()
 		os.Exit(1)
 	}
-	log.Infof("Probe results will be pushed to %s with job %s", gateway, job)
-	log.Infof("Result will be update every %d seconds", interval)
+
+	// Agents pushing to a shared Pushgateway need their own group, otherwise they replace each other's metrics
+	if local && grouping == nil {
+		grouping = map[string]string{"instance": conf.Servers[0].Host}
+	}
+	if listen != "" {
+		log.Infof("Probe results will be served on %s/metrics", listen)
+	} else {
+		log.Infof("Probe results will be pushed to %s with job %s and grouping %v", gateway, job, grouping)
+	}
+	log.Infof("Result will be update every %d seconds with %d samples", interval, samples)
 
 	// Collector init and register
-	sc := collector.NewServerCollector(cfg)
+	sc := newServerCollector(conf, creds, vpath)
+	sc.Quantile = quantile
 	reg := prometheus.NewRegistry()
 	reg.MustRegister(sc)
 
+	// Mark if a round of probe is done
+	pdone := make(chan int)
+	// Update metrics based on defind interval in the background
+	go refreshMetrics(sc, interval, samples, pdone)
+
+	saved := map[string]time.Time{}
+	// Metrics are scraped whenever Prometheus asks, the latest round of probe results is served
+	if listen != "" {
+		http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+		go func() {
+			log.Fatal(http.ListenAndServe(listen, nil))
+		}()
+		for {
+			<-pdone
+			if history != "" {
+				saveHistory(sc, history, retention, saved)
+			}
+			log.Info("Refresh 1 x round of probe results")
+		}
+	}
+
 	// Pusher init
 	pusher := push.New(gateway, job).Collector(sc)
+	var keys []string
+	for k := range grouping {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pusher = pusher.Grouping(k, grouping[k])
+	}
 	defer func() {
 		deleteJob(pusher, gateway, job)
 	}()
@@ -185,14 +442,12 @@ func main() {
 		defer os.Exit(1)
 	}()
 
-	// Mark if a round of probe is done
-	pdone := make(chan int)
-	// Update metrics based on defind interval in the background
-	go refreshMetrics(sc, interval, pdone)
-
 	// Push whenever a round of probe results is ready
 	for {
 		<-pdone
+		if history != "" {
+			saveHistory(sc, history, retention, saved)
+		}
 		if err := pusher.Push(); err != nil {
 			log.Fatal("Fail to push metrics", err)
 		}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kckecheng/osprobe/collector"
+	"github.com/kckecheng/osprobe/probe"
+	"github.com/kckecheng/osprobe/report"
+	log "github.com/sirupsen/logrus"
+)
+
+var reportKeys = []string{"cpu_utilization", "mem_utilization", "nic_received_bytes", "nic_sent_bytes"}
+
+// runOnce probe all servers for one round and report the results, the return value is used as the exit code
+func runOnce(servers []probe.Server, format, output, history string) int {
+	results := make([]collector.Result, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server probe.Server) {
+			defer wg.Done()
+			log.Debug("Probe serve:", server.Host)
+			results[i] = probeServer(server)
+		}(i, server)
+	}
+	wg.Wait()
+
+	if history != "" {
+		if err := report.AppendHistory(history, results); err != nil {
+			log.Errorf("Fail to save history to %s due to %s", history, err)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" && output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Errorf("Fail to create report file %s due to %s", output, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var err error
+	switch format {
+	case "json":
+		err = writeJSONReport(w, results)
+	case "csv":
+		err = writeCSVReport(w, results)
+	default:
+		err = writeTableReport(w, results)
+	}
+	if err != nil {
+		log.Errorf("Fail to write the report due to %s", err)
+		return 1
+	}
+
+	for _, r := range results {
+		if r.Failed() {
+			return 1
+		}
+	}
+	return 0
+}
+
+func writeJSONReport(w io.Writer, results []collector.Result) error {
+	bytes, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(bytes))
+	return err
+}
+
+func writeCSVReport(w io.Writer, results []collector.Result) error {
+	cw := csv.NewWriter(w)
+	header := []string{"host", "type", "labels", "timestamp", "online", "accessible"}
+	header = append(header, reportKeys...)
+	header = append(header, "errors")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		record := []string{r.Host, r.Type, joinLabels(r.Labels), r.Timestamp.Format(time.RFC3339), fmt.Sprint(r.Online), fmt.Sprint(r.Accessible)}
+		for _, k := range reportKeys {
+			record = append(record, formatValue(r, k))
+		}
+		record = append(record, joinFailures(r.Failures))
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeTableReport(w io.Writer, results []collector.Result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tTYPE\tONLINE\tACCESSIBLE\tCPU(%)\tMEM(%)\tERRORS")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%t\t%s\t%s\t%s\n",
+			r.Host,
+			r.Type,
+			r.Online,
+			r.Accessible,
+			formatValue(r, "cpu_utilization"),
+			formatValue(r, "mem_utilization"),
+			joinFailures(r.Failures),
+		)
+	}
+	return tw.Flush()
+}
+
+// formatValue print a probed value, - is used for values not known
+func formatValue(r collector.Result, key string) string {
+	v, ok := r.Values[key]
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", v.Value)
+}
+
+func joinFailures(failures map[string]collector.Failure) string {
+	var keys []string
+	for k := range failures {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ret []string
+	for _, k := range keys {
+		ret = append(ret, fmt.Sprintf("%s: %s (%s)", k, failures[k].Reason, failures[k].Message))
+	}
+	return strings.Join(ret, "; ")
+}
+
+func joinLabels(labels map[string]string) string {
+	var keys []string
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ret []string
+	for _, k := range keys {
+		ret = append(ret, fmt.Sprintf("%s=%s", k, labels[k]))
+	}
+	return strings.Join(ret, ";")
+}
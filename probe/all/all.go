@@ -0,0 +1,18 @@
+// Package all links in all built-in probe backends:
+//
+//	import _ "github.com/kckecheng/osprobe/probe/all"
+package all
+
+import (
+	// Backends register themselves in init
+	_ "github.com/kckecheng/osprobe/probe/kubernetes"
+	_ "github.com/kckecheng/osprobe/probe/libvirt"
+	_ "github.com/kckecheng/osprobe/probe/linux"
+	_ "github.com/kckecheng/osprobe/probe/local"
+	_ "github.com/kckecheng/osprobe/probe/ovirt"
+	_ "github.com/kckecheng/osprobe/probe/proxmox"
+	_ "github.com/kckecheng/osprobe/probe/redfish"
+	_ "github.com/kckecheng/osprobe/probe/snmp"
+	_ "github.com/kckecheng/osprobe/probe/vmware"
+	_ "github.com/kckecheng/osprobe/probe/windows"
+)
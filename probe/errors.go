@@ -0,0 +1,73 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/kckecheng/osprobe/secret"
+)
+
+// Reasons used to categorize probe failures
+const (
+	ReasonOffline     = "offline"
+	ReasonAuth        = "auth"
+	ReasonTimeout     = "timeout"
+	ReasonParse       = "parse"
+	ReasonUnsupported = "unsupported"
+	ReasonSecret      = "secret"
+	ReasonUnknown     = "unknown"
+)
+
+// Errors which can be wrapped by probes to tell the failure category
+var (
+	ErrAuth        = errors.New("Authentication failure")
+	ErrTimeout     = errors.New("Operation timed out")
+	ErrParse       = errors.New("Fail to parse output")
+	ErrUnsupported = errors.New("Not implemented")
+)
+
+// authHints error messages returned by ssh, winrm and vSphere on bad credentials
+var authHints = []string{
+	"unable to authenticate",
+	"incorrect user name or password",
+	"401",
+	"access is denied",
+}
+
+// Reason categorize an error returned by a probe
+func Reason(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	switch {
+	case errors.Is(err, ErrAuth):
+		return ReasonAuth
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return ReasonTimeout
+	case errors.Is(err, ErrParse):
+		return ReasonParse
+	case errors.Is(err, ErrUnsupported):
+		return ReasonUnsupported
+	case errors.Is(err, secret.ErrUnresolvable):
+		return ReasonSecret
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return ReasonTimeout
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, hint := range authHints {
+		if strings.Contains(msg, hint) {
+			return ReasonAuth
+		}
+	}
+	if strings.Contains(msg, "timeout") || strings.Contains(msg, "timed out") {
+		return ReasonTimeout
+	}
+	return ReasonUnknown
+}
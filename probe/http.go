@@ -0,0 +1,64 @@
+package probe
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// NewHTTPClient init a client for REST API backends with the options:
+// - insecure: skip TLS verification, false by default, credentials are sent to the peer;
+// - ca_file: PEM CA bundle used to verify the server (e.g., a self-signed appliance), implies insecure=false;
+// - timeout: timeout(seconds) of each request, def by default.
+func NewHTTPClient(s Server, def int) (*http.Client, error) {
+	timeout, err := strconv.Atoi(s.Option("timeout", strconv.Itoa(def)))
+	if err != nil || timeout <= 0 {
+		return nil, fmt.Errorf("Invalid timeout option %s", s.Option("timeout", ""))
+	}
+	insecure, err := strconv.ParseBool(s.Option("insecure", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid insecure option %s", s.Option("insecure", ""))
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if path := s.Option("ca_file", ""); path != "" {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Fail to read CA file %s: %w", path, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificate is found in CA file %s", path)
+		}
+		tlsConfig.RootCAs = pool
+		tlsConfig.InsecureSkipVerify = false
+	}
+
+	return &http.Client{
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+// DecodeJSON decode the JSON body of a response, status codes are mapped to ErrAuth and ErrUnsupported
+func DecodeJSON(resp *http.Response, v interface{}) error {
+	path := resp.Request.URL.Path
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s returns %s", ErrAuth, path, resp.Status)
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s is not found", ErrUnsupported, path)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("%s returns %s", path, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrParse, path, err)
+	}
+	return nil
+}
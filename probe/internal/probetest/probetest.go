@@ -0,0 +1,103 @@
+// Package probetest fake REST APIs for the tests of the backends
+package probetest
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+// API a fake REST API over TLS serving fixed JSON bodies by path, requests are counted by path
+type API struct {
+	*httptest.Server
+	responses  map[string]string
+	authorized func(*http.Request) bool
+
+	mutex    sync.Mutex
+	handlers map[string]http.HandlerFunc
+	calls    map[string]int
+}
+
+// NewAPI start an API serving responses to the requests accepted by authorized,
+// others get 401 and unknown paths get 404
+func NewAPI(responses map[string]string, authorized func(*http.Request) bool) *API {
+	api := &API{
+		responses:  responses,
+		authorized: authorized,
+		handlers:   map[string]http.HandlerFunc{},
+		calls:      map[string]int{},
+	}
+	api.Server = httptest.NewTLSServer(http.HandlerFunc(api.serve))
+	return api
+}
+
+func (api *API) serve(w http.ResponseWriter, r *http.Request) {
+	if !api.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	api.mutex.Lock()
+	api.calls[r.URL.Path]++
+	handler, ok := api.handlers[r.URL.Path]
+	api.mutex.Unlock()
+	if ok {
+		handler(w, r)
+		return
+	}
+
+	body, ok := api.responses[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprint(w, body)
+}
+
+// Handle serve a path with a handler instead of a fixed body
+func (api *API) Handle(path string, handler http.HandlerFunc) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.handlers[path] = handler
+}
+
+// Calls number of the authorized requests to a path
+func (api *API) Calls(path string) int {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	return api.calls[path]
+}
+
+// ServerOf point a server at the API, its certificate is trusted through the ca_file option
+func (api *API) ServerOf(t *testing.T, s probe.Server) probe.Server {
+	host, port, err := net.SplitHostPort(api.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Host = host
+	s.Port, _ = strconv.Atoi(port)
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(path, CertPEM(api.Server), 0600); err != nil {
+		t.Fatal(err)
+	}
+	options := map[string]string{"ca_file": path}
+	for k, v := range s.Options {
+		options[k] = v
+	}
+	s.Options = options
+	return s
+}
+
+// CertPEM the certificate of a TLS test server in PEM
+func CertPEM(ts *httptest.Server) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+}
@@ -0,0 +1,183 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kubeconfig the subset of a kubeconfig file needed to reach an API server, exec and auth provider plugins are not supported
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string      `yaml:"token"`
+			TokenFile             string      `yaml:"tokenFile"`
+			ClientCertificate     string      `yaml:"client-certificate"`
+			ClientCertificateData string      `yaml:"client-certificate-data"`
+			ClientKey             string      `yaml:"client-key"`
+			ClientKeyData         string      `yaml:"client-key-data"`
+			Username              string      `yaml:"username"`
+			Password              string      `yaml:"password"`
+			Exec                  interface{} `yaml:"exec"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// endpoint how to reach and authenticate with an API server
+type endpoint struct {
+	Server   string
+	TLS      *tls.Config
+	Token    string
+	Username string
+	Password string
+}
+
+// loadKubeconfig read a kubeconfig, key is the path of the file or its content, e.g., resolved from a secret
+func loadKubeconfig(key, context string) (endpoint, error) {
+	content := []byte(key)
+	dir := "."
+	if !strings.Contains(key, "clusters:") {
+		var err error
+		content, err = ioutil.ReadFile(key)
+		if err != nil {
+			return endpoint{}, fmt.Errorf("Fail to read kubeconfig %s: %w", key, err)
+		}
+		dir = filepath.Dir(key)
+	}
+
+	var kc kubeconfig
+	if err := yaml.Unmarshal(content, &kc); err != nil {
+		return endpoint{}, fmt.Errorf("Fail to parse kubeconfig: %w", err)
+	}
+	return kc.endpoint(context, dir)
+}
+
+// endpoint resolve a context, the current context is used if context is empty
+func (kc kubeconfig) endpoint(context, dir string) (endpoint, error) {
+	if context == "" {
+		context = kc.CurrentContext
+	}
+
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == context {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+			break
+		}
+	}
+	if !found {
+		return endpoint{}, fmt.Errorf("Context %q is not found in kubeconfig", context)
+	}
+
+	ep := endpoint{TLS: &tls.Config{}}
+	found = false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		ep.Server = strings.TrimSuffix(c.Cluster.Server, "/")
+		ep.TLS.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+
+		ca, err := readData(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority, dir)
+		if err != nil {
+			return ep, err
+		}
+		if ca != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return ep, fmt.Errorf("No certificate is found in the certificate authority of cluster %s", clusterName)
+			}
+			ep.TLS.RootCAs = pool
+		}
+		break
+	}
+	if !found {
+		return ep, fmt.Errorf("Cluster %q is not found in kubeconfig", clusterName)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		if u.User.Exec != nil {
+			return ep, fmt.Errorf("Exec credential plugins of user %s are not supported, please use a token or a client certificate", userName)
+		}
+
+		ep.Token = u.User.Token
+		if ep.Token == "" && u.User.TokenFile != "" {
+			token, err := ioutil.ReadFile(resolvePath(u.User.TokenFile, dir))
+			if err != nil {
+				return ep, err
+			}
+			ep.Token = strings.TrimSpace(string(token))
+		}
+		ep.Username, ep.Password = u.User.Username, u.User.Password
+
+		cert, err := readData(u.User.ClientCertificateData, u.User.ClientCertificate, dir)
+		if err != nil {
+			return ep, err
+		}
+		key, err := readData(u.User.ClientKeyData, u.User.ClientKey, dir)
+		if err != nil {
+			return ep, err
+		}
+		if cert != nil && key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return ep, fmt.Errorf("Invalid client certificate of user %s: %w", userName, err)
+			}
+			ep.TLS.Certificates = []tls.Certificate{pair}
+		}
+		return ep, nil
+	}
+	return ep, fmt.Errorf("User %q is not found in kubeconfig", userName)
+}
+
+// readData decode base64 encoded data, or read the file if no data is embedded, nil is returned if neither is set
+func readData(data, path, dir string) ([]byte, error) {
+	if data != "" {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid base64 data in kubeconfig: %w", err)
+		}
+		return decoded, nil
+	}
+	if path == "" {
+		return nil, nil
+	}
+	return ioutil.ReadFile(resolvePath(path, dir))
+}
+
+// resolvePath paths in a kubeconfig are relative to the kubeconfig itself
+func resolvePath(path, dir string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
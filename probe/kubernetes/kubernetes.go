@@ -0,0 +1,405 @@
+package kubernetes
+
+/*
+	Kubernetes clusters probed through the API server (6443 by default), the server is the API server:
+	- key: kubeconfig path (or its content through a secret reference), the API server URL, the CA and
+	  the credential (token, client certificate or basic auth) of the context are used;
+	- password: bearer token of a service account if no kubeconfig is set, https://host:port is used.
+	Options:
+	- context: kubeconfig context, the current context by default;
+	- insecure, ca_file and timeout: TLS verification (bearer token only) and request timeout, see probe.NewHTTPClient,
+	  the CA of the service account is used by default when osprobe runs in a pod.
+
+	The service account needs get/list on nodes and pods, and on nodes.metrics.k8s.io (metrics-server).
+	Node metrics carry the host label of the server probing the same machine, e.g., as linux, if there is one.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kckecheng/osprobe/probe"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	probe.Register(probe.Backend{
+		Type:         "kubernetes",
+		New:          newProbe,
+		Port:         6443,
+		UserOptional: true,
+		Metrics:      []string{"cpu", "mem", "node"},
+	})
+}
+
+// inClusterCA CA bundle mounted into pods with the service account token
+var inClusterCA = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+func newProbe(server probe.Server) (probe.Probe, error) {
+	p, err := NewServer(server)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Server Kubernetes API server
+type Server struct {
+	probe.Server
+	client *http.Client
+	ep     endpoint
+}
+
+type quantities map[string]string
+
+type node struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Unschedulable bool `json:"unschedulable"`
+	} `json:"spec"`
+	Status struct {
+		Allocatable quantities `json:"allocatable"`
+		Addresses   []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+type nodeMetrics struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Usage quantities `json:"usage"`
+}
+
+type pod struct {
+	Spec struct {
+		NodeName   string `json:"nodeName"`
+		Containers []struct {
+			Resources struct {
+				Requests quantities `json:"requests"`
+			} `json:"resources"`
+		} `json:"containers"`
+	} `json:"spec"`
+}
+
+// usage resources of a node, CPUs are in cores and memory is in bytes
+type usage struct {
+	node
+	CPU, Mem                      float64
+	AllocCPU, AllocMem, AllocPods float64
+	RequestedCPU, RequestedMem    float64
+	Pods                          float64
+	HasUsage                      bool
+}
+
+// NewServer init with a kubeconfig or a bearer token, the credential is checked against /version
+func NewServer(s probe.Server) (Server, error) {
+	server := Server{Server: s}
+	server.Type = "kubernetes"
+	if !server.Valid() {
+		return server, errors.New("Inputs are not valid, please check")
+	}
+
+	if s.Key == "" && s.Option("ca_file", "") == "" {
+		if _, err := os.Stat(inClusterCA); err == nil {
+			options := map[string]string{"ca_file": inClusterCA}
+			for k, v := range s.Options {
+				options[k] = v
+			}
+			s.Options = options
+		}
+	}
+	client, err := probe.NewHTTPClient(s, 10)
+	if err != nil {
+		return server, err
+	}
+	server.client = client
+
+	if s.Key != "" {
+		server.ep, err = loadKubeconfig(s.Key, s.Option("context", ""))
+		if err != nil {
+			return server, err
+		}
+		client.Transport.(*http.Transport).TLSClientConfig = server.ep.TLS
+	} else {
+		server.ep = endpoint{
+			Server: fmt.Sprintf("https://%s:%d", s.Host, s.Port),
+			Token:  s.Password,
+		}
+	}
+
+	var version map[string]interface{}
+	if err := server.get("/version", &version); err != nil {
+		log.Errorf("Fail to connect to %s due to %s", server.Server, err)
+		server.Close()
+		return server, err
+	}
+	return server, nil
+}
+
+// GetCPUUsage implement interface, usage of all nodes against their allocatable CPUs
+func (k8s Server) GetCPUUsage() (float64, error) {
+	nodes, err := k8s.usages(false)
+	if err != nil {
+		return 0, err
+	}
+
+	var used, total float64
+	for _, n := range nodes {
+		if n.HasUsage {
+			used += n.CPU
+			total += n.AllocCPU
+		}
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("%w: no node metrics are reported by metrics-server", probe.ErrUnsupported)
+	}
+	return used * 100 / total, nil
+}
+
+// GetMemUsage implement interface, usage of all nodes against their allocatable memory
+func (k8s Server) GetMemUsage() (float64, error) {
+	nodes, err := k8s.usages(false)
+	if err != nil {
+		return 0, err
+	}
+
+	var used, total float64
+	for _, n := range nodes {
+		if n.HasUsage {
+			used += n.Mem
+			total += n.AllocMem
+		}
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("%w: no node metrics are reported by metrics-server", probe.ErrUnsupported)
+	}
+	return used * 100 / total, nil
+}
+
+// GetLocalDiskUsage implement interface, not reported by the resource metrics API
+func (k8s Server) GetLocalDiskUsage() (map[string]float64, error) {
+	return nil, fmt.Errorf("%w: disk usage is not reported by metrics.k8s.io", probe.ErrUnsupported)
+}
+
+// GetNICUsage implement interface, not reported by the resource metrics API
+func (k8s Server) GetNICUsage() (map[string]map[string]float64, error) {
+	return nil, fmt.Errorf("%w: NIC usage is not reported by metrics.k8s.io", probe.ErrUnsupported)
+}
+
+// Close implement interface, idle connections are closed
+func (k8s Server) Close() error {
+	k8s.client.CloseIdleConnections()
+	return nil
+}
+
+// GetMetrics implement probe.Extended, usage, requests, pods and cordon status of each node
+func (k8s Server) GetMetrics() ([]probe.Metric, error) {
+	if !k8s.Enabled("node") {
+		return nil, nil
+	}
+	nodes, err := k8s.usages(true)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []probe.Metric
+	for _, n := range nodes {
+		labels := map[string]string{"node": n.Metadata.Name}
+		hosts := []string{n.Metadata.Name}
+		for _, a := range n.Status.Addresses {
+			hosts = append(hosts, a.Address)
+		}
+		add := func(name, help string, v float64) {
+			ret = append(ret, probe.Metric{Name: name, Help: help, Labels: labels, Value: v, Hosts: hosts})
+		}
+
+		add("kube_node_ready", "if the node is ready: 1 - ready, 0 - not ready or unknown", boolToFloat(ready(n.node)))
+		add("kube_node_unschedulable", "if the node is cordoned: 1 - cordoned, 0 - schedulable", boolToFloat(n.Spec.Unschedulable))
+		add("kube_node_pods", "pods scheduled on the node which are not completed", n.Pods)
+		add("kube_node_pods_allocatable", "pods which can be scheduled on the node", n.AllocPods)
+		add("kube_node_cpu_allocatable_cores", "CPUs of the node which can be allocated to pods in cores", n.AllocCPU)
+		add("kube_node_cpu_requested_cores", "CPUs requested by pods on the node in cores", n.RequestedCPU)
+		add("kube_node_mem_allocatable_bytes", "memory of the node which can be allocated to pods in bytes", n.AllocMem)
+		add("kube_node_mem_requested_bytes", "memory requested by pods on the node in bytes", n.RequestedMem)
+		if n.AllocCPU > 0 {
+			add("kube_node_cpu_requested_utilization", "CPUs requested by pods in percent of the allocatable CPUs", n.RequestedCPU*100/n.AllocCPU)
+		}
+		if n.AllocMem > 0 {
+			add("kube_node_mem_requested_utilization", "memory requested by pods in percent of the allocatable memory", n.RequestedMem*100/n.AllocMem)
+		}
+		if !n.HasUsage {
+			continue
+		}
+		add("kube_node_cpu_usage_cores", "CPUs used on the node in cores", n.CPU)
+		add("kube_node_mem_usage_bytes", "memory used on the node (working set) in bytes", n.Mem)
+		if n.AllocCPU > 0 {
+			add("kube_node_cpu_utilization", "CPUs used in percent of the allocatable CPUs", n.CPU*100/n.AllocCPU)
+		}
+		if n.AllocMem > 0 {
+			add("kube_node_mem_utilization", "memory used in percent of the allocatable memory", n.Mem*100/n.AllocMem)
+		}
+	}
+	return ret, nil
+}
+
+// usages gather nodes with their usage, pods and requests are only counted if asked
+func (k8s Server) usages(requests bool) ([]*usage, error) {
+	var nodes struct {
+		Items []node `json:"items"`
+	}
+	if err := k8s.get("/api/v1/nodes", &nodes); err != nil {
+		return nil, err
+	}
+
+	var ret []*usage
+	byName := map[string]*usage{}
+	for _, n := range nodes.Items {
+		u := &usage{
+			node:      n,
+			AllocCPU:  parseQuantity(n.Status.Allocatable["cpu"]),
+			AllocMem:  parseQuantity(n.Status.Allocatable["memory"]),
+			AllocPods: parseQuantity(n.Status.Allocatable["pods"]),
+		}
+		ret = append(ret, u)
+		byName[n.Metadata.Name] = u
+	}
+
+	var metrics struct {
+		Items []nodeMetrics `json:"items"`
+	}
+	if err := k8s.get("/apis/metrics.k8s.io/v1beta1/nodes", &metrics); err != nil {
+		// Requests and pods are still reported without metrics-server
+		if !requests || !errors.Is(err, probe.ErrUnsupported) {
+			return nil, err
+		}
+		log.Warnf("metrics.k8s.io is not available on %s: %s", k8s.Server, err)
+	}
+	for _, m := range metrics.Items {
+		if u, ok := byName[m.Metadata.Name]; ok {
+			u.CPU = parseQuantity(m.Usage["cpu"])
+			u.Mem = parseQuantity(m.Usage["memory"])
+			u.HasUsage = true
+		}
+	}
+
+	if !requests {
+		return ret, nil
+	}
+	var pods struct {
+		Items []pod `json:"items"`
+	}
+	selector := url.QueryEscape("status.phase!=Succeeded,status.phase!=Failed")
+	if err := k8s.get("/api/v1/pods?fieldSelector="+selector, &pods); err != nil {
+		return nil, err
+	}
+	for _, p := range pods.Items {
+		u, ok := byName[p.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		u.Pods++
+		for _, c := range p.Spec.Containers {
+			u.RequestedCPU += parseQuantity(c.Resources.Requests["cpu"])
+			u.RequestedMem += parseQuantity(c.Resources.Requests["memory"])
+		}
+	}
+	return ret, nil
+}
+
+// get fetch an API resource with the credential of the endpoint
+func (k8s Server) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", k8s.ep.Server+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if k8s.ep.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+k8s.ep.Token)
+	} else if k8s.ep.Username != "" {
+		req.SetBasicAuth(k8s.ep.Username, k8s.ep.Password)
+	}
+
+	resp, err := k8s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return probe.DecodeJSON(resp, v)
+}
+
+func ready(n node) bool {
+	for _, c := range n.Status.Conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+// suffixes of resource quantities
+var suffixes = map[string]float64{
+	"n":  1e-9,
+	"u":  1e-6,
+	"m":  1e-3,
+	"":   1,
+	"k":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"P":  1e15,
+	"E":  1e18,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+	"Pi": 1 << 50,
+	"Ei": 1 << 60,
+}
+
+// parseQuantity parse a resource quantity such as 250m, 3911752Ki or 1e3, invalid quantities are 0
+func parseQuantity(q string) float64 {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return 0
+	}
+	if v, err := strconv.ParseFloat(q, 64); err == nil {
+		return v
+	}
+
+	i := strings.IndexFunc(q, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+'
+	})
+	if i <= 0 {
+		return 0
+	}
+	v, err := strconv.ParseFloat(q[:i], 64)
+	if err != nil {
+		return 0
+	}
+	scale, ok := suffixes[q[i:]]
+	if !ok {
+		return 0
+	}
+	return v * scale
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,295 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+	"github.com/kckecheng/osprobe/probe/internal/probetest"
+)
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		q    string
+		want float64
+	}{
+		{"2", 2},
+		{"250m", 0.25},
+		{"1500000n", 0.0015},
+		{"3911752Ki", 3911752 * 1024},
+		{"16Gi", 16 * (1 << 30)},
+		{"1G", 1e9},
+		{"1e3", 1000},
+		{"0.5", 0.5},
+		{"", 0},
+		{"12Xi", 0},
+		{"Mi", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			if got := parseQuantity(tt.q); math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+const kubeconfigTemplate = `apiVersion: v1
+kind: Config
+current-context: %s
+clusters:
+- name: prod
+  cluster:
+    server: https://10.0.0.1:6443/
+    insecure-skip-tls-verify: true
+- name: lab
+  cluster:
+    server: https://lab.example:6443
+    certificate-authority-data: %s
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: reader
+- name: lab
+  context:
+    cluster: lab
+    user: admin
+- name: sso
+  context:
+    cluster: prod
+    user: sso
+users:
+- name: reader
+  user:
+    tokenFile: token
+- name: admin
+  user:
+    username: admin
+    password: secret
+- name: sso
+  user:
+    exec:
+      command: kubectl-oidc
+`
+
+func TestLoadKubeconfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A certificate of the fake API server is as good as any CA bundle
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	ca := base64.StdEncoding.EncodeToString(probetest.CertPEM(ts))
+
+	path := filepath.Join(dir, "config")
+	content := fmt.Sprintf(kubeconfigTemplate, "prod", ca)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "token"), []byte("abc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		context  string
+		server   string
+		token    string
+		username string
+		insecure bool
+		ca       bool
+		err      bool
+	}{
+		{name: "current context with a relative token file", key: path, server: "https://10.0.0.1:6443", token: "abc", insecure: true},
+		{name: "context with a CA and basic auth", key: path, context: "lab", server: "https://lab.example:6443", username: "admin", ca: true},
+		{name: "content instead of a path", key: fmt.Sprintf(kubeconfigTemplate, "lab", ca), server: "https://lab.example:6443", username: "admin", ca: true},
+		{name: "unknown context", key: path, context: "dev", err: true},
+		{name: "exec plugin", key: path, context: "sso", err: true},
+		{name: "missing file", key: filepath.Join(dir, "missing"), err: true},
+		{name: "invalid CA data", key: fmt.Sprintf(kubeconfigTemplate, "lab", "bm90IGEgY2VydA=="), err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ep, err := loadKubeconfig(tt.key, tt.context)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if ep.Server != tt.server || ep.Token != tt.token || ep.Username != tt.username {
+				t.Errorf("got server %s, token %q and user %q, want %s, %q and %q", ep.Server, ep.Token, ep.Username, tt.server, tt.token, tt.username)
+			}
+			if ep.TLS.InsecureSkipVerify != tt.insecure || (ep.TLS.RootCAs != nil) != tt.ca {
+				t.Errorf("got insecure %v and CA %v, want %v and %v", ep.TLS.InsecureSkipVerify, ep.TLS.RootCAs != nil, tt.insecure, tt.ca)
+			}
+		})
+	}
+}
+
+// fakeAPI serve nodes, node metrics (unless metrics is false) and pods with a bearer token
+func fakeAPI(t *testing.T, metrics bool) *probetest.API {
+	responses := map[string]string{
+		"/version": `{"major": "1", "minor": "20"}`,
+		"/api/v1/nodes": `{"items": [
+			{"metadata": {"name": "n1"}, "status": {"allocatable": {"cpu": "4", "memory": "8Gi", "pods": "110"},
+			 "addresses": [{"type": "InternalIP", "address": "10.0.0.5"}], "conditions": [{"type": "Ready", "status": "True"}]}},
+			{"metadata": {"name": "n2"}, "spec": {"unschedulable": true}, "status": {"allocatable": {"cpu": "4", "memory": "8Gi", "pods": "110"},
+			 "conditions": [{"type": "Ready", "status": "False"}]}}
+		]}`,
+		"/apis/metrics.k8s.io/v1beta1/nodes": `{"items": [
+			{"metadata": {"name": "n1"}, "usage": {"cpu": "1500m", "memory": "2Gi"}},
+			{"metadata": {"name": "n2"}, "usage": {"cpu": "500m", "memory": "6Gi"}}
+		]}`,
+		"/api/v1/pods": `{"items": [
+			{"spec": {"nodeName": "n1", "containers": [{"resources": {"requests": {"cpu": "250m", "memory": "512Mi"}}}, {"resources": {}}]}},
+			{"spec": {"nodeName": "n1", "containers": [{"resources": {"requests": {"cpu": "750m", "memory": "1536Mi"}}}]}},
+			{"spec": {"nodeName": "gone", "containers": [{"resources": {"requests": {"cpu": "1"}}}]}}
+		]}`,
+	}
+	if !metrics {
+		delete(responses, "/apis/metrics.k8s.io/v1beta1/nodes")
+	}
+
+	api := probetest.NewAPI(responses, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer token"
+	})
+	api.Handle("/api/v1/pods", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fieldSelector") == "" {
+			t.Errorf("pods are listed without a field selector")
+		}
+		fmt.Fprint(w, responses["/api/v1/pods"])
+	})
+	return api
+}
+
+func TestServer(t *testing.T) {
+	ts := fakeAPI(t, true)
+	defer ts.Close()
+
+	if _, err := NewServer(serverOf(t, ts, "wrong")); !errors.Is(err, probe.ErrAuth) {
+		t.Fatalf("got error %v with a wrong token, want %v", err, probe.ErrAuth)
+	}
+
+	k8s, err := NewServer(serverOf(t, ts, "token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k8s.Close()
+
+	if cpu, err := k8s.GetCPUUsage(); err != nil || cpu != 25 {
+		t.Errorf("got CPU usage %v (%v), want 25", cpu, err)
+	}
+	if mem, err := k8s.GetMemUsage(); err != nil || mem != 50 {
+		t.Errorf("got memory usage %v (%v), want 50", mem, err)
+	}
+
+	metrics, err := k8s.GetMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, m := range metrics {
+		got[m.Name+"/"+m.Labels["node"]] = m.Value
+	}
+	want := map[string]float64{
+		"kube_node_ready/n1":                     1,
+		"kube_node_ready/n2":                     0,
+		"kube_node_unschedulable/n2":             1,
+		"kube_node_pods/n1":                      2,
+		"kube_node_cpu_requested_cores/n1":       1,
+		"kube_node_mem_requested_bytes/n1":       2 * (1 << 30),
+		"kube_node_cpu_requested_utilization/n1": 25,
+		"kube_node_mem_requested_utilization/n1": 25,
+		"kube_node_cpu_usage_cores/n1":           1.5,
+		"kube_node_mem_utilization/n2":           75,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("got %s %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestServerWithoutMetricsServer(t *testing.T) {
+	ts := fakeAPI(t, false)
+	defer ts.Close()
+
+	k8s, err := NewServer(serverOf(t, ts, "token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k8s.Close()
+
+	if _, err := k8s.GetCPUUsage(); !errors.Is(err, probe.ErrUnsupported) {
+		t.Errorf("got error %v, want %v", err, probe.ErrUnsupported)
+	}
+	// Requests are still reported
+	metrics, err := k8s.GetMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range metrics {
+		if m.Name == "kube_node_cpu_usage_cores" {
+			t.Errorf("usage is reported without metrics-server: %+v", m)
+		}
+	}
+	if len(metrics) == 0 {
+		t.Error("no metric is reported without metrics-server")
+	}
+}
+
+func TestInClusterCA(t *testing.T) {
+	api := fakeAPI(t, true)
+	defer api.Close()
+
+	path := filepath.Join(t.TempDir(), "ca.crt")
+	if err := ioutil.WriteFile(path, probetest.CertPEM(api.Server), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(ca string) { inClusterCA = ca }(inClusterCA)
+
+	tests := []struct {
+		name string
+		ca   string
+		fail bool
+	}{
+		{name: "service account CA", ca: path},
+		{name: "outside of a pod", ca: filepath.Join(t.TempDir(), "missing"), fail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inClusterCA = tt.ca
+			s := serverOf(t, api, "token")
+			delete(s.Options, "ca_file")
+			k8s, err := NewServer(s)
+			if (err != nil) != tt.fail {
+				t.Fatalf("got error %v, want failure %v", err, tt.fail)
+			}
+			if err == nil {
+				k8s.Close()
+			}
+		})
+	}
+}
+
+// serverOf a server of the fake API with a bearer token
+func serverOf(t *testing.T, api *probetest.API, token string) probe.Server {
+	return api.ServerOf(t, probe.Server{Password: token, Type: "kubernetes"})
+}
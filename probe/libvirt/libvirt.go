@@ -0,0 +1,268 @@
+package libvirt
+
+/*
+	KVM/QEMU hypervisors managed by libvirt, virsh is run over the SSH transport of the linux backend so that
+	nothing but sshd is required on the host. Host usage is read from /proc as for linux, guests are reported
+	with virsh domstats, the same vm_* metrics are exported by all hypervisor backends.
+	Options:
+	- uri: libvirt connection URI, qemu:///system by default;
+	- virsh: command used to run virsh, e.g., "sudo virsh" if the user is not in the libvirt group.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kckecheng/osprobe/probe"
+	"github.com/kckecheng/osprobe/probe/linux"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	probe.Register(probe.Backend{
+		Type:    "libvirt",
+		New:     newProbe,
+		Port:    22,
+		Metrics: []string{"cpu", "mem", "nic", "vm"},
+	})
+}
+
+func newProbe(server probe.Server) (probe.Probe, error) {
+	p, err := NewServer(server)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// states virDomainState reported by domstats as state.state
+var states = map[string]string{
+	"0": "nostate",
+	"1": "running",
+	"2": "blocked",
+	"3": "paused",
+	"4": "shutdown",
+	"5": "shutoff",
+	"6": "crashed",
+	"7": "pmsuspended",
+}
+
+// Server KVM host
+type Server struct {
+	linux.Server
+}
+
+// NewServer connect over SSH and check that virsh can reach libvirtd
+func NewServer(s probe.Server) (Server, error) {
+	lin, err := linux.Dial(s)
+	if err != nil {
+		return Server{}, err
+	}
+	lin.Server = s
+	lin.Type = "libvirt"
+	server := Server{Server: lin}
+
+	if _, err := server.virsh("uri"); err != nil {
+		log.Errorf("Fail to connect to libvirt on %s due to %s", server.Server.Server, err)
+		server.Close()
+		return server, err
+	}
+	return server, nil
+}
+
+// GetMetrics implement probe.Extended, guests and overcommit ratios of the host
+func (kvm Server) GetMetrics() ([]probe.Metric, error) {
+	if !kvm.Enabled("vm") {
+		return nil, nil
+	}
+
+	output, err := kvm.virsh("domstats --state --cpu-total --balloon --vcpu --interface --block")
+	if err != nil {
+		return nil, err
+	}
+	vms, err := parseDomstats(output)
+	if err != nil {
+		return nil, err
+	}
+
+	// domstats prints names only, UUIDs are listed separately
+	output, err = kvm.Run(fmt.Sprintf("%s list --all --uuid | while read -r id; do if [ -n \"$id\" ]; then echo \"$id $(%s domname $id)\"; fi; done",
+		kvm.virshCmd(), kvm.virshCmd()))
+	if err != nil {
+		return nil, err
+	}
+	ids := parseUUIDs(output)
+	for i := range vms {
+		vms[i].ID = ids[vms[i].Name]
+	}
+
+	output, err = kvm.virsh("nodeinfo")
+	if err != nil {
+		return nil, err
+	}
+	cpus, mem := parseNodeinfo(output)
+	return append(probe.VMMetrics(vms), probe.Overcommit(vms, cpus, mem)...), nil
+}
+
+// virsh run a virsh command against the configured URI
+func (kvm Server) virsh(args string) (string, error) {
+	return kvm.Run(kvm.virshCmd() + " " + args)
+}
+
+// virshCmd virsh connected to the configured URI
+func (kvm Server) virshCmd() string {
+	return fmt.Sprintf("%s -c '%s'", kvm.Option("virsh", "virsh"), kvm.Option("uri", "qemu:///system"))
+}
+
+// parseUUIDs map domain names to UUIDs, each line is "<uuid> <name>"
+func parseUUIDs(output string) map[string]string {
+	ret := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) == 2 {
+			ret[strings.TrimSpace(fields[1])] = fields[0]
+		}
+	}
+	return ret
+}
+
+// parseDomstats parse the output of virsh domstats:
+//
+//	Domain: 'vm1'
+//	  state.state=1
+//	  cpu.time=7218461000
+//	  balloon.current=1048576
+//	  block.0.name=vda
+//	  block.0.rd.bytes=91766272
+func parseDomstats(output string) ([]probe.VM, error) {
+	var ret []probe.VM
+	var fields map[string]string
+	var name string
+	flush := func() {
+		if name != "" {
+			ret = append(ret, toVM(name, fields))
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Domain:") {
+			flush()
+			name = strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "Domain:")), "'")
+			fields = map[string]string{}
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || name == "" {
+			continue
+		}
+		fields[kv[0]] = kv[1]
+	}
+	flush()
+
+	if ret == nil && strings.TrimSpace(output) != "" {
+		return nil, fmt.Errorf("%w: unexpected virsh domstats output %q", probe.ErrParse, output)
+	}
+	return ret, nil
+}
+
+// toVM convert domstats fields of a domain, cpu.time is in nanoseconds and balloon sizes are in KiB
+func toVM(name string, fields map[string]string) probe.VM {
+	vm := probe.VM{
+		Name:  name,
+		State: states[fields["state.state"]],
+		Stats: map[string]float64{},
+		Disks: map[string]map[string]float64{},
+		NICs:  map[string]map[string]float64{},
+	}
+	if vm.State == "" {
+		vm.State = "unknown"
+	}
+
+	number := func(key string) (float64, bool) {
+		v, err := strconv.ParseFloat(fields[key], 64)
+		return v, err == nil
+	}
+	if v, ok := number("vcpu.current"); ok {
+		vm.Stats["vcpus"] = v
+	}
+	if v, ok := number("cpu.time"); ok {
+		vm.Stats["cpu_seconds"] = v / 1e9
+	}
+	if v, ok := number("balloon.maximum"); ok {
+		vm.Stats["mem_bytes"] = v * 1024
+	}
+	if v, ok := number("balloon.current"); ok {
+		vm.Stats["mem_balloon_bytes"] = v * 1024
+	}
+	// Guest memory stats are only available with the balloon driver and a stats period set
+	available, ok1 := number("balloon.available")
+	unused, ok2 := number("balloon.unused")
+	if ok1 && ok2 {
+		vm.Stats["mem_used_bytes"] = (available - unused) * 1024
+	}
+
+	count, _ := strconv.Atoi(fields["block.count"])
+	for i := 0; i < count; i++ {
+		prefix := fmt.Sprintf("block.%d.", i)
+		disk := fields[prefix+"name"]
+		if disk == "" {
+			continue
+		}
+		stats := map[string]float64{}
+		if v, ok := number(prefix + "rd.bytes"); ok {
+			stats["read"] = v
+		}
+		if v, ok := number(prefix + "wr.bytes"); ok {
+			stats["written"] = v
+		}
+		vm.Disks[disk] = stats
+	}
+
+	count, _ = strconv.Atoi(fields["net.count"])
+	for i := 0; i < count; i++ {
+		prefix := fmt.Sprintf("net.%d.", i)
+		nic := fields[prefix+"name"]
+		if nic == "" {
+			continue
+		}
+		stats := map[string]float64{}
+		if v, ok := number(prefix + "rx.bytes"); ok {
+			stats["received"] = v
+		}
+		if v, ok := number(prefix + "tx.bytes"); ok {
+			stats["sent"] = v
+		}
+		vm.NICs[nic] = stats
+	}
+	return vm
+}
+
+// parseNodeinfo get CPUs and memory (bytes) of the host from virsh nodeinfo, e.g., "CPU(s): 8" and "Memory size: 16303004 KiB"
+func parseNodeinfo(output string) (float64, float64) {
+	var cpus, mem float64
+	for _, line := range strings.Split(output, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		fields := strings.Fields(kv[1])
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "CPU(s)":
+			cpus = v
+		case "Memory size":
+			mem = v * 1024
+		}
+	}
+	return cpus, mem
+}
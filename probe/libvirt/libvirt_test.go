@@ -0,0 +1,183 @@
+package libvirt
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+const domstats = `Domain: 'web1'
+  state.state=1
+  state.reason=1
+  cpu.time=7218461000
+  balloon.current=1048576
+  balloon.maximum=2097152
+  balloon.available=1012000
+  balloon.unused=512000
+  vcpu.current=2
+  vcpu.maximum=2
+  net.count=1
+  net.0.name=vnet0
+  net.0.rx.bytes=1024
+  net.0.tx.bytes=2048
+  block.count=2
+  block.0.name=vda
+  block.0.rd.bytes=91766272
+  block.0.wr.bytes=4096
+  block.1.name=hda
+  block.1.rd.bytes=512
+
+Domain: 'db1'
+  state.state=5
+  state.reason=0
+  balloon.maximum=1048576
+  vcpu.maximum=4
+  net.count=0
+  block.count=0
+
+`
+
+func TestParseDomstats(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []probe.VM
+		err    error
+	}{
+		{
+			name:   "running and shut off domains",
+			output: domstats,
+			want: []probe.VM{
+				{
+					Name:  "web1",
+					State: "running",
+					Stats: map[string]float64{
+						"vcpus":             2,
+						"cpu_seconds":       7.218461,
+						"mem_bytes":         2097152 * 1024,
+						"mem_balloon_bytes": 1048576 * 1024,
+						"mem_used_bytes":    500000 * 1024,
+					},
+					Disks: map[string]map[string]float64{
+						"vda": {"read": 91766272, "written": 4096},
+						"hda": {"read": 512},
+					},
+					NICs: map[string]map[string]float64{
+						"vnet0": {"received": 1024, "sent": 2048},
+					},
+				},
+				{
+					Name:  "db1",
+					State: "shutoff",
+					Stats: map[string]float64{"mem_bytes": 1048576 * 1024},
+					Disks: map[string]map[string]float64{},
+					NICs:  map[string]map[string]float64{},
+				},
+			},
+		},
+		{
+			name:   "unknown state",
+			output: "Domain: 'x'\n  state.state=42\n",
+			want: []probe.VM{
+				{
+					Name:  "x",
+					State: "unknown",
+					Stats: map[string]float64{},
+					Disks: map[string]map[string]float64{},
+					NICs:  map[string]map[string]float64{},
+				},
+			},
+		},
+		{
+			name:   "no domain",
+			output: "\n",
+		},
+		{
+			name:   "unexpected output",
+			output: "error: failed to connect to the hypervisor\n",
+			err:    probe.ErrParse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDomstats(tt.output)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNodeinfo(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		cpus   float64
+		mem    float64
+	}{
+		{
+			name: "nodeinfo",
+			output: `CPU model:           x86_64
+CPU(s):              8
+CPU frequency:       2394 MHz
+CPU socket(s):       1
+Memory size:         16303004 KiB
+`,
+			cpus: 8,
+			mem:  16303004 * 1024,
+		},
+		{
+			name:   "missing fields",
+			output: "CPU model: x86_64\n",
+		},
+		{
+			name:   "empty",
+			output: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpus, mem := parseNodeinfo(tt.output)
+			if cpus != tt.cpus || mem != tt.mem {
+				t.Errorf("got %v CPUs and %v bytes, want %v and %v", cpus, mem, tt.cpus, tt.mem)
+			}
+		})
+	}
+}
+
+func TestParseUUIDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   map[string]string
+	}{
+		{
+			name:   "domains",
+			output: "4d1c3e2a-0000-4000-8000-000000000001 web1\n4d1c3e2a-0000-4000-8000-000000000002 my vm\n\n",
+			want: map[string]string{
+				"web1":  "4d1c3e2a-0000-4000-8000-000000000001",
+				"my vm": "4d1c3e2a-0000-4000-8000-000000000002",
+			},
+		},
+		{
+			name:   "empty",
+			output: "",
+			want:   map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseUUIDs(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
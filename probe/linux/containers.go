@@ -0,0 +1,197 @@
+package linux
+
+/*
+	Containers are listed with the Docker or Podman CLI over the SSH session when the containers option is set:
+	- auto: docker if it is installed, podman otherwise;
+	- docker or podman: the CLI to use, it can be prefixed, e.g., "sudo podman".
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+// statsFormat fields shared by docker stats and podman stats
+const statsFormat = `{{.ID}}\t{{.Name}}\t{{.CPUPerc}}\t{{.MemUsage}}\t{{.MemPerc}}`
+
+// inspectFormat fields shared by docker inspect and podman inspect
+const inspectFormat = `{{.Name}}\t{{.State.Running}}\t{{.State.StartedAt}}`
+
+// startedLayouts StartedAt of docker and podman
+var startedLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999 -0700 MST"}
+
+// sizeUnits units of memory usage printed by docker (binary) and podman (decimal)
+var sizeUnits = map[string]float64{
+	"B":   1,
+	"kB":  1e3,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+}
+
+// container usage of a container, running ones only carry CPU and memory usage
+type container struct {
+	Name    string
+	Running bool
+	Started time.Time
+	CPU     float64 // percent
+	Mem     float64 // bytes
+	MemPerc float64 // percent
+}
+
+// GetMetrics implement probe.Extended, containers are only listed if the containers option is set
+func (lin Server) GetMetrics() ([]probe.Metric, error) {
+	cli := lin.Option("containers", "")
+	if cli == "" || !lin.Enabled("containers") {
+		return nil, nil
+	}
+	return Containers(lin.Run, cli)
+}
+
+// Containers list containers with the CLI, it is shared by backends running commands in other ways, e.g., locally
+func Containers(run Runner, cli string) ([]probe.Metric, error) {
+	if cli == "auto" {
+		output, err := run("command -v docker >/dev/null && echo docker || (command -v podman >/dev/null && echo podman) || true")
+		if err != nil {
+			return nil, err
+		}
+		cli = strings.TrimSpace(output)
+		if cli == "" {
+			return nil, fmt.Errorf("%w: neither docker nor podman is installed", probe.ErrUnsupported)
+		}
+	}
+
+	output, err := run(fmt.Sprintf("ids=$(%s ps -aq) || exit 1; [ -z \"$ids\" ] || %s inspect --format '%s' $ids", cli, cli, inspectFormat))
+	if err != nil {
+		return nil, err
+	}
+	containers, err := parseInspect(output)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err = run(fmt.Sprintf("%s stats --no-stream --format '%s'", cli, statsFormat))
+	if err != nil {
+		return nil, err
+	}
+	if err := parseStats(output, containers); err != nil {
+		return nil, err
+	}
+	return containerMetrics(containers, time.Now()), nil
+}
+
+// containerMetrics per container usage of running containers and the last time any container was started
+func containerMetrics(containers map[string]*container, now time.Time) []probe.Metric {
+	var ret []probe.Metric
+	var running float64
+	var last time.Time
+	for _, c := range containers {
+		if c.Started.After(last) {
+			last = c.Started
+		}
+		if !c.Running {
+			continue
+		}
+
+		running++
+		labels := map[string]string{"container": c.Name}
+		ret = append(ret,
+			probe.Metric{Name: "container_cpu_utilization", Help: "cpu utilization of the container in percent of one CPU", Labels: labels, Value: c.CPU},
+			probe.Metric{Name: "container_mem_bytes", Help: "memory used by the container in bytes", Labels: labels, Value: c.Mem},
+			probe.Metric{Name: "container_mem_utilization", Help: "memory used by the container in percent of its limit", Labels: labels, Value: c.MemPerc},
+		)
+		if !c.Started.IsZero() {
+			ret = append(ret, probe.Metric{Name: "container_uptime_seconds", Help: "seconds since the container was started", Labels: labels, Value: now.Sub(c.Started).Seconds()})
+		}
+	}
+
+	ret = append(ret, probe.Metric{Name: "containers_running", Help: "number of running containers", Value: running})
+	if !last.IsZero() {
+		ret = append(ret, probe.Metric{Name: "container_last_started_timestamp", Help: "unix time when any container was started for the last time", Value: float64(last.Unix())})
+	}
+	return ret
+}
+
+// parseInspect parse inspectFormat lines, e.g., "/web\ttrue\t2024-05-01T10:00:00.123456789Z"
+func parseInspect(output string) (map[string]*container, error) {
+	ret := map[string]*container{}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%w: unexpected container inspect output %q", probe.ErrParse, line)
+		}
+
+		c := &container{
+			Name:    strings.TrimPrefix(fields[0], "/"),
+			Running: fields[1] == "true",
+		}
+		for _, layout := range startedLayouts {
+			if t, err := time.Parse(layout, fields[2]); err == nil {
+				// Containers never started carry the zero time
+				if t.Year() > 1 {
+					c.Started = t
+				}
+				break
+			}
+		}
+		ret[c.Name] = c
+	}
+	return ret, nil
+}
+
+// parseStats parse statsFormat lines, e.g., "3f2a...\tweb\t0.52%\t10.5MiB / 1.944GiB\t0.53%"
+func parseStats(output string, containers map[string]*container) error {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			return fmt.Errorf("%w: unexpected container stats output %q", probe.ErrParse, line)
+		}
+
+		c, ok := containers[fields[1]]
+		if !ok {
+			// Started after it was inspected
+			c = &container{Name: fields[1], Running: true}
+			containers[c.Name] = c
+		}
+		c.CPU = parsePercent(fields[2])
+		c.Mem = parseSize(strings.SplitN(fields[3], "/", 2)[0])
+		c.MemPerc = parsePercent(fields[4])
+	}
+	return nil
+}
+
+func parsePercent(s string) float64 {
+	v, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	return v
+}
+
+// parseSize parse a size such as 10.5MiB or 2.06GB, unknown sizes (e.g., --) are 0
+func parseSize(s string) float64 {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i <= 0 {
+		return 0
+	}
+	v, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0
+	}
+	return v * sizeUnits[strings.TrimSpace(s[i:])]
+}
@@ -0,0 +1,157 @@
+package linux
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+func TestParseInspect(t *testing.T) {
+	started := time.Date(2024, 5, 1, 10, 0, 0, 123456789, time.UTC)
+	tests := []struct {
+		name   string
+		output string
+		want   map[string]*container
+		err    error
+	}{
+		{
+			name:   "docker",
+			output: "/web\ttrue\t2024-05-01T10:00:00.123456789Z\n/init\tfalse\t0001-01-01T00:00:00Z\n",
+			want: map[string]*container{
+				"web":  {Name: "web", Running: true, Started: started},
+				"init": {Name: "init"},
+			},
+		},
+		{
+			name:   "podman",
+			output: "web\ttrue\t2024-05-01 10:00:00.123456789 +0000 UTC\n",
+			want: map[string]*container{
+				"web": {Name: "web", Running: true, Started: started},
+			},
+		},
+		{
+			name:   "no container",
+			output: "\n",
+			want:   map[string]*container{},
+		},
+		{
+			name:   "unexpected output",
+			output: "Error: no such object\n",
+			err:    probe.ErrParse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseInspect(tt.output)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d containers, want %d", len(got), len(tt.want))
+			}
+			for name, c := range tt.want {
+				if got[name] == nil || !got[name].Started.Equal(c.Started) {
+					t.Fatalf("got %+v for %s, want %+v", got[name], name, c)
+				}
+				got[name].Started = c.Started
+				if !reflect.DeepEqual(got[name], c) {
+					t.Errorf("got %+v for %s, want %+v", got[name], name, c)
+				}
+			}
+		})
+	}
+}
+
+func TestParseStats(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		containers map[string]*container
+		want       map[string]*container
+		err        error
+	}{
+		{
+			name:       "docker binary units",
+			output:     "3f2a\tweb\t0.52%\t10.5MiB / 1.944GiB\t0.53%\n",
+			containers: map[string]*container{"web": {Name: "web", Running: true}},
+			want: map[string]*container{
+				"web": {Name: "web", Running: true, CPU: 0.52, Mem: 10.5 * (1 << 20), MemPerc: 0.53},
+			},
+		},
+		{
+			name:       "podman decimal units and a container started after inspect",
+			output:     "3f2a\tweb\t12.00%\t2.06GB / 8.2GB\t25.12%\n9c1d\tjob\t--\t-- / --\t--\n",
+			containers: map[string]*container{"web": {Name: "web", Running: true}},
+			want: map[string]*container{
+				"web": {Name: "web", Running: true, CPU: 12, Mem: 2.06e9, MemPerc: 25.12},
+				"job": {Name: "job", Running: true},
+			},
+		},
+		{
+			name:       "unexpected output",
+			output:     "CONTAINER ID NAME CPU %\n",
+			containers: map[string]*container{},
+			want:       map[string]*container{},
+			err:        probe.ErrParse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := parseStats(tt.output, tt.containers)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(tt.containers, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.containers, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainers(t *testing.T) {
+	outputs := map[string]string{
+		"command -v": "podman\n",
+		"inspect":    "web\ttrue\t2024-05-01 10:00:00 +0000 UTC\nold\tfalse\t2024-05-02 10:00:00 +0000 UTC\n",
+		"stats":      "3f2a\tweb\t1.50%\t100MB / 1GB\t10.00%\n",
+	}
+	run := func(cmd string) (string, error) {
+		for k, v := range outputs {
+			if strings.Contains(cmd, k) {
+				return v, nil
+			}
+		}
+		return "", errors.New("unexpected command " + cmd)
+	}
+
+	metrics, err := Containers(run, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, m := range metrics {
+		if m.Name != "container_uptime_seconds" {
+			got[m.Name] = m.Value
+		}
+	}
+	want := map[string]float64{
+		"container_cpu_utilization":        1.5,
+		"container_mem_bytes":              100e6,
+		"container_mem_utilization":        10,
+		"containers_running":               1,
+		"container_last_started_timestamp": float64(time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC).Unix()),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	outputs["command -v"] = "\n"
+	if _, err := Containers(run, "auto"); !errors.Is(err, probe.ErrUnsupported) {
+		t.Errorf("got error %v without docker and podman, want %v", err, probe.ErrUnsupported)
+	}
+}
@@ -4,21 +4,85 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/kckecheng/osprobe/probe"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 )
 
-// Server Linux server
+// Server Linux server, or other Unix servers reached over SSH
 type Server struct {
 	probe.Server
 	client *ssh.Client
+	os     string // output of uname -s, e.g., Linux or FreeBSD
 }
 
-// NewServer init
+func init() {
+	probe.Register(probe.Backend{
+		Type:    "linux",
+		New:     newProbe,
+		Port:    22,
+		Metrics: []string{"cpu", "mem", "nic", "containers"},
+		Hints: probe.Hints{
+			// ESXi and most Linux distributions show plain OpenSSH banners
+			Banners: map[string]float64{
+				"":         0.6,
+				"Ubuntu":   0.9,
+				"Debian":   0.9,
+				"Raspbian": 0.9,
+				"el7":      0.85,
+				"el8":      0.85,
+			},
+			Ports:          [][]int{{22}},
+			PortConfidence: 0.3,
+		},
+	})
+	// The OS is told by uname -s after login, the type only tells what the server is
+	probe.Register(probe.Backend{
+		Type:    "unix",
+		New:     newProbe,
+		Port:    22,
+		Metrics: []string{"cpu", "mem", "nic", "containers"},
+		Hints: probe.Hints{
+			Banners: map[string]float64{
+				"FreeBSD": 0.95,
+				"Sun_SSH": 0.9,
+				"SunSSH":  0.9,
+			},
+		},
+	})
+}
+
+func newProbe(server probe.Server) (probe.Probe, error) {
+	p, err := Dial(server)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Dial connect with public key authentication if a key is set, password authentication otherwise,
+// labels, options and enabled metrics of the server are kept
+func Dial(server probe.Server) (Server, error) {
+	var p Server
+	var err error
+	if server.Key != "" {
+		p, err = NewServerWithKey(server.Host, server.User, server.Key, server.Port)
+	} else {
+		p, err = NewServer(server.Host, server.User, server.Password, server.Port)
+	}
+	if err != nil {
+		return p, err
+	}
+	p.Server = server
+	return p, nil
+}
+
+// NewServer init with password authentication
 func NewServer(host, user, password string, port int) (Server, error) {
 	server := Server{
 		Server: probe.Server{
@@ -26,80 +90,228 @@ func NewServer(host, user, password string, port int) (Server, error) {
 			User:     user,
 			Password: password,
 			Port:     port,
-			Type:     "esxi",
+			Type:     "linux",
+		},
+	}
+	if !server.Valid() {
+		return server, errors.New("Inputs are not valid, please check")
+	}
+
+	return server, server.connect(ssh.Password(password))
+}
+
+// NewServerWithKey init with public key authentication, key is the path of a private key or the PEM encoded key itself
+func NewServerWithKey(host, user, key string, port int) (Server, error) {
+	server := Server{
+		Server: probe.Server{
+			Host: host,
+			User: user,
+			Key:  key,
+			Port: port,
+			Type: "linux",
 		},
 	}
 	if !server.Valid() {
 		return server, errors.New("Inputs are not valid, please check")
 	}
 
+	pem := []byte(key)
+	if !strings.Contains(key, "PRIVATE KEY") {
+		var err error
+		pem, err = ioutil.ReadFile(key)
+		if err != nil {
+			log.Errorf("Fail to read private key %s due to %s", key, err)
+			return server, err
+		}
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		log.Errorf("Fail to parse private key for %s due to %s", host, err)
+		return server, err
+	}
+
+	return server, server.connect(ssh.PublicKeys(signer))
+}
+
+func (lin *Server) connect(auth ssh.AuthMethod) error {
 	config := &ssh.ClientConfig{
-		User: user,
+		User: lin.User,
 		Auth: []ssh.AuthMethod{
-			ssh.Password(password),
+			auth,
 		},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", host, port), config)
+	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", lin.Host, lin.Port), config)
 	if err != nil {
-		log.Errorf("Fail to establish ssh connection to %s due to %s", host, err)
-		return server, err
+		log.Errorf("Fail to establish ssh connection to %s due to %s", lin.Host, err)
+		return err
 	}
 
-	server.client = client
-	return server, nil
+	lin.client = client
+
+	output, err := lin.Run("uname -s")
+	if err != nil {
+		log.Warnf("Fail to tell the OS of %s, Linux is assumed", lin.Host)
+		output = "Linux"
+	}
+	lin.os = strings.TrimSpace(output)
+	if _, ok := platforms[lin.os]; !ok && lin.os != "Linux" {
+		log.Warnf("OS %s of %s is not supported, Linux is assumed", lin.os, lin.Host)
+	}
+	return nil
 }
 
 // GetCPUUsage implement interface
 func (lin Server) GetCPUUsage() (float64, error) {
-	cmd := "head -n1 /proc/stat"
+	if p, ok := platforms[lin.os]; ok {
+		return p.cpu(lin.Run)
+	}
+	cmd := "head -n1 /proc/stat; sleep 1; head -n1 /proc/stat"
 
-	output, err := lin.run(cmd)
+	output, err := lin.Run(cmd)
 	if err != nil {
 		log.Errorf("Fail to query CPU usage: %s", err)
 		return 0, err
 	}
-	r, _ := regexp.Compile(`\d+`)
-	fields := r.FindAllString(output, -1)
-
-	var values []float64
-	var total float64
-	for _, field := range fields {
-		v, _ := strconv.ParseFloat(field, 64)
-		total += v
-		values = append(values, v)
-	}
-	return values[2] * 100 / total, nil
+	return ParseCPUUsage(output)
 }
 
 // GetMemUsage implement interface
 func (lin Server) GetMemUsage() (float64, error) {
+	if p, ok := platforms[lin.os]; ok {
+		return p.mem(lin.Run)
+	}
 	cmd := "head -n2 /proc/meminfo"
 
-	output, err := lin.run(cmd)
+	output, err := lin.Run(cmd)
 	if err != nil {
 		log.Errorf("Fail to query memory usage: %s", err)
 		return 0, err
 	}
+	return ParseMemUsage(output)
+}
+
+// GetLocalDiskUsage implement interface
+func (lin Server) GetLocalDiskUsage() (map[string]float64, error) {
+	return nil, probe.ErrUnsupported
+}
+
+// GetNICUsage implement interface, sent and received bytes since boot are returned for each NIC
+func (lin Server) GetNICUsage() (map[string]map[string]float64, error) {
+	if p, ok := platforms[lin.os]; ok {
+		if p.nic == nil {
+			return nil, fmt.Errorf("%w: NIC counters are not supported on %s", probe.ErrUnsupported, lin.os)
+		}
+		return p.nic(lin.Run)
+	}
+	cmd := "cat /proc/net/dev"
+
+	output, err := lin.Run(cmd)
+	if err != nil {
+		log.Errorf("Fail to query NIC usage: %s", err)
+		return nil, err
+	}
+	return ParseNICUsage(output)
+}
+
+// Close implement interface, the SSH connection is closed
+func (lin Server) Close() error {
+	if lin.client == nil {
+		return nil
+	}
+	return lin.client.Close()
+}
+
+// ParseCPUUsage parse two samples of the cpu line of /proc/stat, the usage in between is returned.
+// iowait is counted as idle, guest and guest_nice are left out since user and nice include them.
+func ParseCPUUsage(output string) (float64, error) {
+	var samples [][]float64
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		if len(fields) > 9 {
+			fields = fields[:9]
+		}
+		var values []float64
+		for _, field := range fields[1:] {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return 0, fmt.Errorf("%w: unexpected /proc/stat output %q", probe.ErrParse, line)
+			}
+			values = append(values, v)
+		}
+		samples = append(samples, values)
+	}
+	if len(samples) != 2 || len(samples[0]) != len(samples[1]) {
+		return 0, fmt.Errorf("%w: unexpected /proc/stat output %q", probe.ErrParse, output)
+	}
+
+	var total, idle float64
+	for i := range samples[0] {
+		delta := samples[1][i] - samples[0][i]
+		total += delta
+		// idle and iowait
+		if i == 3 || i == 4 {
+			idle += delta
+		}
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("%w: /proc/stat does not change %q", probe.ErrParse, output)
+	}
+	return 100 - idle*100/total, nil
+}
 
+// ParseMemUsage parse MemTotal and MemFree, the first two lines of /proc/meminfo
+func ParseMemUsage(output string) (float64, error) {
+	lines := strings.SplitN(output, "\n", 3)
+	if len(lines) > 2 {
+		output = strings.Join(lines[:2], "\n")
+	}
 	r, _ := regexp.Compile(`\d+`)
 	fields := r.FindAllString(output, -1)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("%w: unexpected /proc/meminfo output %q", probe.ErrParse, output)
+	}
 	memTotal, _ := strconv.ParseFloat(fields[0], 64)
 	memFree, _ := strconv.ParseFloat(fields[1], 64)
+	if memTotal == 0 {
+		return 0, fmt.Errorf("%w: unexpected /proc/meminfo output %q", probe.ErrParse, output)
+	}
 	return (memTotal - memFree) * 100 / memTotal, nil
 }
 
-// GetLocalDiskUsage implement interface
-func (lin Server) GetLocalDiskUsage() (map[string]float64, error) {
-	return nil, errors.New("Not implemented")
-}
+// ParseNICUsage parse /proc/net/dev, sent and received bytes since boot are returned for each NIC
+func ParseNICUsage(output string) (map[string]map[string]float64, error) {
+	ret := map[string]map[string]float64{}
+	for _, line := range strings.Split(output, "\n") {
+		// Skip headers, statistics lines look like "eth0: rx_bytes rx_packets ... tx_bytes ..."
+		cols := strings.SplitN(line, ":", 2)
+		if len(cols) != 2 {
+			continue
+		}
+		fields := strings.Fields(cols[1])
+		if len(fields) < 16 {
+			continue
+		}
 
-// GetNICUsage implement interface
-func (lin Server) GetNICUsage() (map[string]map[string]float64, error) {
-	return nil, errors.New("Not implemented")
+		name := strings.TrimSpace(cols[0])
+		received, _ := strconv.ParseFloat(fields[0], 64)
+		sent, _ := strconv.ParseFloat(fields[8], 64)
+		ret[name] = map[string]float64{
+			"received": received,
+			"sent":     sent,
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("%w: unexpected /proc/net/dev output %q", probe.ErrParse, output)
+	}
+	return ret, nil
 }
 
-func (lin Server) run(cmd string) (string, error) {
+// Run execute a command over SSH and return its output, backends built on the SSH transport such as libvirt share it
+func (lin Server) Run(cmd string) (string, error) {
 	session, err := lin.client.NewSession()
 	if err != nil {
 		log.Error("Fail to create session", err)
@@ -0,0 +1,66 @@
+package linux
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+func TestParseCPUUsage(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   float64
+		err    error
+	}{
+		{
+			name:   "two samples",
+			output: "cpu  100 0 50 800 50 0 0 0 0 0\ncpu  200 0 100 1500 200 0 0 0 0 0\n",
+			want:   15,
+		},
+		{
+			name:   "guest time is part of user time",
+			output: "cpu  100 0 0 900 0 0 0 0 50 0\ncpu  200 0 0 1800 0 0 0 0 150 0\n",
+			want:   10,
+		},
+		{
+			name:   "kernels with four columns",
+			output: "cpu  100 0 0 900\ncpu  150 0 50 1300\n",
+			want:   20,
+		},
+		{
+			name:   "per CPU lines are ignored",
+			output: "cpu  100 0 0 900 0\ncpu0 100 0 0 900 0\ncpu  200 0 0 1800 0\ncpu0 200 0 0 1800 0\n",
+			want:   10,
+		},
+		{
+			name:   "since boot",
+			output: "cpu  100 0 50 800 50 0 0 0 0 0\n",
+			err:    probe.ErrParse,
+		},
+		{
+			name:   "no change",
+			output: "cpu  100 0 50 800 50\ncpu  100 0 50 800 50\n",
+			err:    probe.ErrParse,
+		},
+		{
+			name:   "not a number",
+			output: "cpu  100 0 50 800 x\ncpu  100 0 50 900 0\n",
+			err:    probe.ErrParse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCPUUsage(tt.output)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package linux
+
+/*
+	Other Unix systems are probed over the same SSH transport, the OS is told by uname -s after login so that
+	the scanner only needs to recognize an SSH server:
+	- FreeBSD: sysctl kern.cp_time and vm.stats, netstat -ibn;
+	- Darwin (macOS): top -l, vm_stat and sysctl hw.memsize, netstat -ibn;
+	- AIX: vmstat and svmon, NIC counters are not supported;
+	- SunOS (Solaris/illumos): kstat.
+	CPU utilization of FreeBSD and Solaris is computed with two samples taken one second apart.
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+// Runner run a command on the server and return its output
+type Runner func(cmd string) (string, error)
+
+// platform parsers of a Unix other than Linux
+type platform struct {
+	cpu func(run Runner) (float64, error)
+	mem func(run Runner) (float64, error)
+	nic func(run Runner) (map[string]map[string]float64, error)
+}
+
+// platforms by the output of uname -s
+var platforms = map[string]platform{
+	"FreeBSD": {
+		cpu: func(run Runner) (float64, error) {
+			output, err := run("sysctl -n kern.cp_time; sleep 1; sysctl -n kern.cp_time")
+			if err != nil {
+				return 0, err
+			}
+			return parseCPTime(output)
+		},
+		mem: func(run Runner) (float64, error) {
+			output, err := run("sysctl -n vm.stats.vm.v_page_count vm.stats.vm.v_free_count vm.stats.vm.v_inactive_count")
+			if err != nil {
+				return 0, err
+			}
+			return parseFreeBSDMem(output)
+		},
+		nic: func(run Runner) (map[string]map[string]float64, error) {
+			output, err := run("netstat -ibn")
+			if err != nil {
+				return nil, err
+			}
+			return parseNetstat(output)
+		},
+	},
+	"Darwin": {
+		cpu: func(run Runner) (float64, error) {
+			// The first sample of top is the average since boot
+			output, err := run("top -l 2 -n 0 -s 1 | grep '^CPU usage'")
+			if err != nil {
+				return 0, err
+			}
+			return parseTopCPU(output)
+		},
+		mem: func(run Runner) (float64, error) {
+			output, err := run("sysctl -n hw.memsize; vm_stat")
+			if err != nil {
+				return 0, err
+			}
+			return parseVMStat(output)
+		},
+		nic: func(run Runner) (map[string]map[string]float64, error) {
+			output, err := run("netstat -ibn")
+			if err != nil {
+				return nil, err
+			}
+			return parseNetstat(output)
+		},
+	},
+	"AIX": {
+		cpu: func(run Runner) (float64, error) {
+			output, err := run("vmstat 1 2")
+			if err != nil {
+				return 0, err
+			}
+			return parseVmstatIdle(output)
+		},
+		mem: func(run Runner) (float64, error) {
+			output, err := run("svmon -G -O unit=KB")
+			if err != nil {
+				return 0, err
+			}
+			return parseSvmon(output)
+		},
+	},
+	"SunOS": {
+		cpu: func(run Runner) (float64, error) {
+			cmd := "kstat -p 'cpu::sys:/^cpu_ticks_(idle|user|kernel|wait)$/'"
+			output, err := run(fmt.Sprintf("%s; echo ---; sleep 1; %s", cmd, cmd))
+			if err != nil {
+				return 0, err
+			}
+			return parseKstatCPU(output)
+		},
+		mem: func(run Runner) (float64, error) {
+			output, err := run("kstat -p unix:0:system_pages:physmem unix:0:system_pages:freemem")
+			if err != nil {
+				return 0, err
+			}
+			return parseKstatMem(output)
+		},
+		nic: func(run Runner) (map[string]map[string]float64, error) {
+			output, err := run("kstat -p -c net ':::/^[ro]bytes64$/'")
+			if err != nil {
+				return nil, err
+			}
+			return parseKstatNIC(output)
+		},
+	},
+}
+
+// parseCPTime parse two samples of kern.cp_time: user nice sys intr idle ticks
+func parseCPTime(output string) (float64, error) {
+	lines := nonEmptyLines(output)
+	if len(lines) != 2 {
+		return 0, fmt.Errorf("%w: unexpected kern.cp_time output %q", probe.ErrParse, output)
+	}
+	first, err := parseNumbers(lines[0], 5)
+	if err != nil {
+		return 0, err
+	}
+	second, err := parseNumbers(lines[1], 5)
+	if err != nil {
+		return 0, err
+	}
+
+	var total float64
+	for i := range first {
+		total += second[i] - first[i]
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("%w: kern.cp_time does not change %q", probe.ErrParse, output)
+	}
+	return 100 - (second[4]-first[4])*100/total, nil
+}
+
+// parseFreeBSDMem parse page count, free pages and inactive pages, inactive pages can be reclaimed
+func parseFreeBSDMem(output string) (float64, error) {
+	values, err := parseNumbers(strings.Join(nonEmptyLines(output), " "), 3)
+	if err != nil || values[0] == 0 {
+		return 0, fmt.Errorf("%w: unexpected vm.stats output %q", probe.ErrParse, output)
+	}
+	return (values[0] - values[1] - values[2]) * 100 / values[0], nil
+}
+
+var topCPURegex = regexp.MustCompile(`([\d.]+)% idle`)
+
+// parseTopCPU use the last sample of top, e.g., "CPU usage: 5.26% user, 10.52% sys, 84.21% idle"
+func parseTopCPU(output string) (float64, error) {
+	lines := nonEmptyLines(output)
+	if len(lines) == 0 {
+		return 0, fmt.Errorf("%w: unexpected top output %q", probe.ErrParse, output)
+	}
+	m := topCPURegex.FindStringSubmatch(lines[len(lines)-1])
+	if m == nil {
+		return 0, fmt.Errorf("%w: unexpected top output %q", probe.ErrParse, output)
+	}
+	idle, _ := strconv.ParseFloat(m[1], 64)
+	return 100 - idle, nil
+}
+
+var (
+	vmStatPageSizeRegex = regexp.MustCompile(`page size of (\d+) bytes`)
+	vmStatRegex         = regexp.MustCompile(`^(Pages [^:]+):\s+(\d+)\.?$`)
+)
+
+// parseVMStat parse hw.memsize followed by vm_stat, active, wired and compressed pages are used
+func parseVMStat(output string) (float64, error) {
+	lines := nonEmptyLines(output)
+	if len(lines) < 2 {
+		return 0, fmt.Errorf("%w: unexpected vm_stat output %q", probe.ErrParse, output)
+	}
+	total, err := strconv.ParseFloat(strings.TrimSpace(lines[0]), 64)
+	if err != nil || total == 0 {
+		return 0, fmt.Errorf("%w: unexpected hw.memsize output %q", probe.ErrParse, lines[0])
+	}
+	m := vmStatPageSizeRegex.FindStringSubmatch(lines[1])
+	if m == nil {
+		return 0, fmt.Errorf("%w: unexpected vm_stat output %q", probe.ErrParse, output)
+	}
+	pageSize, _ := strconv.ParseFloat(m[1], 64)
+
+	pages := map[string]float64{}
+	for _, line := range lines[2:] {
+		if m := vmStatRegex.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			pages[m[1]], _ = strconv.ParseFloat(m[2], 64)
+		}
+	}
+	used := pages["Pages active"] + pages["Pages wired down"] + pages["Pages occupied by compressor"]
+	return used * pageSize * 100 / total, nil
+}
+
+// parseNetstat parse netstat -ibn of BSDs, link level rows (Network <Link#N>) carry the counters of each NIC
+func parseNetstat(output string) (map[string]map[string]float64, error) {
+	lines := nonEmptyLines(output)
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: unexpected netstat output %q", probe.ErrParse, output)
+	}
+	header := strings.Fields(lines[0])
+	columns := map[string]int{}
+	for i, h := range header {
+		columns[h] = i
+	}
+	ib, ok1 := columns["Ibytes"]
+	ob, ok2 := columns["Obytes"]
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("%w: unexpected netstat header %q", probe.ErrParse, lines[0])
+	}
+
+	ret := map[string]map[string]float64{}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.HasPrefix(fields[2], "<Link") || strings.HasPrefix(fields[0], "lo") {
+			continue
+		}
+		// The address is empty for NICs such as tunnels, later columns shift left
+		shift := len(header) - len(fields)
+		if shift < 0 || ib-shift < 3 {
+			continue
+		}
+		received, _ := strconv.ParseFloat(fields[ib-shift], 64)
+		sent, _ := strconv.ParseFloat(fields[ob-shift], 64)
+		ret[strings.TrimSuffix(fields[0], "*")] = map[string]float64{
+			"received": received,
+			"sent":     sent,
+		}
+	}
+	return ret, nil
+}
+
+// parseVmstatIdle use the id column of the last vmstat line, the first one is the average since boot
+func parseVmstatIdle(output string) (float64, error) {
+	lines := nonEmptyLines(output)
+	index := -1
+	for _, line := range lines {
+		for i, f := range strings.Fields(line) {
+			if f == "id" {
+				index = i
+			}
+		}
+		if index >= 0 {
+			break
+		}
+	}
+	if index < 0 || len(lines) == 0 {
+		return 0, fmt.Errorf("%w: unexpected vmstat output %q", probe.ErrParse, output)
+	}
+
+	fields := strings.Fields(lines[len(lines)-1])
+	if index >= len(fields) {
+		return 0, fmt.Errorf("%w: unexpected vmstat output %q", probe.ErrParse, output)
+	}
+	idle, err := strconv.ParseFloat(fields[index], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: unexpected vmstat output %q", probe.ErrParse, output)
+	}
+	return 100 - idle, nil
+}
+
+// parseSvmon parse the memory row of svmon -G, available memory is preferred to free memory since file caches are counted as in use
+func parseSvmon(output string) (float64, error) {
+	var header []string
+	for _, line := range nonEmptyLines(output) {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "size" {
+			header = fields
+			continue
+		}
+		if len(fields) == 0 || fields[0] != "memory" || header == nil {
+			continue
+		}
+
+		values := map[string]float64{}
+		for i, h := range header {
+			if i+1 < len(fields) {
+				values[h], _ = strconv.ParseFloat(fields[i+1], 64)
+			}
+		}
+		size := values["size"]
+		if size == 0 {
+			break
+		}
+		if available, ok := values["available"]; ok {
+			return (size - available) * 100 / size, nil
+		}
+		return values["inuse"] * 100 / size, nil
+	}
+	return 0, fmt.Errorf("%w: unexpected svmon output %q", probe.ErrParse, output)
+}
+
+// parseKstatCPU parse two samples of cpu_ticks_* separated by ---, e.g., "cpu:0:sys:cpu_ticks_idle	1234"
+func parseKstatCPU(output string) (float64, error) {
+	samples := strings.SplitN(output, "---", 2)
+	if len(samples) != 2 {
+		return 0, fmt.Errorf("%w: unexpected kstat output %q", probe.ErrParse, output)
+	}
+	first, second := sumKstat(samples[0]), sumKstat(samples[1])
+
+	var total float64
+	for _, k := range []string{"cpu_ticks_idle", "cpu_ticks_user", "cpu_ticks_kernel", "cpu_ticks_wait"} {
+		total += second[k] - first[k]
+	}
+	if total <= 0 {
+		return 0, fmt.Errorf("%w: cpu_ticks do not change %q", probe.ErrParse, output)
+	}
+	return 100 - (second["cpu_ticks_idle"]-first["cpu_ticks_idle"])*100/total, nil
+}
+
+// parseKstatMem parse physmem and freemem pages
+func parseKstatMem(output string) (float64, error) {
+	stats := sumKstat(output)
+	physmem := stats["physmem"]
+	if physmem == 0 {
+		return 0, fmt.Errorf("%w: unexpected kstat output %q", probe.ErrParse, output)
+	}
+	return (physmem - stats["freemem"]) * 100 / physmem, nil
+}
+
+// parseKstatNIC parse rbytes64/obytes64 of the net class, e.g., "link:0:net0:rbytes64	1234"
+func parseKstatNIC(output string) (map[string]map[string]float64, error) {
+	ret := map[string]map[string]float64{}
+	for _, line := range nonEmptyLines(output) {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		keys := strings.Split(fields[0], ":")
+		if len(keys) != 4 || strings.HasPrefix(keys[2], "lo") {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+
+		name := keys[2]
+		if _, ok := ret[name]; !ok {
+			ret[name] = map[string]float64{}
+		}
+		switch keys[3] {
+		case "rbytes64":
+			ret[name]["received"] = v
+		case "obytes64":
+			ret[name]["sent"] = v
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("%w: unexpected kstat output %q", probe.ErrParse, output)
+	}
+	return ret, nil
+}
+
+// sumKstat sum kstat -p values by statistic name across instances
+func sumKstat(output string) map[string]float64 {
+	ret := map[string]float64{}
+	for _, line := range nonEmptyLines(output) {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		keys := strings.Split(fields[0], ":")
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		ret[keys[len(keys)-1]] += v
+	}
+	return ret
+}
+
+// parseNumbers parse at least n numbers separated by spaces
+func parseNumbers(line string, n int) ([]float64, error) {
+	fields := strings.Fields(line)
+	if len(fields) < n {
+		return nil, fmt.Errorf("%w: %d numbers are expected in %q", probe.ErrParse, n, line)
+	}
+	var ret []float64
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a number", probe.ErrParse, f)
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+func nonEmptyLines(output string) []string {
+	var ret []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) != "" {
+			ret = append(ret, line)
+		}
+	}
+	return ret
+}
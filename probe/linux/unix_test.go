@@ -0,0 +1,252 @@
+package linux
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+func TestParseUnixUsage(t *testing.T) {
+	tests := []struct {
+		name   string
+		parse  func(string) (float64, error)
+		output string
+		want   float64
+		err    error
+	}{
+		{
+			name:   "FreeBSD kern.cp_time",
+			parse:  parseCPTime,
+			output: "100 0 50 0 850\n250 0 100 0 1650\n",
+			want:   20,
+		},
+		{
+			name:   "FreeBSD kern.cp_time without change",
+			parse:  parseCPTime,
+			output: "100 0 50 0 850\n100 0 50 0 850\n",
+			err:    probe.ErrParse,
+		},
+		{
+			name:   "FreeBSD kern.cp_time with a single sample",
+			parse:  parseCPTime,
+			output: "100 0 50 0 850\n",
+			err:    probe.ErrParse,
+		},
+		{
+			name:   "FreeBSD vm.stats",
+			parse:  parseFreeBSDMem,
+			output: "1000\n100\n400\n",
+			want:   50,
+		},
+		{
+			name:   "FreeBSD vm.stats without pages",
+			parse:  parseFreeBSDMem,
+			output: "0\n0\n0\n",
+			err:    probe.ErrParse,
+		},
+		{
+			name:   "macOS top",
+			parse:  parseTopCPU,
+			output: "CPU usage: 3.0% user, 2.0% sys, 95.0% idle\nCPU usage: 10.0% user, 15.0% sys, 75.0% idle\n",
+			want:   25,
+		},
+		{
+			name:   "macOS top without idle",
+			parse:  parseTopCPU,
+			output: "CPU usage: n/a\n",
+			err:    probe.ErrParse,
+		},
+		{
+			name:  "macOS vm_stat",
+			parse: parseVMStat,
+			output: `17179869184
+Mach Virtual Memory Statistics: (page size of 4096 bytes)
+Pages free:                               10000.
+Pages active:                            700000.
+Pages inactive:                          500000.
+Pages wired down:                        300000.
+Pages occupied by compressor:             48576.
+`,
+			want: 25,
+		},
+		{
+			name:   "macOS vm_stat without page size",
+			parse:  parseVMStat,
+			output: "17179869184\nPages active: 700000.\n",
+			err:    probe.ErrParse,
+		},
+		{
+			name:  "AIX vmstat",
+			parse: parseVmstatIdle,
+			output: `
+System configuration: lcpu=4 mem=8192MB ent=0.20
+
+kthr    memory              page              faults              cpu
+----- ----------- ------------------------ ------------ -----------------------
+ r  b   avm   fre  re  pi  po  fr   sr  cy  in   sy  cs us sy id wa    pc    ec
+ 1  0 400000 1000000   0   0   0   0    0   0  10  200 150  5  3 90  2  0.02  10.0
+ 2  0 400100 999000   0   0   0   0    0   0  12  300 180 20 10 70  0  0.05  25.0
+`,
+			want: 30,
+		},
+		{
+			name:   "AIX vmstat without the id column",
+			parse:  parseVmstatIdle,
+			output: "r b avm\n1 0 400000\n",
+			err:    probe.ErrParse,
+		},
+		{
+			name:  "AIX svmon with available memory",
+			parse: parseSvmon,
+			output: `Unit: KB
+--------------------------------------------------------------------------------------
+               size       inuse        free         pin     virtual  available   mmode
+memory      8388608     6291456     2097152     1048576     4194304    4194304     Ded
+pg space    1048576       10240
+`,
+			want: 50,
+		},
+		{
+			name:  "AIX svmon without available memory",
+			parse: parseSvmon,
+			output: `               size       inuse        free         pin     virtual
+memory      8388608     6291456     2097152     1048576     4194304
+`,
+			want: 75,
+		},
+		{
+			name:   "AIX svmon without the memory row",
+			parse:  parseSvmon,
+			output: "Unit: KB\n",
+			err:    probe.ErrParse,
+		},
+		{
+			name:  "Solaris kstat cpu_ticks",
+			parse: parseKstatCPU,
+			output: `cpu:0:sys:cpu_ticks_idle	1000
+cpu:0:sys:cpu_ticks_kernel	100
+cpu:0:sys:cpu_ticks_user	200
+cpu:0:sys:cpu_ticks_wait	0
+cpu:1:sys:cpu_ticks_idle	1000
+cpu:1:sys:cpu_ticks_kernel	100
+cpu:1:sys:cpu_ticks_user	200
+cpu:1:sys:cpu_ticks_wait	0
+---
+cpu:0:sys:cpu_ticks_idle	1150
+cpu:0:sys:cpu_ticks_kernel	120
+cpu:0:sys:cpu_ticks_user	230
+cpu:0:sys:cpu_ticks_wait	0
+cpu:1:sys:cpu_ticks_idle	1150
+cpu:1:sys:cpu_ticks_kernel	120
+cpu:1:sys:cpu_ticks_user	230
+cpu:1:sys:cpu_ticks_wait	0
+`,
+			want: 25,
+		},
+		{
+			name:   "Solaris kstat cpu_ticks with a single sample",
+			parse:  parseKstatCPU,
+			output: "cpu:0:sys:cpu_ticks_idle	1000\n",
+			err:    probe.ErrParse,
+		},
+		{
+			name:   "Solaris kstat system_pages",
+			parse:  parseKstatMem,
+			output: "unix:0:system_pages:physmem\t1000\nunix:0:system_pages:freemem\t250\n",
+			want:   75,
+		},
+		{
+			name:   "Solaris kstat without physmem",
+			parse:  parseKstatMem,
+			output: "unix:0:system_pages:freemem\t250\n",
+			err:    probe.ErrParse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parse(tt.output)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUnixNICUsage(t *testing.T) {
+	tests := []struct {
+		name   string
+		parse  func(string) (map[string]map[string]float64, error)
+		output string
+		want   map[string]map[string]float64
+		err    error
+	}{
+		{
+			name:  "FreeBSD netstat",
+			parse: parseNetstat,
+			output: `Name    Mtu Network       Address              Ipkts Ierrs Idrop     Ibytes    Opkts Oerrs     Obytes  Coll
+em0    1500 <Link#1>      08:00:27:aa:bb:cc    12345     0     0    9876543     6789     0    1234567     0
+em0       - 192.168.1.0/24 192.168.1.10         1000     -     -     100000      900     -      90000     -
+lo0   16384 <Link#2>      lo0                     10     0     0       1000       10     0       1000     0
+gif0*  1280 <Link#3>                               5     0     0        700        6     0        800     0
+`,
+			want: map[string]map[string]float64{
+				"em0":  {"received": 9876543, "sent": 1234567},
+				"gif0": {"received": 700, "sent": 800},
+			},
+		},
+		{
+			name:  "macOS netstat",
+			parse: parseNetstat,
+			output: `Name       Mtu   Network       Address            Ipkts Ierrs     Ibytes    Opkts Oerrs     Obytes  Coll
+lo0        16384 <Link#1>                         5000     0     500000     5000     0     500000     0
+en0        1500  <Link#4>    a4:83:e7:00:00:01   80000     0  100000000    40000     0    5000000     0
+en0        1500  192.168.1     192.168.1.20      70000     -   90000000    30000     -    4000000     -
+`,
+			want: map[string]map[string]float64{
+				"en0": {"received": 100000000, "sent": 5000000},
+			},
+		},
+		{
+			name:   "netstat without byte counters",
+			parse:  parseNetstat,
+			output: "Name Mtu Network Address Ipkts Ierrs Opkts Oerrs\n",
+			err:    probe.ErrParse,
+		},
+		{
+			name:  "Solaris kstat",
+			parse: parseKstatNIC,
+			output: `link:0:net0:obytes64	2000
+link:0:net0:rbytes64	1000
+link:0:lo0:rbytes64	5
+`,
+			want: map[string]map[string]float64{
+				"net0": {"received": 1000, "sent": 2000},
+			},
+		},
+		{
+			name:   "Solaris kstat without NICs",
+			parse:  parseKstatNIC,
+			output: "\n",
+			err:    probe.ErrParse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parse(tt.output)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package local
+
+/*
+	The machine osprobe runs on, /proc is read directly so that no remote login is needed, e.g., osprobe deployed
+	as an agent on hosts which do not allow SSH. Parsing is shared with the linux backend so that both report the
+	same metrics. Options:
+	- proc: mount point of procfs, e.g., /host/proc if osprobe runs in a container, /proc by default;
+	- containers: Docker or Podman CLI to list containers with, see the linux backend.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/kckecheng/osprobe/probe"
+	"github.com/kckecheng/osprobe/probe/linux"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	probe.Register(probe.Backend{
+		Type:    "local",
+		New:     newProbe,
+		Local:   true,
+		Metrics: []string{"cpu", "mem", "nic", "containers"},
+	})
+}
+
+func newProbe(server probe.Server) (probe.Probe, error) {
+	p, err := NewServer(server)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Server the local machine
+type Server struct {
+	probe.Server
+	proc string
+}
+
+// NewServer init with the procfs mount point, only Linux is supported
+func NewServer(s probe.Server) (Server, error) {
+	server := Server{Server: s, proc: s.Option("proc", "/proc")}
+	server.Type = "local"
+	if !server.Valid() {
+		return server, errors.New("Inputs are not valid, please check")
+	}
+	if runtime.GOOS != "linux" {
+		return server, fmt.Errorf("%w: local probe is only supported on Linux, not %s", probe.ErrUnsupported, runtime.GOOS)
+	}
+	return server, nil
+}
+
+// GetCPUUsage implement interface, /proc/stat is sampled twice one second apart as over SSH
+func (l Server) GetCPUUsage() (float64, error) {
+	first, err := l.read("stat")
+	if err != nil {
+		return 0, err
+	}
+	time.Sleep(time.Second)
+	second, err := l.read("stat")
+	if err != nil {
+		return 0, err
+	}
+	return linux.ParseCPUUsage(first + "\n" + second)
+}
+
+// GetMemUsage implement interface
+func (l Server) GetMemUsage() (float64, error) {
+	output, err := l.read("meminfo")
+	if err != nil {
+		return 0, err
+	}
+	return linux.ParseMemUsage(output)
+}
+
+// GetLocalDiskUsage implement interface
+func (l Server) GetLocalDiskUsage() (map[string]float64, error) {
+	return nil, probe.ErrUnsupported
+}
+
+// GetNICUsage implement interface, sent and received bytes since boot are returned for each NIC
+func (l Server) GetNICUsage() (map[string]map[string]float64, error) {
+	output, err := l.read("net/dev")
+	if err != nil {
+		return nil, err
+	}
+	return linux.ParseNICUsage(output)
+}
+
+// Close implement interface, nothing is kept open
+func (l Server) Close() error {
+	return nil
+}
+
+// GetMetrics implement probe.Extended, containers are only listed if the containers option is set
+func (l Server) GetMetrics() ([]probe.Metric, error) {
+	cli := l.Option("containers", "")
+	if cli == "" || !l.Enabled("containers") {
+		return nil, nil
+	}
+	return linux.Containers(run, cli)
+}
+
+// read read a file under the procfs mount point
+func (l Server) read(name string) (string, error) {
+	path := filepath.Join(l.proc, name)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Errorf("Fail to read %s due to %s", path, err)
+		return "", err
+	}
+	return string(content), nil
+}
+
+// run execute a command with the shell, the same way as it is run over SSH
+func run(cmd string) (string, error) {
+	output, err := exec.Command("sh", "-c", cmd).Output()
+	if err != nil {
+		log.Errorf("Fail to run command %s due to %s", cmd, err)
+		return "", err
+	}
+	return string(output), nil
+}
@@ -0,0 +1,461 @@
+package ovirt
+
+/*
+	oVirt/RHV engines probed through the REST API v4 (443 by default). With a user, e.g., admin@internal,
+	an SSO token is issued for the user and the password, without a user the password is used as an SSO
+	access token directly. Hosts and VMs managed by the engine are reported.
+	Options:
+	- node: host whose usage is reported as the server usage, usage of all hosts by default;
+	- insecure, ca_file and timeout: TLS verification and request timeout, see probe.NewHTTPClient.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kckecheng/osprobe/probe"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	probe.Register(probe.Backend{
+		Type:         "ovirt",
+		New:          newProbe,
+		Port:         443,
+		UserOptional: true,
+		Metrics:      []string{"cpu", "mem", "nic", "node", "vm"},
+	})
+}
+
+func newProbe(server probe.Server) (probe.Probe, error) {
+	p, err := NewServer(server)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// states VM states mapped to the ones of other hypervisors
+var states = map[string]string{
+	"up":   "running",
+	"down": "shutoff",
+}
+
+// Server oVirt engine
+type Server struct {
+	probe.Server
+	client *http.Client
+	base   string
+	token  string
+}
+
+// number the API renders numbers as strings in some versions
+type number float64
+
+func (n *number) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*n = number(v)
+	return nil
+}
+
+type statistics struct {
+	Statistic []struct {
+		Name   string `json:"name"`
+		Values struct {
+			Value []struct {
+				Datum number `json:"datum"`
+			} `json:"value"`
+		} `json:"values"`
+	} `json:"statistic"`
+}
+
+// get the first value of a statistic
+func (s statistics) get(name string) (float64, bool) {
+	for _, stat := range s.Statistic {
+		if stat.Name == name && len(stat.Values.Value) > 0 {
+			return float64(stat.Values.Value[0].Datum), true
+		}
+	}
+	return 0, false
+}
+
+type topology struct {
+	Cores   number `json:"cores"`
+	Sockets number `json:"sockets"`
+	Threads number `json:"threads"`
+}
+
+// count logical CPUs, threads are omitted for VMs without SMT
+func (t topology) count() float64 {
+	threads := float64(t.Threads)
+	if threads == 0 {
+		threads = 1
+	}
+	return float64(t.Cores) * float64(t.Sockets) * threads
+}
+
+type nic struct {
+	Name       string     `json:"name"`
+	Statistics statistics `json:"statistics"`
+}
+
+type host struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	CPU    struct {
+		Topology topology `json:"topology"`
+	} `json:"cpu"`
+	Statistics statistics `json:"statistics"`
+	NICs       struct {
+		NIC []nic `json:"host_nic"`
+	} `json:"nics"`
+}
+
+type vm struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Memory number `json:"memory"`
+	Host   struct {
+		ID string `json:"id"`
+	} `json:"host"`
+	CPU struct {
+		Topology topology `json:"topology"`
+	} `json:"cpu"`
+	Statistics statistics `json:"statistics"`
+	NICs       struct {
+		NIC []nic `json:"nic"`
+	} `json:"nics"`
+	DiskAttachments struct {
+		DiskAttachment []struct {
+			Disk struct {
+				ProvisionedSize number `json:"provisioned_size"`
+				ActualSize      number `json:"actual_size"`
+			} `json:"disk"`
+		} `json:"disk_attachment"`
+	} `json:"disk_attachments"`
+}
+
+// NewServer init, an SSO token is issued if a user is set, the token is checked against the API entry point
+func NewServer(s probe.Server) (Server, error) {
+	server := Server{Server: s}
+	server.Type = "ovirt"
+	if !server.Valid() {
+		return server, errors.New("Inputs are not valid, please check")
+	}
+
+	client, err := probe.NewHTTPClient(s, 30)
+	if err != nil {
+		return server, err
+	}
+	server.client = client
+	server.base = fmt.Sprintf("https://%s:%d/ovirt-engine", s.Host, s.Port)
+
+	server.token = s.Password
+	if s.User != "" {
+		if server.token, err = server.sso(); err != nil {
+			log.Errorf("Fail to issue SSO token for %s due to %s", server.Server, err)
+			server.Close()
+			return server, err
+		}
+	}
+
+	var api map[string]interface{}
+	if err := server.get("/api", &api); err != nil {
+		log.Errorf("Fail to connect to %s due to %s", server.Server, err)
+		server.Close()
+		return server, err
+	}
+	return server, nil
+}
+
+// sso issue an access token with the resource owner password grant
+func (ovt Server) sso() (string, error) {
+	form := url.Values{
+		"grant_type": {"password"},
+		"scope":      {"ovirt-app-api"},
+		"username":   {ovt.User},
+		"password":   {ovt.Password},
+	}
+	req, err := http.NewRequest("POST", ovt.base+"/sso/oauth/token", bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ovt.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("%w: %s: %s", probe.ErrParse, resp.Request.URL.Path, err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("%w: %s %s", probe.ErrAuth, token.Error, token.Description)
+	}
+	return token.AccessToken, nil
+}
+
+// GetCPUUsage implement interface, the host set in options or all hosts
+func (ovt Server) GetCPUUsage() (float64, error) {
+	hosts, err := ovt.hosts(true)
+	if err != nil {
+		return 0, err
+	}
+
+	var used, total float64
+	for _, h := range hosts {
+		idle, ok := h.Statistics.get("cpu.current.idle")
+		if !ok {
+			continue
+		}
+		cpus := h.CPU.Topology.count()
+		used += (100 - idle) * cpus
+		total += cpus
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("%w: no host reports CPU usage", probe.ErrParse)
+	}
+	return used / total, nil
+}
+
+// GetMemUsage implement interface, the host set in options or all hosts
+func (ovt Server) GetMemUsage() (float64, error) {
+	hosts, err := ovt.hosts(true)
+	if err != nil {
+		return 0, err
+	}
+
+	var used, total float64
+	for _, h := range hosts {
+		u, ok1 := h.Statistics.get("memory.used")
+		t, ok2 := h.Statistics.get("memory.total")
+		if ok1 && ok2 {
+			used += u
+			total += t
+		}
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("%w: no host reports memory usage", probe.ErrParse)
+	}
+	return used * 100 / total, nil
+}
+
+// GetLocalDiskUsage implement interface, file systems of hosts are not reported by the API
+func (ovt Server) GetLocalDiskUsage() (map[string]float64, error) {
+	return nil, fmt.Errorf("%w: disk usage of hosts is not reported by oVirt", probe.ErrUnsupported)
+}
+
+// GetNICUsage implement interface, NICs are named as host/nic
+func (ovt Server) GetNICUsage() (map[string]map[string]float64, error) {
+	hosts, err := ovt.hosts(true)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string]map[string]float64{}
+	for _, h := range hosts {
+		for name, stats := range nicCounters(h.NICs.NIC) {
+			ret[h.Name+"/"+name] = stats
+		}
+	}
+	return ret, nil
+}
+
+// Close implement interface, idle connections are closed
+func (ovt Server) Close() error {
+	ovt.client.CloseIdleConnections()
+	return nil
+}
+
+// GetMetrics implement probe.Extended, hosts and VMs managed by the engine
+func (ovt Server) GetMetrics() ([]probe.Metric, error) {
+	hosts, err := ovt.hosts(false)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []probe.Node
+	names := map[string]string{}
+	var cpus, mem float64
+	for _, h := range hosts {
+		node := toNode(h)
+		nodes = append(nodes, node)
+		names[h.ID] = h.Name
+		cpus += node.Stats["cpus"]
+		mem += node.Stats["mem_bytes"]
+	}
+
+	var ret []probe.Metric
+	if ovt.Enabled("node") {
+		ret = append(ret, probe.NodeMetrics(nodes)...)
+	}
+	if ovt.Enabled("vm") {
+		var body struct {
+			VM []vm `json:"vm"`
+		}
+		if err := ovt.get("/api/vms?follow=statistics,nics.statistics,disk_attachments.disk", &body); err != nil {
+			return ret, err
+		}
+
+		var vms []probe.VM
+		for _, v := range body.VM {
+			vms = append(vms, toVM(v, names[v.Host.ID]))
+		}
+		ret = append(ret, probe.VMMetrics(vms)...)
+		ret = append(ret, probe.Overcommit(vms, cpus, mem)...)
+	}
+	return ret, nil
+}
+
+func toNode(h host) probe.Node {
+	node := probe.Node{
+		Name:  h.Name,
+		State: h.Status,
+		Stats: map[string]float64{},
+	}
+	if cpus := h.CPU.Topology.count(); cpus > 0 {
+		node.Stats["cpus"] = cpus
+	}
+	if idle, ok := h.Statistics.get("cpu.current.idle"); ok {
+		node.Stats["cpu_utilization"] = 100 - idle
+	}
+	used, ok1 := h.Statistics.get("memory.used")
+	total, ok2 := h.Statistics.get("memory.total")
+	if ok1 && ok2 && total > 0 {
+		node.Stats["mem_bytes"] = total
+		node.Stats["mem_utilization"] = used * 100 / total
+	}
+
+	var received, sent float64
+	counters := nicCounters(h.NICs.NIC)
+	for _, stats := range counters {
+		received += stats["received"]
+		sent += stats["sent"]
+	}
+	if len(counters) > 0 {
+		node.Stats["nic_received_bytes"] = received
+		node.Stats["nic_sent_bytes"] = sent
+	}
+	return node
+}
+
+func toVM(v vm, node string) probe.VM {
+	state, ok := states[v.Status]
+	if !ok {
+		state = v.Status
+	}
+	ret := probe.VM{
+		Name:  v.Name,
+		ID:    v.ID,
+		State: state,
+		Node:  node,
+		Stats: map[string]float64{
+			"vcpus":     v.CPU.Topology.count(),
+			"mem_bytes": float64(v.Memory),
+		},
+		NICs: nicCounters(v.NICs.NIC),
+	}
+
+	if cpu, ok := v.Statistics.get("cpu.current.total"); ok {
+		ret.Stats["cpu_utilization"] = cpu
+	}
+	if used, ok := v.Statistics.get("memory.used"); ok {
+		ret.Stats["mem_used_bytes"] = used
+	}
+	if elapsed, ok := v.Statistics.get("elapsed.time"); ok {
+		ret.Stats["uptime_seconds"] = elapsed
+	}
+
+	var provisioned, actual float64
+	for _, a := range v.DiskAttachments.DiskAttachment {
+		provisioned += float64(a.Disk.ProvisionedSize)
+		actual += float64(a.Disk.ActualSize)
+	}
+	if provisioned > 0 {
+		ret.Stats["disk_bytes"] = provisioned
+		ret.Stats["disk_used_bytes"] = actual
+	}
+	return ret
+}
+
+// nicCounters received and sent bytes of NICs reporting data.total.rx/tx (oVirt 4.2 and later)
+func nicCounters(nics []nic) map[string]map[string]float64 {
+	ret := map[string]map[string]float64{}
+	for _, n := range nics {
+		rx, ok1 := n.Statistics.get("data.total.rx")
+		tx, ok2 := n.Statistics.get("data.total.tx")
+		if ok1 && ok2 {
+			ret[n.Name] = map[string]float64{"received": rx, "sent": tx}
+		}
+	}
+	return ret
+}
+
+// hosts all hosts, only up hosts or the host set in options if selected
+func (ovt Server) hosts(selected bool) ([]host, error) {
+	var body struct {
+		Host []host `json:"host"`
+	}
+	if err := ovt.get("/api/hosts?follow=statistics,nics.statistics", &body); err != nil {
+		return nil, err
+	}
+	if !selected {
+		return body.Host, nil
+	}
+
+	want := ovt.Option("node", "")
+	var ret []host
+	for _, h := range body.Host {
+		if h.Status != "up" {
+			continue
+		}
+		if want == "" || h.Name == want {
+			ret = append(ret, h)
+		}
+	}
+	if want != "" && len(ret) == 0 {
+		return nil, fmt.Errorf("Host %s is not found or not up", want)
+	}
+	return ret, nil
+}
+
+// get fetch a resource with the access token
+func (ovt Server) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", ovt.base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+ovt.token)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Version", "4")
+
+	resp, err := ovt.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return probe.DecodeJSON(resp, v)
+}
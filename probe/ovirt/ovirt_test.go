@@ -0,0 +1,223 @@
+package ovirt
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+	"github.com/kckecheng/osprobe/probe/internal/probetest"
+)
+
+const gib = 1 << 30
+
+// fixtures hosts and VMs of an engine, numbers are partly rendered as strings like some API versions do
+var fixtures = map[string]string{
+	"/ovirt-engine/api": `{"product_info": {"name": "oVirt Engine"}}`,
+	"/ovirt-engine/api/hosts": fmt.Sprintf(`{"host": [
+		{"id": "h-1", "name": "host1", "status": "up",
+		 "cpu": {"topology": {"cores": "4", "sockets": "1", "threads": "2"}},
+		 "statistics": {"statistic": [
+			{"name": "cpu.current.idle", "values": {"value": [{"datum": 75}]}},
+			{"name": "memory.used", "values": {"value": [{"datum": "%d"}]}},
+			{"name": "memory.total", "values": {"value": [{"datum": "%d"}]}}
+		 ]},
+		 "nics": {"host_nic": [
+			{"name": "eth0", "statistics": {"statistic": [
+				{"name": "data.total.rx", "values": {"value": [{"datum": "100"}]}},
+				{"name": "data.total.tx", "values": {"value": [{"datum": "200"}]}}
+			]}},
+			{"name": "bond0", "statistics": {"statistic": []}}
+		 ]}},
+		{"id": "h-2", "name": "host2", "status": "up",
+		 "cpu": {"topology": {"cores": 8, "sockets": 1}},
+		 "statistics": {"statistic": [
+			{"name": "cpu.current.idle", "values": {"value": [{"datum": 25}]}},
+			{"name": "memory.used", "values": {"value": [{"datum": %d}]}},
+			{"name": "memory.total", "values": {"value": [{"datum": %d}]}}
+		 ]}},
+		{"id": "h-3", "name": "host3", "status": "maintenance"}
+	]}`, 4*gib, 16*gib, 12*gib, 16*gib),
+	"/ovirt-engine/api/vms": fmt.Sprintf(`{"vm": [
+		{"id": "v-1", "name": "web", "status": "up", "memory": "%d", "host": {"id": "h-1"},
+		 "cpu": {"topology": {"cores": 2, "sockets": 1}},
+		 "statistics": {"statistic": [
+			{"name": "cpu.current.total", "values": {"value": [{"datum": 10}]}},
+			{"name": "elapsed.time", "values": {"value": [{"datum": 60}]}}
+		 ]},
+		 "nics": {"nic": [{"name": "nic1", "statistics": {"statistic": [
+			{"name": "data.total.rx", "values": {"value": [{"datum": 5}]}},
+			{"name": "data.total.tx", "values": {"value": [{"datum": 6}]}}
+		 ]}}]},
+		 "disk_attachments": {"disk_attachment": [{"disk": {"provisioned_size": "100", "actual_size": "40"}}]}},
+		{"id": "v-2", "name": "db", "status": "down", "memory": %d, "cpu": {"topology": {"cores": 2, "sockets": 1}}}
+	]}`, 4*gib, 4*gib),
+}
+
+const ssoPath = "/ovirt-engine/sso/oauth/token"
+
+// fakeEngine serve the fixtures with the bearer token "token", which SSO issues for admin@internal:secret
+func fakeEngine() *probetest.API {
+	api := probetest.NewAPI(fixtures, func(r *http.Request) bool {
+		return r.URL.Path == ssoPath || r.Header.Get("Authorization") == "Bearer token"
+	})
+	api.Handle(ssoPath, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("username") != "admin@internal" || r.PostForm.Get("password") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "access_denied", "error_description": "Cannot authenticate user"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "token", "token_type": "bearer"}`)
+	})
+	return api
+}
+
+func serverOf(t *testing.T, api *probetest.API, user, password string, options map[string]string) probe.Server {
+	return api.ServerOf(t, probe.Server{User: user, Password: password, Type: "ovirt", Options: options})
+}
+
+func TestNewServer(t *testing.T) {
+	ts := fakeEngine()
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		err      error
+	}{
+		{name: "SSO", user: "admin@internal", password: "secret"},
+		{name: "access token", password: "token"},
+		{name: "wrong password", user: "admin@internal", password: "wrong", err: probe.ErrAuth},
+		{name: "wrong access token", password: "wrong", err: probe.ErrAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ovt, err := NewServer(serverOf(t, ts, tt.user, tt.password, nil))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err == nil {
+				ovt.Close()
+			}
+		})
+	}
+}
+
+func TestUsage(t *testing.T) {
+	ts := fakeEngine()
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		node string
+		cpu  float64
+		mem  float64
+		nics map[string]map[string]float64
+		fail bool
+	}{
+		{
+			name: "all hosts",
+			cpu:  50,
+			mem:  50,
+			nics: map[string]map[string]float64{"host1/eth0": {"received": 100, "sent": 200}},
+		},
+		{
+			name: "host",
+			node: "host2",
+			cpu:  75,
+			mem:  75,
+			nics: map[string]map[string]float64{},
+		},
+		{name: "host in maintenance", node: "host3", fail: true},
+		{name: "unknown host", node: "host4", fail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := map[string]string{}
+			if tt.node != "" {
+				options["node"] = tt.node
+			}
+			ovt, err := NewServer(serverOf(t, ts, "", "token", options))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ovt.Close()
+
+			cpu, err := ovt.GetCPUUsage()
+			if (err != nil) != tt.fail {
+				t.Fatalf("got error %v, want failure %v", err, tt.fail)
+			}
+			if tt.fail {
+				return
+			}
+			mem, _ := ovt.GetMemUsage()
+			nics, _ := ovt.GetNICUsage()
+			if cpu != tt.cpu || mem != tt.mem || fmt.Sprint(nics) != fmt.Sprint(tt.nics) {
+				t.Errorf("got CPU %v, memory %v and NICs %v, want %v, %v and %v", cpu, mem, nics, tt.cpu, tt.mem, tt.nics)
+			}
+			if _, err := ovt.GetLocalDiskUsage(); !errors.Is(err, probe.ErrUnsupported) {
+				t.Errorf("got disk error %v, want %v", err, probe.ErrUnsupported)
+			}
+		})
+	}
+}
+
+func TestGetMetrics(t *testing.T) {
+	ts := fakeEngine()
+	defer ts.Close()
+
+	ovt, err := NewServer(serverOf(t, ts, "", "token", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ovt.Close()
+
+	metrics, err := ovt.GetMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, m := range metrics {
+		key := m.Name
+		for _, l := range []string{"node", "id", "state", "nic"} {
+			if v, ok := m.Labels[l]; ok {
+				key += "/" + v
+			}
+		}
+		got[key] = m.Value
+	}
+	want := map[string]float64{
+		"node_state/host3/maintenance":               1,
+		"node_cpus/host1":                            8,
+		"node_cpu_utilization/host1":                 25,
+		"node_mem_utilization/host2":                 75,
+		"node_nic_received_bytes_total/host1":        100,
+		"node_nic_sent_bytes_total/host1":            200,
+		"vm_state/host1/v-1/running":                 1,
+		"vm_vcpus/host1/v-1":                         2,
+		"vm_cpu_utilization/host1/v-1":               10,
+		"vm_uptime_seconds/host1/v-1":                60,
+		"vm_disk_bytes/host1/v-1":                    100,
+		"vm_disk_used_bytes/host1/v-1":               40,
+		"vm_nic_received_bytes_total/host1/v-1/nic1": 5,
+		"vm_state//v-2/shutoff":                      1,
+		"vm_mem_bytes//v-2":                          4 * gib,
+		"vcpu_overcommit_ratio":                      0.125,
+		"mem_overcommit_ratio":                       0.125,
+	}
+	for k, v := range want {
+		if g, ok := got[k]; !ok || g != v {
+			t.Errorf("got %s %v (reported %v), want %v", k, g, ok, v)
+		}
+	}
+	for _, k := range []string{"node_nic_received_bytes_total/host2", "node_cpu_utilization/host3", "vm_cpu_utilization//v-2"} {
+		if _, ok := got[k]; ok {
+			t.Errorf("%s is reported", k)
+		}
+	}
+}
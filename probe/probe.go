@@ -2,8 +2,11 @@ package probe
 
 import (
 	"bytes"
+	"fmt"
 	"os/exec"
 	"strings"
+
+	"github.com/kckecheng/osprobe/secret"
 )
 
 // Probe interface
@@ -13,28 +16,130 @@ type Probe interface {
 	GetMemUsage() (float64, error)
 	GetLocalDiskUsage() (map[string]float64, error)
 	GetNICUsage() (map[string]map[string]float64, error)
+	// Close release connections and sessions, probes are created for each probe round
+	Close() error
+}
+
+// Metric a backend specific metric, e.g., the power draw reported by a BMC
+type Metric struct {
+	Name   string            `json:"name"`
+	Help   string            `json:"-"`
+	Type   ValueType         `json:"-"`
+	Labels map[string]string `json:"labels,omitempty"` // labels besides host and type
+	Value  float64           `json:"value"`
+	// Hosts names and addresses of the machine the metric is about if it is not the probed server, e.g.,
+	// a Kubernetes node. The first one configured as a server is used as the host label, the first one otherwise
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// ValueType how the value of a metric changes
+type ValueType int
+
+// Value types of metrics, counters are named *_total
+const (
+	Gauge   ValueType = iota // goes up and down, e.g., a utilization
+	Counter                  // cumulative, it only decreases when it is reset, e.g., bytes sent since boot
+)
+
+// Extended probes exporting backend specific metrics besides CPU, memory and NIC usage,
+// metrics not enabled for the server should be skipped
+type Extended interface {
+	GetMetrics() ([]Metric, error)
 }
 
 // Server inforamtion to connect to a server
 type Server struct {
 	Host     string `json:"host"`
-	User     string `json:"user"`
-	Password string `json:"password"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"` // plain text or a secret reference such as env:VAR, file:/path or exec:command
+	Key      string `json:"key,omitempty"`      // path of a SSH private key or a secret reference to the key
 	Port     int    `json:"port"`
-	Type     string `json:"type"` // linux, windows, or esxi
+	Type     string `json:"type"` // type of a registered backend, e.g., linux, windows, or esxi
+	// Credential name of a credential in the credential database, used instead of user/password/key
+	Credential string `json:"credential,omitempty"`
+	// Labels user defined metadata such as owner, team, lab, rack, ticket and purpose
+	Labels map[string]string `json:"labels,omitempty"`
+	// Interval refresh interval(seconds) of the server, 0 means the global interval
+	Interval int64 `json:"interval,omitempty"`
+	// Metrics enabled metrics: cpu, mem, nic, or backend specific groups, empty means all
+	Metrics []string `json:"metrics,omitempty"`
+	// Options backend specific settings, e.g., SNMP version and protocols, values can be secret references
+	Options map[string]string `json:"options,omitempty"`
 }
 
-// Valid make sure all fields are valid
+// Enabled check if a metric should be probed for the server
+func (s Server) Enabled(metric string) bool {
+	if len(s.Metrics) == 0 {
+		return true
+	}
+	for _, m := range s.Metrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// String describe the server without secrets so that it can be logged safely
+func (s Server) String() string {
+	return fmt.Sprintf("%s@%s:%d (%s)", s.User, s.Host, s.Port, s.Type)
+}
+
+// GoString describe the server without secrets for %#v
+func (s Server) GoString() string {
+	return s.String()
+}
+
+// Resolve return a copy of the server with secret references of password, key and options resolved
+func (s Server) Resolve() (Server, error) {
+	password, err := secret.Resolve(s.Password)
+	if err != nil {
+		return s, err
+	}
+	key, err := secret.Resolve(s.Key)
+	if err != nil {
+		return s, err
+	}
+
+	var options map[string]string
+	if s.Options != nil {
+		options = map[string]string{}
+	}
+	for k, v := range s.Options {
+		options[k], err = secret.Resolve(v)
+		if err != nil {
+			return s, err
+		}
+	}
+
+	s.Password = password
+	s.Key = key
+	s.Options = options
+	return s, nil
+}
+
+// Option get a backend specific setting, the default value is returned if it is not set
+func (s Server) Option(name, def string) string {
+	if v, ok := s.Options[name]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// Valid make sure all fields are valid, user is not required by backends such as SNMP v2c
+// and only host is required by local backends
 func (s Server) Valid() bool {
-	if s.Host == "" || s.User == "" || s.Password == "" || s.Port <= 0 || s.Port > 65535 {
+	b, ok := Lookup(s.Type)
+	if !ok {
 		return false
 	}
-
-	switch s.Type {
-	case "linux", "windows", "esxi":
-		return true
+	if b.Local {
+		return s.Host != ""
 	}
-	return false
+	if s.Host == "" || (s.User == "" && !b.OptionalUser(s)) || (s.Password == "" && s.Key == "") || s.Port <= 0 || s.Port > 65535 {
+		return false
+	}
+	return true
 }
 
 // Online check if a server is reachable
@@ -0,0 +1,342 @@
+package proxmox
+
+/*
+	Proxmox VE clusters probed through the REST API (8006 by default) with an API token: the user is the token id,
+	e.g., monitoring@pve!osprobe, and the password is the token secret. A token of any cluster node is enough,
+	nodes and guests (QEMU VMs and LXC containers) of the whole cluster are reported with /cluster/resources, which is
+	fetched once per probe. Network rates of nodes are read from the RRD data of each node, NIC counters since boot are
+	not exposed by the API.
+	Options:
+	- node: node whose usage is reported as the server usage, usage of the whole cluster by default;
+	- insecure, ca_file and timeout: TLS verification and request timeout, see probe.NewHTTPClient.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kckecheng/osprobe/probe"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	probe.Register(probe.Backend{
+		Type:    "proxmox",
+		New:     newProbe,
+		Port:    8006,
+		Metrics: []string{"cpu", "mem", "node", "vm"},
+	})
+}
+
+func newProbe(server probe.Server) (probe.Probe, error) {
+	p, err := NewServer(server)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Server Proxmox VE API endpoint
+type Server struct {
+	probe.Server
+	client *http.Client
+	base   string
+	cache  *cache
+}
+
+// cache /cluster/resources shared by all metrics of a probe
+type cache struct {
+	once      sync.Once
+	resources []resource
+	err       error
+}
+
+// rrd an item of /nodes/{node}/rrddata, null for the interval being recorded
+type rrd struct {
+	NetIn  *float64 `json:"netin"`  // bytes per second
+	NetOut *float64 `json:"netout"` // bytes per second
+}
+
+// resource an item of /cluster/resources, fields not relevant to the type are 0
+type resource struct {
+	Type      string  `json:"type"` // node, qemu, lxc, storage, etc.
+	Node      string  `json:"node"`
+	Status    string  `json:"status"`
+	Name      string  `json:"name"`
+	VMID      int     `json:"vmid"`
+	Template  int     `json:"template"`
+	CPU       float64 `json:"cpu"` // fraction of maxcpu
+	MaxCPU    float64 `json:"maxcpu"`
+	Mem       float64 `json:"mem"`
+	MaxMem    float64 `json:"maxmem"`
+	Disk      float64 `json:"disk"`
+	MaxDisk   float64 `json:"maxdisk"`
+	DiskRead  float64 `json:"diskread"`
+	DiskWrite float64 `json:"diskwrite"`
+	NetIn     float64 `json:"netin"`
+	NetOut    float64 `json:"netout"`
+	Uptime    float64 `json:"uptime"`
+}
+
+// NewServer init and check the token against /version
+func NewServer(s probe.Server) (Server, error) {
+	server := Server{Server: s, cache: &cache{}}
+	server.Type = "proxmox"
+	if !server.Valid() {
+		return server, errors.New("Inputs are not valid, please check")
+	}
+	if !strings.Contains(s.User, "!") {
+		return server, fmt.Errorf("User %s is not an API token id such as monitoring@pve!osprobe", s.User)
+	}
+
+	client, err := probe.NewHTTPClient(s, 10)
+	if err != nil {
+		return server, err
+	}
+	server.client = client
+	server.base = fmt.Sprintf("https://%s:%d/api2/json", s.Host, s.Port)
+
+	var version map[string]interface{}
+	if err := server.get("/version", &version); err != nil {
+		log.Errorf("Fail to connect to %s due to %s", server.Server, err)
+		server.Close()
+		return server, err
+	}
+	return server, nil
+}
+
+// GetCPUUsage implement interface, the node set in options or the whole cluster
+func (pve Server) GetCPUUsage() (float64, error) {
+	nodes, err := pve.nodes()
+	if err != nil {
+		return 0, err
+	}
+
+	var used, total float64
+	for _, n := range nodes {
+		used += n.CPU * n.MaxCPU
+		total += n.MaxCPU
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("%w: no online node is found", probe.ErrParse)
+	}
+	return used * 100 / total, nil
+}
+
+// GetMemUsage implement interface, the node set in options or the whole cluster
+func (pve Server) GetMemUsage() (float64, error) {
+	nodes, err := pve.nodes()
+	if err != nil {
+		return 0, err
+	}
+
+	var used, total float64
+	for _, n := range nodes {
+		used += n.Mem
+		total += n.MaxMem
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("%w: no online node is found", probe.ErrParse)
+	}
+	return used * 100 / total, nil
+}
+
+// GetLocalDiskUsage implement interface, usage of the root file system of each node
+func (pve Server) GetLocalDiskUsage() (map[string]float64, error) {
+	nodes, err := pve.nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string]float64{}
+	for _, n := range nodes {
+		if n.MaxDisk > 0 {
+			ret[n.Node] = n.Disk * 100 / n.MaxDisk
+		}
+	}
+	return ret, nil
+}
+
+// GetNICUsage implement interface, node counters are not exposed by the API
+func (pve Server) GetNICUsage() (map[string]map[string]float64, error) {
+	return nil, fmt.Errorf("%w: NIC counters of nodes are not reported by Proxmox VE", probe.ErrUnsupported)
+}
+
+// Close implement interface, idle connections are closed
+func (pve Server) Close() error {
+	pve.client.CloseIdleConnections()
+	return nil
+}
+
+// GetMetrics implement probe.Extended, nodes and guests of the cluster
+func (pve Server) GetMetrics() ([]probe.Metric, error) {
+	resources, err := pve.resources()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []probe.Node
+	var vms []probe.VM
+	var cpus, mem float64
+	for _, r := range resources {
+		switch r.Type {
+		case "node":
+			node := toNode(r)
+			if r.Status == "online" && pve.Enabled("node") {
+				pve.netRates(&node)
+			}
+			nodes = append(nodes, node)
+			cpus += r.MaxCPU
+			mem += r.MaxMem
+		case "qemu", "lxc":
+			if r.Template == 1 {
+				continue
+			}
+			vms = append(vms, toVM(r))
+		}
+	}
+
+	var ret []probe.Metric
+	if pve.Enabled("node") {
+		ret = append(ret, probe.NodeMetrics(nodes)...)
+	}
+	if pve.Enabled("vm") {
+		ret = append(ret, probe.VMMetrics(vms)...)
+		ret = append(ret, probe.Overcommit(vms, cpus, mem)...)
+	}
+	return ret, nil
+}
+
+func toNode(r resource) probe.Node {
+	node := probe.Node{
+		Name:  r.Node,
+		State: r.Status,
+		Stats: map[string]float64{},
+	}
+	if r.Status != "online" {
+		return node
+	}
+
+	node.Stats["cpu_utilization"] = r.CPU * 100
+	node.Stats["cpus"] = r.MaxCPU
+	node.Stats["mem_bytes"] = r.MaxMem
+	node.Stats["uptime_seconds"] = r.Uptime
+	if r.MaxMem > 0 {
+		node.Stats["mem_utilization"] = r.Mem * 100 / r.MaxMem
+	}
+	if r.MaxDisk > 0 {
+		node.Stats["disk_utilization"] = r.Disk * 100 / r.MaxDisk
+	}
+	return node
+}
+
+// toVM convert a QEMU VM or a LXC container, disk and NIC counters are only reported for all devices together
+func toVM(r resource) probe.VM {
+	name := r.Name
+	if name == "" {
+		name = strconv.Itoa(r.VMID)
+	}
+	vm := probe.VM{
+		Name:  name,
+		ID:    strconv.Itoa(r.VMID),
+		State: r.Status,
+		Node:  r.Node,
+		Stats: map[string]float64{
+			"vcpus":     r.MaxCPU,
+			"mem_bytes": r.MaxMem,
+		},
+	}
+	if r.MaxDisk > 0 {
+		vm.Stats["disk_bytes"] = r.MaxDisk
+	}
+	if r.Status != "running" {
+		return vm
+	}
+
+	vm.Stats["cpu_utilization"] = r.CPU * 100
+	vm.Stats["mem_used_bytes"] = r.Mem
+	vm.Stats["uptime_seconds"] = r.Uptime
+	// Disk usage is only known for containers
+	if r.Type == "lxc" {
+		vm.Stats["disk_used_bytes"] = r.Disk
+	}
+	vm.Disks = map[string]map[string]float64{
+		"all": {"read": r.DiskRead, "written": r.DiskWrite},
+	}
+	vm.NICs = map[string]map[string]float64{
+		"all": {"received": r.NetIn, "sent": r.NetOut},
+	}
+	return vm
+}
+
+// nodes online nodes, only the node set in options if any
+func (pve Server) nodes() ([]resource, error) {
+	resources, err := pve.resources()
+	if err != nil {
+		return nil, err
+	}
+
+	want := pve.Option("node", "")
+	var ret []resource
+	for _, r := range resources {
+		if r.Type != "node" || r.Status != "online" {
+			continue
+		}
+		if want == "" || r.Node == want {
+			ret = append(ret, r)
+		}
+	}
+	if want != "" && len(ret) == 0 {
+		return nil, fmt.Errorf("Node %s is not found or offline", want)
+	}
+	return ret, nil
+}
+
+// resources fetch /cluster/resources once per probe
+func (pve Server) resources() ([]resource, error) {
+	pve.cache.once.Do(func() {
+		pve.cache.err = pve.get("/cluster/resources", &pve.cache.resources)
+	})
+	return pve.cache.resources, pve.cache.err
+}
+
+// netRates set the latest average network rates of a node, failures are logged only
+func (pve Server) netRates(node *probe.Node) {
+	var data []rrd
+	if err := pve.get(fmt.Sprintf("/nodes/%s/rrddata?timeframe=hour&cf=AVERAGE", url.PathEscape(node.Name)), &data); err != nil {
+		log.Warnf("Fail to read network rates of node %s due to %s", node.Name, err)
+		return
+	}
+	for i := len(data) - 1; i >= 0; i-- {
+		if data[i].NetIn != nil && data[i].NetOut != nil {
+			node.Stats["nic_received_bytes_per_second"] = *data[i].NetIn
+			node.Stats["nic_sent_bytes_per_second"] = *data[i].NetOut
+			return
+		}
+	}
+}
+
+// get fetch a resource, the data field of the response is decoded
+func (pve Server) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", pve.base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", pve.User, pve.Password))
+
+	resp, err := pve.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body := struct {
+		Data interface{} `json:"data"`
+	}{Data: v}
+	return probe.DecodeJSON(resp, &body)
+}
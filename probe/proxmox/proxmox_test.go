@@ -0,0 +1,180 @@
+package proxmox
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+	"github.com/kckecheng/osprobe/probe/internal/probetest"
+)
+
+const gib = 1 << 30
+
+var resources = fmt.Sprintf(`{"data": [
+	{"type": "node", "node": "pve1", "status": "online", "cpu": 0.25, "maxcpu": 8, "mem": %d, "maxmem": %d, "disk": 10, "maxdisk": 100, "uptime": 3600},
+	{"type": "node", "node": "pve2", "status": "online", "cpu": 0.75, "maxcpu": 8, "mem": %d, "maxmem": %d},
+	{"type": "node", "node": "pve3", "status": "offline"},
+	{"type": "qemu", "node": "pve1", "vmid": 100, "name": "web", "status": "running", "cpu": 0.5, "maxcpu": 4, "mem": %d, "maxmem": %d,
+	 "maxdisk": 100, "diskread": 1, "diskwrite": 2, "netin": 3, "netout": 4, "uptime": 60},
+	{"type": "lxc", "node": "pve2", "vmid": 200, "status": "stopped", "maxcpu": 2, "maxmem": %d},
+	{"type": "qemu", "node": "pve2", "vmid": 9000, "name": "tmpl", "status": "stopped", "template": 1},
+	{"type": "storage", "node": "pve1", "status": "available"}
+]}`, 4*gib, 16*gib, 12*gib, 16*gib, 2*gib, 8*gib, gib)
+
+// fakeAPI serve a cluster of three nodes with an API token
+func fakeAPI() *probetest.API {
+	responses := map[string]string{
+		"/api2/json/version":            `{"data": {"version": "7.0"}}`,
+		"/api2/json/cluster/resources":  resources,
+		"/api2/json/nodes/pve1/rrddata": `{"data": [{"time": 1, "netin": 100, "netout": 200}, {"time": 2, "netin": 300.5, "netout": 400}, {"time": 3}]}`,
+		"/api2/json/nodes/pve2/rrddata": `{"data": []}`,
+	}
+	return probetest.NewAPI(responses, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "PVEAPIToken=monitoring@pve!osprobe=secret"
+	})
+}
+
+func serverOf(t *testing.T, api *probetest.API, user, password string, options map[string]string) probe.Server {
+	return api.ServerOf(t, probe.Server{User: user, Password: password, Type: "proxmox", Options: options})
+}
+
+func TestNewServer(t *testing.T) {
+	api := fakeAPI()
+	defer api.Close()
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		fail     bool
+		err      error
+	}{
+		{name: "token", user: "monitoring@pve!osprobe", password: "secret"},
+		{name: "user instead of a token", user: "root@pam", password: "secret", fail: true},
+		{name: "wrong secret", user: "monitoring@pve!osprobe", password: "wrong", fail: true, err: probe.ErrAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pve, err := NewServer(serverOf(t, api, tt.user, tt.password, nil))
+			if (err != nil) != tt.fail || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Fatalf("got error %v, want %v (failure %v)", err, tt.err, tt.fail)
+			}
+			if err == nil {
+				pve.Close()
+			}
+		})
+	}
+}
+
+func TestUsage(t *testing.T) {
+	api := fakeAPI()
+	defer api.Close()
+
+	tests := []struct {
+		name string
+		node string
+		cpu  float64
+		mem  float64
+		disk map[string]float64
+		fail bool
+	}{
+		{name: "cluster", cpu: 50, mem: 50, disk: map[string]float64{"pve1": 10}},
+		{name: "node", node: "pve2", cpu: 75, mem: 75, disk: map[string]float64{}},
+		{name: "offline node", node: "pve3", fail: true},
+		{name: "unknown node", node: "pve4", fail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := map[string]string{}
+			if tt.node != "" {
+				options["node"] = tt.node
+			}
+			pve, err := NewServer(serverOf(t, api, "monitoring@pve!osprobe", "secret", options))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pve.Close()
+
+			cpu, err := pve.GetCPUUsage()
+			if (err != nil) != tt.fail {
+				t.Fatalf("got error %v, want failure %v", err, tt.fail)
+			}
+			if tt.fail {
+				return
+			}
+			mem, _ := pve.GetMemUsage()
+			disk, _ := pve.GetLocalDiskUsage()
+			if cpu != tt.cpu || mem != tt.mem || fmt.Sprint(disk) != fmt.Sprint(tt.disk) {
+				t.Errorf("got CPU %v, memory %v and disk %v, want %v, %v and %v", cpu, mem, disk, tt.cpu, tt.mem, tt.disk)
+			}
+		})
+	}
+}
+
+func TestGetMetrics(t *testing.T) {
+	api := fakeAPI()
+	defer api.Close()
+
+	pve, err := NewServer(serverOf(t, api, "monitoring@pve!osprobe", "secret", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pve.Close()
+
+	if _, err := pve.GetCPUUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pve.GetMemUsage(); err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := pve.GetMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := api.Calls("/api2/json/cluster/resources"); n != 1 {
+		t.Errorf("cluster resources are fetched %d times, want once per probe", n)
+	}
+	if n := api.Calls("/api2/json/nodes/pve3/rrddata"); n != 0 {
+		t.Errorf("network rates of an offline node are fetched %d times", n)
+	}
+
+	got := map[string]float64{}
+	for _, m := range metrics {
+		key := m.Name
+		for _, l := range []string{"node", "id", "state"} {
+			if v, ok := m.Labels[l]; ok {
+				key += "/" + v
+			}
+		}
+		got[key] = m.Value
+	}
+	want := map[string]float64{
+		"node_state/pve3/offline":                 1,
+		"node_cpu_utilization/pve1":               25,
+		"node_mem_utilization/pve2":               75,
+		"node_disk_utilization/pve1":              10,
+		"node_nic_received_bytes_per_second/pve1": 300.5,
+		"node_nic_sent_bytes_per_second/pve1":     400,
+		"vm_state/pve1/100/running":               1,
+		"vm_cpu_utilization/pve1/100":             50,
+		"vm_nic_received_bytes_total/pve1/100":    3,
+		"vm_state/pve2/200/stopped":               1,
+		"vm_mem_bytes/pve2/200":                   gib,
+		"vcpu_overcommit_ratio":                   0.25,
+		"mem_overcommit_ratio":                    0.25,
+	}
+	for k, v := range want {
+		if g, ok := got[k]; !ok || g != v {
+			t.Errorf("got %s %v (reported %v), want %v", k, g, ok, v)
+		}
+	}
+	for _, k := range []string{"node_nic_received_bytes_per_second/pve2", "vm_state/pve2/9000/stopped", "vm_cpu_utilization/pve2/200"} {
+		if _, ok := got[k]; ok {
+			t.Errorf("%s is reported", k)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package redfish
+
+/*
+	Baseboard management controllers (iDRAC, iLO, XClarity, OpenBMC, etc.) speaking the DMTF Redfish REST API.
+	The BMC is authenticated with HTTP basic auth, no session is created.
+	Options:
+	- scheme: https (default) or http;
+	- insecure: skip TLS verification, false by default, set ca_file for BMCs with self-signed certificates;
+	- ca_file: PEM CA bundle used to verify the BMC, implies insecure=false;
+	- system: Id of the computer system, the first member of /redfish/v1/Systems by default;
+	- timeout: timeout(seconds) of each request, 10 by default.
+
+	Only hardware metrics are exported (power, thermal and inventory), CPU, memory and NIC usage of the OS
+	are invisible to a BMC. The Power and Thermal resources of the chassis are used which are implemented
+	by all BMCs although deprecated in favor of PowerSubsystem and ThermalSubsystem.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kckecheng/osprobe/probe"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	probe.Register(probe.Backend{
+		Type:    "redfish",
+		New:     newProbe,
+		Port:    443,
+		Metrics: []string{"power", "thermal", "inventory"},
+	})
+}
+
+func newProbe(server probe.Server) (probe.Probe, error) {
+	p, err := NewServer(server)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Server Redfish service of a BMC
+type Server struct {
+	probe.Server
+	client  *http.Client
+	base    string
+	system  string // @odata.id of the computer system
+	chassis string // @odata.id of the chassis containing the system
+}
+
+// link a reference to another resource
+type link struct {
+	ID string `json:"@odata.id"`
+}
+
+// collection a resource collection
+type collection struct {
+	Members []link `json:"Members"`
+}
+
+// status health of a resource, State is Absent for empty slots
+type status struct {
+	State  string `json:"State"`
+	Health string `json:"Health"`
+}
+
+type system struct {
+	ID               string `json:"Id"`
+	Manufacturer     string `json:"Manufacturer"`
+	Model            string `json:"Model"`
+	SerialNumber     string `json:"SerialNumber"`
+	PowerState       string `json:"PowerState"`
+	Status           status `json:"Status"`
+	ProcessorSummary struct {
+		Count *int `json:"Count"`
+	} `json:"ProcessorSummary"`
+	MemorySummary struct {
+		TotalSystemMemoryGiB *float64 `json:"TotalSystemMemoryGiB"`
+	} `json:"MemorySummary"`
+	Memory link `json:"Memory"`
+	Links  struct {
+		Chassis []link `json:"Chassis"`
+	} `json:"Links"`
+}
+
+type chassis struct {
+	Power   link `json:"Power"`
+	Thermal link `json:"Thermal"`
+}
+
+type power struct {
+	PowerControl []struct {
+		PowerConsumedWatts *float64 `json:"PowerConsumedWatts"`
+	} `json:"PowerControl"`
+	PowerSupplies []struct {
+		MemberID string `json:"MemberId"`
+		Name     string `json:"Name"`
+		Status   status `json:"Status"`
+	} `json:"PowerSupplies"`
+}
+
+type thermal struct {
+	Temperatures []struct {
+		MemberID       string   `json:"MemberId"`
+		Name           string   `json:"Name"`
+		ReadingCelsius *float64 `json:"ReadingCelsius"`
+		Status         status   `json:"Status"`
+	} `json:"Temperatures"`
+	Fans []struct {
+		MemberID string `json:"MemberId"`
+		Name     string `json:"Name"`
+		FanName  string `json:"FanName"` // Redfish 2016.1 and earlier
+		Status   status `json:"Status"`
+	} `json:"Fans"`
+}
+
+type memory struct {
+	Status status `json:"Status"`
+}
+
+// NewServer init with the TLS settings set in options, the system and its chassis are located to verify the credential
+func NewServer(s probe.Server) (Server, error) {
+	server := Server{Server: s}
+	server.Type = "redfish"
+	if !server.Valid() {
+		return server, errors.New("Inputs are not valid, please check")
+	}
+
+	client, err := probe.NewHTTPClient(s, 10)
+	if err != nil {
+		return server, err
+	}
+	server.client = client
+	server.base = fmt.Sprintf("%s://%s:%d", s.Option("scheme", "https"), s.Host, s.Port)
+
+	if err := server.locate(); err != nil {
+		log.Errorf("Fail to locate the system of %s due to %s", server.Server, err)
+		server.Close()
+		return server, err
+	}
+	return server, nil
+}
+
+// locate find the computer system and the chassis containing it
+func (rf *Server) locate() error {
+	var systems collection
+	if err := rf.get("/redfish/v1/Systems", &systems); err != nil {
+		return err
+	}
+	if len(systems.Members) == 0 {
+		return fmt.Errorf("%w: no system is managed by the BMC", probe.ErrUnsupported)
+	}
+
+	want := rf.Option("system", "")
+	for _, m := range systems.Members {
+		if want == "" || strings.TrimSuffix(m.ID, "/") == "/redfish/v1/Systems/"+want {
+			rf.system = m.ID
+			break
+		}
+	}
+	if rf.system == "" {
+		return fmt.Errorf("System %s is not found", want)
+	}
+
+	var sys system
+	if err := rf.get(rf.system, &sys); err != nil {
+		return err
+	}
+	if len(sys.Links.Chassis) > 0 {
+		rf.chassis = sys.Links.Chassis[0].ID
+		return nil
+	}
+
+	var chassis collection
+	if err := rf.get("/redfish/v1/Chassis", &chassis); err != nil {
+		return err
+	}
+	if len(chassis.Members) > 0 {
+		rf.chassis = chassis.Members[0].ID
+	}
+	return nil
+}
+
+// GetCPUUsage implement interface, not visible to the BMC
+func (rf Server) GetCPUUsage() (float64, error) {
+	return 0, fmt.Errorf("%w: CPU usage is not reported by Redfish", probe.ErrUnsupported)
+}
+
+// GetMemUsage implement interface, not visible to the BMC
+func (rf Server) GetMemUsage() (float64, error) {
+	return 0, fmt.Errorf("%w: memory usage is not reported by Redfish", probe.ErrUnsupported)
+}
+
+// GetLocalDiskUsage implement interface, not visible to the BMC
+func (rf Server) GetLocalDiskUsage() (map[string]float64, error) {
+	return nil, fmt.Errorf("%w: disk usage is not reported by Redfish", probe.ErrUnsupported)
+}
+
+// GetNICUsage implement interface, not visible to the BMC
+func (rf Server) GetNICUsage() (map[string]map[string]float64, error) {
+	return nil, fmt.Errorf("%w: NIC usage is not reported by Redfish", probe.ErrUnsupported)
+}
+
+// Close implement interface, idle connections are closed
+func (rf Server) Close() error {
+	rf.client.CloseIdleConnections()
+	return nil
+}
+
+// GetMetrics implement probe.Extended, metrics gathered before a failure are returned along with the error
+func (rf Server) GetMetrics() ([]probe.Metric, error) {
+	var sys system
+	if err := rf.get(rf.system, &sys); err != nil {
+		return nil, err
+	}
+
+	var ret []probe.Metric
+	add := func(name, help string, v float64, labels ...string) {
+		m := probe.Metric{Name: name, Help: help, Value: v}
+		if len(labels) > 0 {
+			m.Labels = map[string]string{}
+			for i := 0; i+1 < len(labels); i += 2 {
+				m.Labels[labels[i]] = labels[i+1]
+			}
+		}
+		ret = append(ret, m)
+	}
+
+	if rf.Enabled("power") {
+		add("redfish_power_on", "if the system is powered on: 1 - on, 0 - off or transitioning", boolToFloat(sys.PowerState == "On"))
+		if err := rf.power(add); err != nil {
+			return ret, err
+		}
+	}
+	if rf.Enabled("thermal") {
+		if err := rf.thermal(add); err != nil {
+			return ret, err
+		}
+	}
+	if rf.Enabled("inventory") {
+		add("redfish_system_healthy", "if the rollup health of the system is OK: 1 - OK, 0 - warning or critical", healthy(sys.Status))
+		add("redfish_system_info", "model and serial number of the system, the value is always 1", 1,
+			"manufacturer", sys.Manufacturer, "model", sys.Model, "serial", sys.SerialNumber)
+		if sys.ProcessorSummary.Count != nil {
+			add("redfish_cpu_count", "number of processors installed", float64(*sys.ProcessorSummary.Count))
+		}
+		if sys.MemorySummary.TotalSystemMemoryGiB != nil {
+			add("redfish_memory_bytes", "total memory installed in bytes", *sys.MemorySummary.TotalSystemMemoryGiB*(1<<30))
+		}
+		if sys.Memory.ID != "" {
+			count, err := rf.dimms(sys.Memory.ID)
+			if err != nil {
+				return ret, err
+			}
+			add("redfish_memory_dimm_count", "number of DIMMs installed, empty slots are excluded", float64(count))
+		}
+	}
+	return ret, nil
+}
+
+// power read the power draw and the health of power supplies
+func (rf Server) power(add func(name, help string, v float64, labels ...string)) error {
+	if rf.chassis == "" {
+		return fmt.Errorf("%w: no chassis is found", probe.ErrUnsupported)
+	}
+	var ch chassis
+	if err := rf.get(rf.chassis, &ch); err != nil {
+		return err
+	}
+	if ch.Power.ID == "" {
+		return fmt.Errorf("%w: power is not reported by the chassis", probe.ErrUnsupported)
+	}
+
+	var p power
+	if err := rf.get(ch.Power.ID, &p); err != nil {
+		return err
+	}
+	var watts float64
+	var found bool
+	for _, pc := range p.PowerControl {
+		if pc.PowerConsumedWatts != nil {
+			watts += *pc.PowerConsumedWatts
+			found = true
+		}
+	}
+	if found {
+		add("redfish_power_watts", "power consumed by the chassis in watts", watts)
+	}
+	for i, psu := range p.PowerSupplies {
+		if psu.Status.State == "Absent" {
+			continue
+		}
+		add("redfish_psu_healthy", "if the power supply is healthy: 1 - OK, 0 - warning or critical", healthy(psu.Status),
+			"psu", name(psu.Name, psu.MemberID, i))
+	}
+	return nil
+}
+
+// thermal read temperature sensors and the health of fans
+func (rf Server) thermal(add func(name, help string, v float64, labels ...string)) error {
+	if rf.chassis == "" {
+		return fmt.Errorf("%w: no chassis is found", probe.ErrUnsupported)
+	}
+	var ch chassis
+	if err := rf.get(rf.chassis, &ch); err != nil {
+		return err
+	}
+	if ch.Thermal.ID == "" {
+		return fmt.Errorf("%w: thermal is not reported by the chassis", probe.ErrUnsupported)
+	}
+
+	var t thermal
+	if err := rf.get(ch.Thermal.ID, &t); err != nil {
+		return err
+	}
+	for i, sensor := range t.Temperatures {
+		if sensor.ReadingCelsius == nil || sensor.Status.State == "Absent" {
+			continue
+		}
+		add("redfish_temperature_celsius", "temperature reading in celsius", *sensor.ReadingCelsius,
+			"sensor", name(sensor.Name, sensor.MemberID, i))
+	}
+	for i, fan := range t.Fans {
+		if fan.Status.State == "Absent" {
+			continue
+		}
+		n := fan.Name
+		if n == "" {
+			n = fan.FanName
+		}
+		add("redfish_fan_healthy", "if the fan is healthy: 1 - OK, 0 - warning or critical", healthy(fan.Status),
+			"fan", name(n, fan.MemberID, i))
+	}
+	return nil
+}
+
+// dimms count populated memory modules, some BMCs list empty slots as Absent
+func (rf Server) dimms(path string) (int, error) {
+	var modules collection
+	if err := rf.get(path, &modules); err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, m := range modules.Members {
+		var dimm memory
+		if err := rf.get(m.ID, &dimm); err != nil {
+			return 0, err
+		}
+		if dimm.Status.State != "Absent" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// get fetch a resource and decode it
+func (rf Server) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", rf.base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(rf.User, rf.Password)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := rf.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return probe.DecodeJSON(resp, v)
+}
+
+// healthy map a Redfish health to 1 (OK) or 0 (Warning, Critical), resources without health are healthy
+func healthy(s status) float64 {
+	return boolToFloat(s.Health == "" || s.Health == "OK")
+}
+
+// name label a sensor, fan or PSU with its name, member id or index
+func name(n, id string, i int) string {
+	switch {
+	case n != "":
+		return n
+	case id != "":
+		return id
+	}
+	return strconv.Itoa(i)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
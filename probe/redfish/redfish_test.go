@@ -0,0 +1,190 @@
+package redfish
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+	"github.com/kckecheng/osprobe/probe/internal/probetest"
+)
+
+// fixtures resources of a BMC managing two systems, the second one is linked to no chassis
+var fixtures = map[string]string{
+	"/redfish/v1/Systems": `{"Members": [{"@odata.id": "/redfish/v1/Systems/1"}, {"@odata.id": "/redfish/v1/Systems/2/"}]}`,
+	"/redfish/v1/Systems/1": `{
+		"Id": "1", "Manufacturer": "Dell Inc.", "Model": "PowerEdge R640", "SerialNumber": "ABC123",
+		"PowerState": "On", "Status": {"State": "Enabled", "Health": "OK"},
+		"ProcessorSummary": {"Count": 2}, "MemorySummary": {"TotalSystemMemoryGiB": 64},
+		"Memory": {"@odata.id": "/redfish/v1/Systems/1/Memory"},
+		"Links": {"Chassis": [{"@odata.id": "/redfish/v1/Chassis/1"}]}
+	}`,
+	"/redfish/v1/Systems/2/":          `{"Id": "2", "PowerState": "Off", "Status": {"Health": "Critical"}}`,
+	"/redfish/v1/Systems/1/Memory":    `{"Members": [{"@odata.id": "/redfish/v1/Systems/1/Memory/A1"}, {"@odata.id": "/redfish/v1/Systems/1/Memory/A2"}]}`,
+	"/redfish/v1/Systems/1/Memory/A1": `{"Status": {"State": "Enabled", "Health": "OK"}}`,
+	"/redfish/v1/Systems/1/Memory/A2": `{"Status": {"State": "Absent"}}`,
+	"/redfish/v1/Chassis":             `{"Members": [{"@odata.id": "/redfish/v1/Chassis/2"}]}`,
+	"/redfish/v1/Chassis/1":           `{"Power": {"@odata.id": "/redfish/v1/Chassis/1/Power"}, "Thermal": {"@odata.id": "/redfish/v1/Chassis/1/Thermal"}}`,
+	"/redfish/v1/Chassis/2":           `{}`,
+	"/redfish/v1/Chassis/1/Power": `{
+		"PowerControl": [{"PowerConsumedWatts": 200}, {"PowerConsumedWatts": 50.5}, {}],
+		"PowerSupplies": [
+			{"MemberId": "0", "Name": "PSU 1", "Status": {"State": "Enabled", "Health": "OK"}},
+			{"MemberId": "1", "Status": {"State": "Enabled", "Health": "Critical"}},
+			{"Name": "PSU 3", "Status": {"State": "Absent"}}
+		]
+	}`,
+	"/redfish/v1/Chassis/1/Thermal": `{
+		"Temperatures": [
+			{"Name": "Inlet Temp", "ReadingCelsius": 21, "Status": {"State": "Enabled", "Health": "OK"}},
+			{"Name": "Exhaust Temp", "Status": {"State": "Enabled"}},
+			{"MemberId": "2", "ReadingCelsius": 45, "Status": {"State": "Enabled"}}
+		],
+		"Fans": [
+			{"FanName": "Fan 1", "Status": {"State": "Enabled", "Health": "Warning"}},
+			{"Status": {"State": "Enabled", "Health": "OK"}}
+		]
+	}`,
+}
+
+// fakeBMC serve the fixtures with basic auth, root:calvin is accepted
+func fakeBMC() *probetest.API {
+	return probetest.NewAPI(fixtures, func(r *http.Request) bool {
+		user, password, ok := r.BasicAuth()
+		return ok && user == "root" && password == "calvin"
+	})
+}
+
+func TestLocate(t *testing.T) {
+	ts := fakeBMC()
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		password string
+		system   string
+		wantSys  string
+		wantCh   string
+		fail     bool
+		err      error
+	}{
+		{name: "first system", password: "calvin", wantSys: "/redfish/v1/Systems/1", wantCh: "/redfish/v1/Chassis/1"},
+		{name: "system without a chassis link", password: "calvin", system: "2", wantSys: "/redfish/v1/Systems/2/", wantCh: "/redfish/v1/Chassis/2"},
+		{name: "unknown system", password: "calvin", system: "3", fail: true},
+		{name: "wrong password", password: "wrong", fail: true, err: probe.ErrAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := map[string]string{}
+			if tt.system != "" {
+				options["system"] = tt.system
+			}
+			rf, err := NewServer(ts.ServerOf(t, probe.Server{User: "root", Password: tt.password, Type: "redfish", Options: options}))
+			if (err != nil) != tt.fail || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Fatalf("got error %v, want %v (failure %v)", err, tt.err, tt.fail)
+			}
+			if err != nil {
+				return
+			}
+			defer rf.Close()
+			if rf.system != tt.wantSys || rf.chassis != tt.wantCh {
+				t.Errorf("got system %s and chassis %s, want %s and %s", rf.system, rf.chassis, tt.wantSys, tt.wantCh)
+			}
+		})
+	}
+}
+
+func TestGetMetrics(t *testing.T) {
+	ts := fakeBMC()
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		system  string
+		metrics []string
+		want    []string
+		err     error
+	}{
+		{
+			name: "all metrics",
+			want: []string{
+				"redfish_cpu_count 2",
+				"redfish_fan_healthy{fan=1} 1",
+				"redfish_fan_healthy{fan=Fan 1} 0",
+				"redfish_memory_bytes 6.8719476736e+10",
+				"redfish_memory_dimm_count 1",
+				"redfish_power_on 1",
+				"redfish_power_watts 250.5",
+				"redfish_psu_healthy{psu=1} 0",
+				"redfish_psu_healthy{psu=PSU 1} 1",
+				"redfish_system_healthy 1",
+				"redfish_system_info{manufacturer=Dell Inc.,model=PowerEdge R640,serial=ABC123} 1",
+				"redfish_temperature_celsius{sensor=2} 45",
+				"redfish_temperature_celsius{sensor=Inlet Temp} 21",
+			},
+		},
+		{
+			name:    "thermal only",
+			metrics: []string{"thermal"},
+			want: []string{
+				"redfish_fan_healthy{fan=1} 1",
+				"redfish_fan_healthy{fan=Fan 1} 0",
+				"redfish_temperature_celsius{sensor=2} 45",
+				"redfish_temperature_celsius{sensor=Inlet Temp} 21",
+			},
+		},
+		{
+			name:    "chassis without power",
+			system:  "2",
+			metrics: []string{"power"},
+			want:    []string{"redfish_power_on 0"},
+			err:     probe.ErrUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := map[string]string{}
+			if tt.system != "" {
+				options["system"] = tt.system
+			}
+			rf, err := NewServer(ts.ServerOf(t, probe.Server{User: "root", Password: "calvin", Type: "redfish", Options: options, Metrics: tt.metrics}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rf.Close()
+
+			metrics, err := rf.GetMetrics()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			got := format(metrics)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+// format metrics as sorted "name{k=v,...} value" lines
+func format(metrics []probe.Metric) []string {
+	var ret []string
+	for _, m := range metrics {
+		var labels []string
+		for k, v := range m.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		name := m.Name
+		if len(labels) > 0 {
+			name += "{" + strings.Join(labels, ",") + "}"
+		}
+		ret = append(ret, fmt.Sprintf("%s %v", name, m.Value))
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,138 @@
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Backend a probe backend for a server type. Backends register themselves in init so that built-in
+// and third-party backends are linked in with a blank import, e.g., _ "github.com/kckecheng/osprobe/probe/all"
+type Backend struct {
+	// Type server type handled by the backend, e.g., linux
+	Type string
+	// New connect to a server, secrets of the server are resolved already
+	New func(server Server) (Probe, error)
+	// Port default port of the type
+	Port int
+	// UserOptional user is not required, e.g., SNMP v2c authenticates with a community only
+	UserOptional bool
+	// UserOptionalFor tell per server if the user is optional when UserOptional is set, e.g., by an auth option
+	UserOptionalFor func(server Server) bool
+	// Local the server is the machine osprobe runs on, neither port nor credential is required
+	Local bool
+	// Metrics metrics supported by the backend: cpu, mem, nic, or backend specific groups such as power
+	Metrics []string
+	// Hints OS detection hints used by the scanner
+	Hints Hints
+}
+
+// Hints OS detection hints used by the scanner when no protocol tells the type
+type Hints struct {
+	// Banners keywords in SSH banners and the confidence they indicate the type, an empty keyword matches any banner
+	Banners map[string]float64
+	// Ports groups of ports suggesting the type, at least one port of each group must be open
+	Ports [][]int
+	// PortConfidence confidence of the guess based on opened ports
+	PortConfidence float64
+}
+
+// OptionalUser check if a server of the backend authenticates without a user
+func (b Backend) OptionalUser(server Server) bool {
+	if b.UserOptional && b.UserOptionalFor != nil {
+		return b.UserOptionalFor(server)
+	}
+	return b.UserOptional
+}
+
+// Supports check if a metric is supported by the backend
+func (b Backend) Supports(metric string) bool {
+	for _, m := range b.Metrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	backends = map[string]Backend{}
+	mutex    sync.RWMutex
+)
+
+// Register make a backend available by its type, registering a type twice panics
+func Register(b Backend) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if b.Type == "" || b.New == nil {
+		panic("probe: backend type and constructor are required")
+	}
+	if _, ok := backends[b.Type]; ok {
+		panic(fmt.Sprintf("probe: backend %s is registered twice", b.Type))
+	}
+	backends[b.Type] = b
+}
+
+// Lookup find the backend of a type
+func Lookup(t string) (Backend, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	b, ok := backends[t]
+	return b, ok
+}
+
+// Backends all registered backends sorted by type
+func Backends() []Backend {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	var ret []Backend
+	for _, b := range backends {
+		ret = append(ret, b)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Type < ret[j].Type
+	})
+	return ret
+}
+
+// Types all registered types sorted
+func Types() []string {
+	var ret []string
+	for _, b := range Backends() {
+		ret = append(ret, b.Type)
+	}
+	return ret
+}
+
+// Connect create a probe for a server with the backend of its type
+func Connect(server Server) (Probe, error) {
+	b, ok := Lookup(server.Type)
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported operating system %s", ErrUnsupported, server.Type)
+	}
+	return b.New(server)
+}
+
+// Verify check that a connected probe can be used with its credential, e.g., for a dry run or a scan.
+// A standard metric supported by the backend is read, backends without any (e.g., redfish) are verified by
+// connecting. Metrics which are not available (ErrUnsupported) do not fail the check since the login worked.
+func Verify(p Probe, server Server) error {
+	b, _ := Lookup(server.Type)
+	var err error
+	switch {
+	case b.Supports("cpu"):
+		_, err = p.GetCPUUsage()
+	case b.Supports("mem"):
+		_, err = p.GetMemUsage()
+	case b.Supports("nic"):
+		_, err = p.GetNICUsage()
+	}
+	if errors.Is(err, ErrUnsupported) {
+		return nil
+	}
+	return err
+}
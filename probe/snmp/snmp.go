@@ -0,0 +1,441 @@
+package snmp
+
+/*
+	Storage arrays, switches and appliances which only speak SNMP. v1/v2c authenticates with the password
+	as the community, v3 uses USM with the user and the password as the authentication passphrase.
+	Options:
+	- version: 1, 2c (default) or 3;
+	- auth_protocol: MD5, SHA (default), SHA224, SHA256, SHA384 or SHA512;
+	- priv_protocol: DES, AES (default), AES192, AES256, AES192C or AES256C;
+	- priv_password: privacy passphrase, authPriv is used if it is set, authNoPriv otherwise;
+	- context: SNMP v3 context name;
+	- timeout: timeout(seconds) of each request, 5 by default;
+	- retries: retries of each request, 1 by default.
+
+	HOST-RESOURCES-MIB is used for CPU, memory and disks, UCD-SNMP-MIB (net-snmp) is preferred for memory
+	where available since buffers and caches are told, IF-MIB counters are used for NICs.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/kckecheng/osprobe/probe"
+	log "github.com/sirupsen/logrus"
+)
+
+// OIDs used by the probe
+const (
+	oidSysUpTime       = ".1.3.6.1.2.1.1.3.0"
+	oidHrProcessorLoad = ".1.3.6.1.2.1.25.3.3.1.2"
+	oidHrStorageType   = ".1.3.6.1.2.1.25.2.3.1.2"
+	oidHrStorageDescr  = ".1.3.6.1.2.1.25.2.3.1.3"
+	oidHrStorageUnits  = ".1.3.6.1.2.1.25.2.3.1.4"
+	oidHrStorageSize   = ".1.3.6.1.2.1.25.2.3.1.5"
+	oidHrStorageUsed   = ".1.3.6.1.2.1.25.2.3.1.6"
+	oidHrStorageRAM    = ".1.3.6.1.2.1.25.2.1.2"
+	oidHrStorageDisk   = ".1.3.6.1.2.1.25.2.1.4"
+	oidSsCPUIdle       = ".1.3.6.1.4.1.2021.11.11.0"
+	oidMemTotalReal    = ".1.3.6.1.4.1.2021.4.5.0"
+	oidMemAvailReal    = ".1.3.6.1.4.1.2021.4.6.0"
+	oidMemBuffer       = ".1.3.6.1.4.1.2021.4.14.0"
+	oidMemCached       = ".1.3.6.1.4.1.2021.4.15.0"
+	oidIfDescr         = ".1.3.6.1.2.1.2.2.1.2"
+	oidIfType          = ".1.3.6.1.2.1.2.2.1.3"
+	oidIfInOctets      = ".1.3.6.1.2.1.2.2.1.10"
+	oidIfOutOctets     = ".1.3.6.1.2.1.2.2.1.16"
+	oidIfName          = ".1.3.6.1.2.1.31.1.1.1.1"
+	oidIfHCInOctets    = ".1.3.6.1.2.1.31.1.1.1.6"
+	oidIfHCOutOctets   = ".1.3.6.1.2.1.31.1.1.1.10"
+)
+
+// ifTypeLoopback softwareLoopback in IANAifType-MIB
+const ifTypeLoopback = 24
+
+var authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+var privProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"DES":     gosnmp.DES,
+	"AES":     gosnmp.AES,
+	"AES192":  gosnmp.AES192,
+	"AES256":  gosnmp.AES256,
+	"AES192C": gosnmp.AES192C,
+	"AES256C": gosnmp.AES256C,
+}
+
+func init() {
+	probe.Register(probe.Backend{
+		Type:         "snmp",
+		New:          newProbe,
+		Port:         161,
+		UserOptional: true,
+		Metrics:      []string{"cpu", "mem", "nic"},
+	})
+}
+
+func newProbe(server probe.Server) (probe.Probe, error) {
+	p, err := NewServer(server)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Server SNMP agent
+type Server struct {
+	probe.Server
+	client *gosnmp.GoSNMP
+}
+
+// NewServer init with the version and protocols set in options, the agent is queried once to verify the credential
+func NewServer(s probe.Server) (Server, error) {
+	server := Server{Server: s}
+	server.Type = "snmp"
+	if !server.Valid() {
+		return server, errors.New("Inputs are not valid, please check")
+	}
+
+	timeout, err := strconv.Atoi(s.Option("timeout", "5"))
+	if err != nil || timeout <= 0 {
+		return server, fmt.Errorf("Invalid timeout option %s", s.Option("timeout", ""))
+	}
+	retries, err := strconv.Atoi(s.Option("retries", "1"))
+	if err != nil || retries < 0 {
+		return server, fmt.Errorf("Invalid retries option %s", s.Option("retries", ""))
+	}
+
+	client := &gosnmp.GoSNMP{
+		Target:             s.Host,
+		Port:               uint16(s.Port),
+		Timeout:            time.Duration(timeout) * time.Second,
+		Retries:            retries,
+		MaxOids:            gosnmp.MaxOids,
+		MaxRepetitions:     25,
+		ExponentialTimeout: true,
+	}
+
+	switch version := s.Option("version", "2c"); version {
+	case "1", "2c":
+		client.Version = gosnmp.Version2c
+		if version == "1" {
+			client.Version = gosnmp.Version1
+		}
+		client.Community = s.Password
+	case "3":
+		if err := server.usm(client); err != nil {
+			return server, err
+		}
+	default:
+		return server, fmt.Errorf("SNMP version %s is not supported", version)
+	}
+
+	if err := client.Connect(); err != nil {
+		log.Errorf("Fail to create client for %s due to %s", server.Server, err)
+		return server, err
+	}
+	server.client = client
+
+	// SNMP runs over UDP, bad communities are only found out by timeouts
+	if _, err := server.get(oidSysUpTime); err != nil {
+		log.Errorf("Fail to query %s due to %s", server.Server, err)
+		server.Close()
+		return server, err
+	}
+	return server, nil
+}
+
+// usm set the SNMP v3 user based security model
+func (snmp Server) usm(client *gosnmp.GoSNMP) error {
+	auth, ok := authProtocols[strings.ToUpper(snmp.Option("auth_protocol", "SHA"))]
+	if !ok {
+		return fmt.Errorf("SNMP auth protocol %s is not supported", snmp.Option("auth_protocol", ""))
+	}
+	priv, ok := privProtocols[strings.ToUpper(snmp.Option("priv_protocol", "AES"))]
+	if !ok {
+		return fmt.Errorf("SNMP privacy protocol %s is not supported", snmp.Option("priv_protocol", ""))
+	}
+
+	params := &gosnmp.UsmSecurityParameters{
+		UserName:                 snmp.User,
+		AuthenticationProtocol:   auth,
+		AuthenticationPassphrase: snmp.Password,
+		PrivacyProtocol:          gosnmp.NoPriv,
+	}
+	client.MsgFlags = gosnmp.AuthNoPriv
+	if password := snmp.Option("priv_password", ""); password != "" {
+		params.PrivacyProtocol = priv
+		params.PrivacyPassphrase = password
+		client.MsgFlags = gosnmp.AuthPriv
+	}
+
+	client.Version = gosnmp.Version3
+	client.SecurityModel = gosnmp.UserSecurityModel
+	client.SecurityParameters = params
+	client.ContextName = snmp.Option("context", "")
+	return nil
+}
+
+// GetCPUUsage implement interface, the average hrProcessorLoad (last minute) of all processors,
+// or UCD-SNMP-MIB ssCpuIdle if no processor is listed
+func (snmp Server) GetCPUUsage() (float64, error) {
+	loads, err := snmp.walk(oidHrProcessorLoad)
+	if err != nil {
+		return 0, err
+	}
+	if len(loads) > 0 {
+		var total float64
+		for _, load := range loads {
+			total += load
+		}
+		return total / float64(len(loads)), nil
+	}
+
+	values, err := snmp.get(oidSsCPUIdle)
+	if err != nil {
+		return 0, err
+	}
+	idle, ok := values[oidSsCPUIdle]
+	if !ok {
+		return 0, fmt.Errorf("%w: neither hrProcessorLoad nor ssCpuIdle is available", probe.ErrUnsupported)
+	}
+	return 100 - idle, nil
+}
+
+// GetMemUsage implement interface, buffers and caches are excluded if UCD-SNMP-MIB is available
+func (snmp Server) GetMemUsage() (float64, error) {
+	values, err := snmp.get(oidMemTotalReal, oidMemAvailReal, oidMemBuffer, oidMemCached)
+	if err != nil {
+		return 0, err
+	}
+	if total := values[oidMemTotalReal]; total > 0 {
+		used := total - values[oidMemAvailReal] - values[oidMemBuffer] - values[oidMemCached]
+		if used < 0 {
+			used = total - values[oidMemAvailReal]
+		}
+		return used * 100 / total, nil
+	}
+
+	storages, err := snmp.storages(oidHrStorageRAM)
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range storages {
+		if s.size > 0 {
+			return s.used * 100 / s.size, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: no RAM is listed in hrStorageTable", probe.ErrUnsupported)
+}
+
+// GetLocalDiskUsage implement interface, usage of fixed disks in hrStorageTable
+func (snmp Server) GetLocalDiskUsage() (map[string]float64, error) {
+	storages, err := snmp.storages(oidHrStorageDisk)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string]float64{}
+	for _, s := range storages {
+		if s.size > 0 {
+			ret[s.descr] = s.used * 100 / s.size
+		}
+	}
+	return ret, nil
+}
+
+// GetNICUsage implement interface, 64-bit IF-MIB counters are preferred, loopback interfaces are skipped
+func (snmp Server) GetNICUsage() (map[string]map[string]float64, error) {
+	descrs, err := snmp.walkStrings(oidIfDescr)
+	if err != nil {
+		return nil, err
+	}
+	names, _ := snmp.walkStrings(oidIfName)
+	types, _ := snmp.walk(oidIfType)
+
+	received, err := snmp.walk(oidIfHCInOctets)
+	if err != nil || len(received) == 0 {
+		if received, err = snmp.walk(oidIfInOctets); err != nil {
+			return nil, err
+		}
+	}
+	sent, err := snmp.walk(oidIfHCOutOctets)
+	if err != nil || len(sent) == 0 {
+		if sent, err = snmp.walk(oidIfOutOctets); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := map[string]map[string]float64{}
+	for index, descr := range descrs {
+		if types[index] == ifTypeLoopback {
+			continue
+		}
+		name := descr
+		if n := names[index]; n != "" {
+			name = n
+		}
+		ret[name] = map[string]float64{
+			"received": received[index],
+			"sent":     sent[index],
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("%w: no interface is listed in ifTable", probe.ErrParse)
+	}
+	return ret, nil
+}
+
+// Close implement interface, the UDP socket is closed
+func (snmp Server) Close() error {
+	if snmp.client == nil || snmp.client.Conn == nil {
+		return nil
+	}
+	return snmp.client.Conn.Close()
+}
+
+// storage an entry of hrStorageTable, size and used are in bytes
+type storage struct {
+	descr string
+	size  float64
+	used  float64
+}
+
+// storages list hrStorageTable entries of a type
+func (snmp Server) storages(storageType string) ([]storage, error) {
+	pdus, err := snmp.walkPDUs(oidHrStorageType)
+	if err != nil {
+		return nil, err
+	}
+	descrs, err := snmp.walkStrings(oidHrStorageDescr)
+	if err != nil {
+		return nil, err
+	}
+	units, err := snmp.walk(oidHrStorageUnits)
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := snmp.walk(oidHrStorageSize)
+	if err != nil {
+		return nil, err
+	}
+	used, err := snmp.walk(oidHrStorageUsed)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []storage
+	for index, pdu := range pdus {
+		if oid, ok := pdu.Value.(string); !ok || "."+strings.TrimPrefix(oid, ".") != storageType {
+			continue
+		}
+		ret = append(ret, storage{
+			descr: descrs[index],
+			size:  sizes[index] * units[index],
+			used:  used[index] * units[index],
+		})
+	}
+	return ret, nil
+}
+
+// get query scalar values, objects which do not exist are left out
+func (snmp Server) get(oids ...string) (map[string]float64, error) {
+	result, err := snmp.client.Get(oids)
+	if err != nil {
+		return nil, snmpError(err)
+	}
+	if result.Error != gosnmp.NoError {
+		return nil, fmt.Errorf("SNMP error %s", result.Error)
+	}
+
+	ret := map[string]float64{}
+	for _, pdu := range result.Variables {
+		if v, ok := number(pdu); ok {
+			ret["."+strings.TrimPrefix(pdu.Name, ".")] = v
+		}
+	}
+	return ret, nil
+}
+
+// walkPDUs walk a table column, PDUs are keyed by their index
+func (snmp Server) walkPDUs(root string) (map[string]gosnmp.SnmpPDU, error) {
+	var pdus []gosnmp.SnmpPDU
+	var err error
+	if snmp.client.Version == gosnmp.Version1 {
+		pdus, err = snmp.client.WalkAll(root)
+	} else {
+		pdus, err = snmp.client.BulkWalkAll(root)
+	}
+	if err != nil {
+		return nil, snmpError(err)
+	}
+
+	ret := map[string]gosnmp.SnmpPDU{}
+	for _, pdu := range pdus {
+		index := strings.TrimPrefix(strings.TrimPrefix("."+strings.TrimPrefix(pdu.Name, "."), root), ".")
+		ret[index] = pdu
+	}
+	return ret, nil
+}
+
+// walk walk a column of numbers
+func (snmp Server) walk(root string) (map[string]float64, error) {
+	pdus, err := snmp.walkPDUs(root)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]float64{}
+	for index, pdu := range pdus {
+		if v, ok := number(pdu); ok {
+			ret[index] = v
+		}
+	}
+	return ret, nil
+}
+
+// walkStrings walk a column of strings
+func (snmp Server) walkStrings(root string) (map[string]string, error) {
+	pdus, err := snmp.walkPDUs(root)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]string{}
+	for index, pdu := range pdus {
+		if b, ok := pdu.Value.([]byte); ok {
+			ret[index] = strings.TrimRight(string(b), "\x00")
+		}
+	}
+	return ret, nil
+}
+
+// number convert integers, gauges and counters to float64
+func number(pdu gosnmp.SnmpPDU) (float64, bool) {
+	switch pdu.Type {
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Counter64, gosnmp.Uinteger32:
+		f, _ := new(big.Float).SetInt(gosnmp.ToBigInt(pdu.Value)).Float64()
+		return f, true
+	}
+	return 0, false
+}
+
+// snmpError categorize errors of the SNMP client
+func snmpError(err error) error {
+	switch {
+	case errors.Is(err, gosnmp.ErrUnknownUsername), errors.Is(err, gosnmp.ErrWrongDigest), errors.Is(err, gosnmp.ErrUnknownSecurityLevel):
+		return fmt.Errorf("%w: %s", probe.ErrAuth, err)
+	case strings.Contains(err.Error(), "timeout"):
+		return fmt.Errorf("%w: %s", probe.ErrTimeout, err)
+	}
+	return err
+}
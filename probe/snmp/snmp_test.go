@@ -0,0 +1,311 @@
+package snmp
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/kckecheng/osprobe/probe"
+)
+
+// agent an in-process SNMP v1/v2c agent serving a MIB, requests with another community are dropped
+type agent struct {
+	conn      net.PacketConn
+	community string
+	mib       []gosnmp.SnmpPDU // sorted by OID
+}
+
+func newAgent(t *testing.T, community string, mib []gosnmp.SnmpPDU) *agent {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sorted := append([]gosnmp.SnmpPDU{}, mib...)
+	sort.Slice(sorted, func(i, j int) bool { return compareOID(sorted[i].Name, sorted[j].Name) < 0 })
+	a := &agent{conn: conn, community: community, mib: sorted}
+	go a.serve()
+	return a
+}
+
+func (a *agent) serve() {
+	buf := make([]byte, 65536)
+	decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+	for {
+		n, addr, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req, err := decoder.SnmpDecodePacket(buf[:n])
+		if err != nil || req.Community != a.community {
+			continue
+		}
+		resp := a.respond(req)
+		if out, err := resp.MarshalMsg(); err == nil {
+			a.conn.WriteTo(out, addr)
+		}
+	}
+}
+
+// respond answer Get, GetNext and GetBulk requests
+func (a *agent) respond(req *gosnmp.SnmpPacket) *gosnmp.SnmpPacket {
+	resp := &gosnmp.SnmpPacket{
+		Version:   req.Version,
+		Community: req.Community,
+		PDUType:   gosnmp.GetResponse,
+		RequestID: req.RequestID,
+	}
+	for _, v := range req.Variables {
+		switch req.PDUType {
+		case gosnmp.GetRequest:
+			pdu, ok := a.lookup(v.Name)
+			if !ok {
+				pdu = gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.NoSuchObject}
+			}
+			resp.Variables = append(resp.Variables, pdu)
+		case gosnmp.GetNextRequest:
+			next := a.next(v.Name, 1)
+			if len(next) == 0 {
+				// v1 agents report the end of the MIB as noSuchName
+				resp.Error, resp.ErrorIndex = gosnmp.NoSuchName, 1
+				return resp
+			}
+			resp.Variables = append(resp.Variables, next...)
+		case gosnmp.GetBulkRequest:
+			// The decoder of gosnmp drops max-repetitions, the probe asks for 25
+			repetitions := int(req.MaxRepetitions)
+			if repetitions == 0 {
+				repetitions = 25
+			}
+			next := a.next(v.Name, repetitions)
+			if len(next) < repetitions {
+				next = append(next, gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.EndOfMibView})
+			}
+			resp.Variables = append(resp.Variables, next...)
+		}
+	}
+	return resp
+}
+
+func (a *agent) lookup(oid string) (gosnmp.SnmpPDU, bool) {
+	for _, pdu := range a.mib {
+		if compareOID(pdu.Name, oid) == 0 {
+			return pdu, true
+		}
+	}
+	return gosnmp.SnmpPDU{}, false
+}
+
+// next up to n objects after an OID
+func (a *agent) next(oid string, n int) []gosnmp.SnmpPDU {
+	var ret []gosnmp.SnmpPDU
+	for _, pdu := range a.mib {
+		if len(ret) < n && compareOID(pdu.Name, oid) > 0 {
+			ret = append(ret, pdu)
+		}
+	}
+	return ret
+}
+
+func (a *agent) server(t *testing.T, community string, options map[string]string) probe.Server {
+	host, port, err := net.SplitHostPort(a.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	return probe.Server{Host: host, Port: p, Password: community, Type: "snmp", Options: options}
+}
+
+// compareOID compare OIDs numerically
+func compareOID(a, b string) int {
+	as := strings.Split(strings.Trim(a, "."), ".")
+	bs := strings.Split(strings.Trim(b, "."), ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.Atoi(as[i])
+		y, _ := strconv.Atoi(bs[i])
+		if x != y {
+			return x - y
+		}
+	}
+	return len(as) - len(bs)
+}
+
+func integer(oid string, v int) gosnmp.SnmpPDU {
+	return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.Integer, Value: v}
+}
+
+func gauge(oid string, v uint) gosnmp.SnmpPDU {
+	return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.Gauge32, Value: v}
+}
+
+func counter64(oid string, v uint64) gosnmp.SnmpPDU {
+	return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.Counter64, Value: v}
+}
+
+func str(oid, v string) gosnmp.SnmpPDU {
+	return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.OctetString, Value: v}
+}
+
+func objectID(oid, v string) gosnmp.SnmpPDU {
+	return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.ObjectIdentifier, Value: v}
+}
+
+// netSNMP a Linux host running net-snmp with HOST-RESOURCES-MIB, UCD-SNMP-MIB and 64-bit IF-MIB counters
+var netSNMP = []gosnmp.SnmpPDU{
+	{Name: oidSysUpTime, Type: gosnmp.TimeTicks, Value: uint32(100)},
+	integer(oidHrProcessorLoad+".196608", 20),
+	integer(oidHrProcessorLoad+".196609", 40),
+	integer(oidMemTotalReal, 1000),
+	integer(oidMemAvailReal, 200),
+	integer(oidMemBuffer, 100),
+	integer(oidMemCached, 200),
+	objectID(oidHrStorageType+".1", oidHrStorageRAM),
+	objectID(oidHrStorageType+".31", oidHrStorageDisk),
+	objectID(oidHrStorageType+".32", oidHrStorageDisk),
+	str(oidHrStorageDescr+".1", "Physical memory"),
+	str(oidHrStorageDescr+".31", "/"),
+	str(oidHrStorageDescr+".32", "/mnt"),
+	integer(oidHrStorageUnits+".1", 1024),
+	integer(oidHrStorageUnits+".31", 4096),
+	integer(oidHrStorageUnits+".32", 4096),
+	integer(oidHrStorageSize+".1", 1000),
+	integer(oidHrStorageSize+".31", 1000),
+	integer(oidHrStorageSize+".32", 0),
+	integer(oidHrStorageUsed+".1", 900),
+	integer(oidHrStorageUsed+".31", 250),
+	integer(oidHrStorageUsed+".32", 0),
+	str(oidIfDescr+".1", "lo"),
+	str(oidIfDescr+".2", "Intel Corporation 82540EM"),
+	integer(oidIfType+".1", ifTypeLoopback),
+	integer(oidIfType+".2", 6),
+	gosnmp.SnmpPDU{Name: oidIfInOctets + ".2", Type: gosnmp.Counter32, Value: uint(1)},
+	gosnmp.SnmpPDU{Name: oidIfOutOctets + ".2", Type: gosnmp.Counter32, Value: uint(2)},
+	str(oidIfName+".1", "lo"),
+	str(oidIfName+".2", "eth0"),
+	counter64(oidIfHCInOctets+".1", 5),
+	counter64(oidIfHCInOctets+".2", 1<<40),
+	counter64(oidIfHCOutOctets+".1", 5),
+	counter64(oidIfHCOutOctets+".2", 2000),
+}
+
+// appliance an agent without processors, UCD-SNMP-MIB memory, disks, ifXTable and ifName
+var appliance = []gosnmp.SnmpPDU{
+	{Name: oidSysUpTime, Type: gosnmp.TimeTicks, Value: uint32(100)},
+	integer(oidSsCPUIdle, 90),
+	objectID(oidHrStorageType+".1", oidHrStorageRAM),
+	str(oidHrStorageDescr+".1", "RAM"),
+	integer(oidHrStorageUnits+".1", 1024),
+	integer(oidHrStorageSize+".1", 1000),
+	integer(oidHrStorageUsed+".1", 600),
+	str(oidIfDescr+".1", "port1"),
+	integer(oidIfType+".1", 6),
+	gauge(oidIfInOctets+".1", 100),
+	gauge(oidIfOutOctets+".1", 200),
+}
+
+func TestServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		mib     []gosnmp.SnmpPDU
+		version string
+		cpu     float64
+		mem     float64
+		disks   map[string]float64
+		nics    map[string]map[string]float64
+	}{
+		{
+			name:  "net-snmp",
+			mib:   netSNMP,
+			cpu:   30,
+			mem:   50,
+			disks: map[string]float64{"/": 25},
+			nics:  map[string]map[string]float64{"eth0": {"received": 1 << 40, "sent": 2000}},
+		},
+		{
+			name:    "net-snmp with v1",
+			mib:     netSNMP,
+			version: "1",
+			cpu:     30,
+			mem:     50,
+			disks:   map[string]float64{"/": 25},
+			nics:    map[string]map[string]float64{"eth0": {"received": 1 << 40, "sent": 2000}},
+		},
+		{
+			name:  "appliance",
+			mib:   appliance,
+			cpu:   10,
+			mem:   60,
+			disks: map[string]float64{},
+			nics:  map[string]map[string]float64{"port1": {"received": 100, "sent": 200}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAgent(t, "public", tt.mib)
+			defer a.conn.Close()
+
+			options := map[string]string{"timeout": "1"}
+			if tt.version != "" {
+				options["version"] = tt.version
+			}
+			snmp, err := NewServer(a.server(t, "public", options))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer snmp.Close()
+
+			if cpu, err := snmp.GetCPUUsage(); err != nil || cpu != tt.cpu {
+				t.Errorf("got CPU usage %v (%v), want %v", cpu, err, tt.cpu)
+			}
+			if mem, err := snmp.GetMemUsage(); err != nil || mem != tt.mem {
+				t.Errorf("got memory usage %v (%v), want %v", mem, err, tt.mem)
+			}
+			if disks, err := snmp.GetLocalDiskUsage(); err != nil || !reflect.DeepEqual(disks, tt.disks) {
+				t.Errorf("got disk usage %v (%v), want %v", disks, err, tt.disks)
+			}
+			if nics, err := snmp.GetNICUsage(); err != nil || !reflect.DeepEqual(nics, tt.nics) {
+				t.Errorf("got NIC usage %v (%v), want %v", nics, err, tt.nics)
+			}
+		})
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	a := newAgent(t, "public", appliance)
+	defer a.conn.Close()
+
+	tests := []struct {
+		name      string
+		community string
+		options   map[string]string
+		fail      bool
+		err       error
+	}{
+		{name: "community", community: "public"},
+		{name: "wrong community", community: "private", options: map[string]string{"retries": "0"}, fail: true, err: probe.ErrTimeout},
+		{name: "unsupported version", community: "public", options: map[string]string{"version": "4"}, fail: true},
+		{name: "invalid timeout", community: "public", options: map[string]string{"timeout": "0"}, fail: true},
+		{name: "unsupported auth protocol", community: "public", options: map[string]string{"version": "3", "auth_protocol": "SHA1024"}, fail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := map[string]string{"timeout": "1"}
+			for k, v := range tt.options {
+				options[k] = v
+			}
+			snmp, err := NewServer(a.server(t, tt.community, options))
+			if (err != nil) != tt.fail || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Fatalf("got error %v, want %v (failure %v)", err, tt.err, tt.fail)
+			}
+			if err == nil {
+				snmp.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package probe
+
+import "sort"
+
+// VM usage of a guest reported by a hypervisor, values which cannot be probed are omitted
+type VM struct {
+	Name  string
+	ID    string // stable id unique within the hypervisor or cluster, e.g., the domain UUID or the VMID
+	State string // e.g., running, paused, shutoff
+	Node  string // node hosting the VM for cluster APIs, empty for a single hypervisor
+	// Stats keys of vmHelp, e.g., vcpus or mem_bytes
+	Stats map[string]float64
+	// Disks read and written bytes of each disk
+	Disks map[string]map[string]float64
+	// NICs received and sent bytes of each NIC
+	NICs map[string]map[string]float64
+}
+
+// vmHelp per VM stats exported as vm_<key>{vm, id, node}, counters as vm_<key>_total
+var vmHelp = map[string]string{
+	"vcpus":             "virtual CPUs of the VM",
+	"cpu_seconds":       "CPU time consumed by the VM since it was started in seconds",
+	"cpu_utilization":   "CPU utilization of the VM in percent",
+	"mem_bytes":         "memory configured for the VM in bytes",
+	"mem_balloon_bytes": "memory currently assigned to the VM by the balloon driver in bytes",
+	"mem_used_bytes":    "memory used by the VM in bytes",
+	"uptime_seconds":    "seconds since the VM was started",
+	"disk_bytes":        "disk space provisioned for the VM in bytes",
+	"disk_used_bytes":   "disk space used by the VM in bytes",
+}
+
+// vmCounters VM stats which are cumulative
+var vmCounters = map[string]bool{"cpu_seconds": true}
+
+// VMMetrics convert guests to metrics with the vm, id and node labels, they look the same for all hypervisors.
+// Names are not unique, e.g., guests on different nodes of a cluster, the id tells them apart.
+func VMMetrics(vms []VM) []Metric {
+	var ret []Metric
+	for _, vm := range vms {
+		ret = append(ret, Metric{
+			Name:   "vm_state",
+			Help:   "state of the VM as reported by the hypervisor, the value is always 1",
+			Labels: vm.labels("state", vm.State),
+			Value:  1,
+		})
+
+		var keys []string
+		for k := range vm.Stats {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ret = append(ret, stat("vm_"+k, vmHelp[k], vmCounters[k], vm.labels("", ""), vm.Stats[k]))
+		}
+
+		ret = append(ret, deviceMetrics(vm, "disk", vm.Disks, map[string]string{
+			"read":    "bytes read from the disk of the VM since it was started",
+			"written": "bytes written to the disk of the VM since it was started",
+		})...)
+		ret = append(ret, deviceMetrics(vm, "nic", vm.NICs, map[string]string{
+			"received": "bytes received by the NIC of the VM since it was started",
+			"sent":     "bytes sent by the NIC of the VM since it was started",
+		})...)
+	}
+	return ret
+}
+
+// labels vm, id and node labels of the VM, with another label if key is not empty
+func (vm VM) labels(key, value string) map[string]string {
+	ret := map[string]string{"vm": vm.Name, "id": vm.ID, "node": vm.Node}
+	if key != "" {
+		ret[key] = value
+	}
+	return ret
+}
+
+// deviceMetrics export counters of disks or NICs as vm_<kind>_<counter>_bytes_total{vm, id, node, <kind>}
+func deviceMetrics(vm VM, kind string, devices map[string]map[string]float64, help map[string]string) []Metric {
+	var names []string
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ret []Metric
+	for _, name := range names {
+		for _, counter := range []string{"read", "written", "received", "sent"} {
+			v, ok := devices[name][counter]
+			if !ok {
+				continue
+			}
+			ret = append(ret, stat("vm_"+kind+"_"+counter+"_bytes", help[counter], true, vm.labels(kind, name), v))
+		}
+	}
+	return ret
+}
+
+// stat a gauge, or a counter named with the _total suffix
+func stat(name, help string, counter bool, labels map[string]string, v float64) Metric {
+	if counter {
+		return Metric{Name: name + "_total", Help: help, Type: Counter, Labels: labels, Value: v}
+	}
+	return Metric{Name: name, Help: help, Labels: labels, Value: v}
+}
+
+// Node usage of a hypervisor node reported by a cluster API, values which cannot be probed are omitted
+type Node struct {
+	Name  string
+	State string // e.g., online, offline, maintenance
+	// Stats keys of nodeHelp, e.g., cpu_utilization
+	Stats map[string]float64
+}
+
+// nodeHelp per node stats exported as node_<key>{node}, counters as node_<key>_total
+var nodeHelp = map[string]string{
+	"cpu_utilization":               "cpu utilization of the node in percent",
+	"mem_utilization":               "memory utilization of the node in percent",
+	"disk_utilization":              "utilization of the root file system of the node in percent",
+	"nic_received_bytes":            "bytes received by all NICs of the node since boot",
+	"nic_sent_bytes":                "bytes sent by all NICs of the node since boot",
+	"nic_received_bytes_per_second": "bytes received by all NICs of the node per second, averaged by the cluster",
+	"nic_sent_bytes_per_second":     "bytes sent by all NICs of the node per second, averaged by the cluster",
+	"cpus":                          "logical CPUs of the node",
+	"mem_bytes":                     "memory of the node in bytes",
+	"uptime_seconds":                "seconds since the node was booted",
+}
+
+// nodeCounters node stats which are cumulative
+var nodeCounters = map[string]bool{"nic_received_bytes": true, "nic_sent_bytes": true}
+
+// NodeMetrics convert nodes to metrics with the node label, they look the same for all cluster APIs
+func NodeMetrics(nodes []Node) []Metric {
+	var ret []Metric
+	for _, node := range nodes {
+		ret = append(ret, Metric{
+			Name:   "node_state",
+			Help:   "state of the node as reported by the cluster, the value is always 1",
+			Labels: map[string]string{"node": node.Name, "state": node.State},
+			Value:  1,
+		})
+
+		var keys []string
+		for k := range node.Stats {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ret = append(ret, stat("node_"+k, nodeHelp[k], nodeCounters[k], map[string]string{"node": node.Name}, node.Stats[k]))
+		}
+	}
+	return ret
+}
+
+// Overcommit ratios of vCPUs and memory assigned to running guests against the host capacity,
+// cpus and mem (bytes) of the host are skipped if unknown (0)
+func Overcommit(vms []VM, cpus, mem float64) []Metric {
+	var vcpus, assigned float64
+	for _, vm := range vms {
+		if vm.State != "running" {
+			continue
+		}
+		vcpus += vm.Stats["vcpus"]
+		assigned += vm.Stats["mem_bytes"]
+	}
+
+	var ret []Metric
+	if cpus > 0 {
+		ret = append(ret, Metric{
+			Name:  "vcpu_overcommit_ratio",
+			Help:  "virtual CPUs of running VMs divided by physical CPUs of the host",
+			Value: vcpus / cpus,
+		})
+	}
+	if mem > 0 {
+		ret = append(ret, Metric{
+			Name:  "mem_overcommit_ratio",
+			Help:  "memory configured for running VMs divided by memory of the host",
+			Value: assigned / mem,
+		})
+	}
+	return ret
+}
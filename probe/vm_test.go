@@ -0,0 +1,57 @@
+package probe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVMMetrics(t *testing.T) {
+	vms := []VM{{
+		Name:  "web",
+		ID:    "100",
+		State: "running",
+		Stats: map[string]float64{"vcpus": 2, "cpu_seconds": 60},
+		Disks: map[string]map[string]float64{"vda": {"read": 1, "written": 2}},
+		NICs:  map[string]map[string]float64{"vnet0": {"received": 3, "sent": 4}},
+	}}
+
+	got := map[string]ValueType{}
+	for _, m := range VMMetrics(vms) {
+		got[m.Name] = m.Type
+	}
+	want := map[string]ValueType{
+		"vm_state":                    Gauge,
+		"vm_vcpus":                    Gauge,
+		"vm_cpu_seconds_total":        Counter,
+		"vm_disk_read_bytes_total":    Counter,
+		"vm_disk_written_bytes_total": Counter,
+		"vm_nic_received_bytes_total": Counter,
+		"vm_nic_sent_bytes_total":     Counter,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNodeMetrics(t *testing.T) {
+	nodes := []Node{{
+		Name:  "pve1",
+		State: "online",
+		Stats: map[string]float64{"cpu_utilization": 10, "nic_received_bytes": 1, "nic_sent_bytes": 2, "nic_sent_bytes_per_second": 3},
+	}}
+
+	got := map[string]ValueType{}
+	for _, m := range NodeMetrics(nodes) {
+		got[m.Name] = m.Type
+	}
+	want := map[string]ValueType{
+		"node_state":                     Gauge,
+		"node_cpu_utilization":           Gauge,
+		"node_nic_received_bytes_total":  Counter,
+		"node_nic_sent_bytes_total":      Counter,
+		"node_nic_sent_bytes_per_second": Gauge,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
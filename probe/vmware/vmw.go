@@ -1,7 +1,8 @@
 package vmware
 
 /*
-	Connect to ESXi - do not connect to vCenter although it works
+	Connect to ESXi (esxi) or vCenter (vcenter), the usage of all connected host systems is reported
+	together, which is the usage of the host itself for ESXi and of all clusters for vCenter
 */
 
 import (
@@ -14,14 +15,43 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/view"
-	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
 )
 
 // Server vCenter/ESXi
 type Server struct {
 	probe.Server
-	client *vim25.Client
+	client *govmomi.Client
+}
+
+func init() {
+	probe.Register(probe.Backend{
+		Type:    "esxi",
+		New:     newProbe,
+		Port:    443,
+		Metrics: []string{"cpu", "mem"},
+		Hints: probe.Hints{
+			// vSphere client to ESX/ESXi, and to VMs for ESXi 5.x and later (902) or ESXi 3.5 and 4.x (903)
+			Ports:          [][]int{{443}, {902, 903}},
+			PortConfidence: 0.4,
+		},
+	})
+	probe.Register(probe.Backend{
+		Type:    "vcenter",
+		New:     newProbe,
+		Port:    443,
+		Metrics: []string{"cpu", "mem"},
+	})
+}
+
+func newProbe(server probe.Server) (probe.Probe, error) {
+	p, err := NewServer(server.Host, server.User, server.Password, server.Port)
+	if err != nil {
+		return nil, err
+	}
+	p.Type = server.Type
+	return p, nil
 }
 
 // NewServer init
@@ -50,11 +80,11 @@ func NewServer(host, user, password string, port int) (Server, error) {
 	ctx := context.Background()
 	c, err := govmomi.NewClient(ctx, &u, true)
 	if err != nil {
-		log.Errorf("Fail to create client for %+v", server.Server)
+		log.Errorf("Fail to create client for %s due to %s", server.Server, err)
 		return server, err
 	}
 
-	server.client = c.Client
+	server.client = c
 	return server, nil
 }
 
@@ -65,14 +95,15 @@ func (vmw Server) GetCPUUsage() (float64, error) {
 		return 0, err
 	}
 
-	var ret []float64
+	var totalCPU, usedCPU int64
 	for _, h := range esxiHosts {
-		totalCPU := int64(h.Summary.Hardware.CpuMhz) * int64(h.Summary.Hardware.NumCpuCores)
-		usedCPU := int64(h.Summary.QuickStats.OverallCpuUsage)
-
-		ret = append(ret, (float64(usedCPU)/float64(totalCPU))*100)
+		totalCPU += int64(h.Summary.Hardware.CpuMhz) * int64(h.Summary.Hardware.NumCpuCores)
+		usedCPU += int64(h.Summary.QuickStats.OverallCpuUsage)
+	}
+	if totalCPU == 0 {
+		return 0, fmt.Errorf("%w: no connected host system found", probe.ErrParse)
 	}
-	return ret[0], nil
+	return float64(usedCPU) / float64(totalCPU) * 100, nil
 }
 
 // GetMemUsage implement interface
@@ -82,27 +113,38 @@ func (vmw Server) GetMemUsage() (float64, error) {
 		return 0, err
 	}
 
-	var ret []float64
+	var totalMemory, usedMemory int64
 	for _, h := range esxiHosts {
-		totalMemory := int64(h.Summary.Hardware.MemorySize)
-		usedMemory := (int64(h.Summary.QuickStats.OverallMemoryUsage) * 1024 * 1024)
-		ret = append(ret, float64(usedMemory)/float64(totalMemory)*100)
+		totalMemory += h.Summary.Hardware.MemorySize
+		usedMemory += int64(h.Summary.QuickStats.OverallMemoryUsage) * 1024 * 1024
 	}
-	return ret[0], nil
+	if totalMemory == 0 {
+		return 0, fmt.Errorf("%w: no connected host system found", probe.ErrParse)
+	}
+	return float64(usedMemory) / float64(totalMemory) * 100, nil
 }
 
 // GetNICUsage implement interface
 func (vmw Server) GetNICUsage() (map[string]map[string]float64, error) {
-	return nil, errors.New("Not implemented")
+	return nil, probe.ErrUnsupported
 }
 
 // GetLocalDiskUsage implement interface
 func (vmw Server) GetLocalDiskUsage() (map[string]float64, error) {
-	return nil, errors.New("Not implemented")
+	return nil, probe.ErrUnsupported
+}
+
+// Close implement interface, the session is logged out so that sessions do not pile up on the host
+func (vmw Server) Close() error {
+	if vmw.client == nil {
+		return nil
+	}
+	defer vmw.client.CloseIdleConnections()
+	return vmw.client.Logout(context.Background())
 }
 
 func (vmw Server) getHostMor() ([]mo.HostSystem, error) {
-	c := vmw.client
+	c := vmw.client.Client
 	m := view.NewManager(c)
 
 	ctx := context.Background()
@@ -111,6 +153,7 @@ func (vmw Server) getHostMor() ([]mo.HostSystem, error) {
 		log.Errorf("Fail to create host view due to %s", err)
 		return nil, err
 	}
+	defer v.Destroy(ctx)
 
 	var hosts []mo.HostSystem
 	err = v.Retrieve(ctx, []string{"HostSystem"}, []string{"summary"}, &hosts)
@@ -118,5 +161,13 @@ func (vmw Server) getHostMor() ([]mo.HostSystem, error) {
 		log.Errorf("Fail to grab host summary information due to %s", err)
 		return nil, err
 	}
-	return hosts, nil
+
+	// Disconnected hosts of a vCenter report no usage
+	connected := hosts[:0]
+	for _, h := range hosts {
+		if h.Summary.Hardware != nil && (h.Summary.Runtime == nil || h.Summary.Runtime.ConnectionState == types.HostSystemConnectionStateConnected) {
+			connected = append(connected, h)
+		}
+	}
+	return connected, nil
 }
@@ -6,17 +6,30 @@ Connect to Windows with WinRM and grab information by running PowerShell command
 Prequisites: refer to https://github.com/masterzen/winrm
 	winrm quickconfig
 	y
-	winrm set winrm/config/service/Auth '@{Basic="true"}'
-	winrm set winrm/config/service '@{AllowUnencrypted="true"}'
+	winrm quickconfig -transport:https
 	winrm set winrm/config/winrs '@{MaxMemoryPerShellMB="1024"}'
+
+Options:
+	- scheme: http or https, https by default unless the port is 5985;
+	- allow_unencrypted: true to allow http, messages and basic auth credentials are sent in clear text otherwise;
+	- auth: basic, ntlm (negotiate, domain accounts work as DOMAIN\user) or cert (client certificate mapped to
+	  a local account, key is the private key and cert_file is the certificate, https only), basic by default;
+	- insecure and ca_file: skip TLS verification or verify the server with a PEM CA bundle, verified by default;
+	- timeout: timeout(seconds) of each WinRM operation, 60 by default.
+HTTPS (5986) is used by default, HTTP requires AllowUnencrypted on the server and allow_unencrypted in the options.
 */
 
 import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kckecheng/osprobe/probe"
 	"github.com/masterzen/winrm"
@@ -27,27 +40,157 @@ import (
 type Server struct {
 	probe.Server
 	client *winrm.Client
+	conns  *conns
 }
 
-// NewServer init a Windows connection
-func NewServer(host, user, password string, port int) (Server, error) {
-	server := Server{
-		Server: probe.Server{
-			Host:     host,
-			User:     user,
-			Password: password,
-			Port:     port,
-			Type:     "esxi",
+// conns connections opened by the WinRM transport, which does not expose them, they are closed with the probe
+type conns struct {
+	mutex sync.Mutex
+	list  []net.Conn
+}
+
+func (c *conns) dial(network, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	c.list = append(c.list, conn)
+	c.mutex.Unlock()
+	return conn, nil
+}
+
+func (c *conns) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, conn := range c.list {
+		conn.Close()
+	}
+	c.list = nil
+}
+
+func init() {
+	probe.Register(probe.Backend{
+		Type: "windows",
+		New:  newProbe,
+		Port: 5986,
+		// Client certificates are mapped to accounts by Windows, other auth needs a user
+		UserOptional: true,
+		UserOptionalFor: func(s probe.Server) bool {
+			return s.Option("auth", "basic") == "cert"
+		},
+		Metrics: []string{"cpu", "mem", "nic"},
+		Hints: probe.Hints{
+			Banners: map[string]float64{
+				"for_Windows": 0.7,
+			},
+			// RDP with WinRM HTTP/HTTPS or Windows sharing
+			Ports:          [][]int{{3389}, {5985, 5986, 445}},
+			PortConfidence: 0.4,
 		},
+	})
+}
+
+func newProbe(server probe.Server) (probe.Probe, error) {
+	p, err := Dial(server)
+	if err != nil {
+		return nil, err
 	}
+	return p, nil
+}
+
+// NewServer init a Windows connection with basic auth over HTTPS
+func NewServer(host, user, password string, port int) (Server, error) {
+	return Dial(probe.Server{
+		Host:     host,
+		User:     user,
+		Password: password,
+		Port:     port,
+		Type:     "windows",
+	})
+}
+
+// Dial init a Windows connection with the scheme and auth selected by the options of the server
+func Dial(s probe.Server) (Server, error) {
+	server := Server{Server: s, conns: &conns{}}
+	server.Type = "windows"
 	if !server.Valid() {
 		return server, errors.New("Inputs are not valid, please check")
 	}
 
-	endpoint := winrm.NewEndpoint(host, port, false, false, nil, nil, nil, 0)
-	client, err := winrm.NewClient(endpoint, user, password)
+	defScheme := "https"
+	if s.Port == 5985 {
+		defScheme = "http"
+	}
+	scheme := s.Option("scheme", defScheme)
+	if scheme != "http" && scheme != "https" {
+		return server, fmt.Errorf("Invalid scheme option %s", scheme)
+	}
+	unencrypted, err := strconv.ParseBool(s.Option("allow_unencrypted", "false"))
+	if err != nil {
+		return server, fmt.Errorf("Invalid allow_unencrypted option %s", s.Option("allow_unencrypted", ""))
+	}
+	// Neither basic auth nor NTLM of the WinRM client encrypts messages, credentials would be sent in clear text
+	if scheme == "http" && !unencrypted {
+		return server, errors.New("WinRM over http is not encrypted, please use https (5986) or set the allow_unencrypted option")
+	}
+	insecure, err := strconv.ParseBool(s.Option("insecure", "false"))
 	if err != nil {
-		log.Errorf("Fail to create client for %+v due to %s", server.Server, err)
+		return server, fmt.Errorf("Invalid insecure option %s", s.Option("insecure", ""))
+	}
+	timeout, err := strconv.Atoi(s.Option("timeout", "60"))
+	if err != nil || timeout <= 0 {
+		return server, fmt.Errorf("Invalid timeout option %s", s.Option("timeout", ""))
+	}
+
+	var ca, cert, key []byte
+	if path := s.Option("ca_file", ""); path != "" {
+		ca, err = ioutil.ReadFile(path)
+		if err != nil {
+			return server, fmt.Errorf("Fail to read CA file %s: %w", path, err)
+		}
+		insecure = false
+	}
+
+	// The HTTP timeout is a bit longer than the operation timeout so that the WinRM fault is received
+	params := winrm.NewParameters(fmt.Sprintf("PT%dS", timeout), "en-US", 153600)
+	params.Dial = server.conns.dial
+	switch auth := s.Option("auth", "basic"); auth {
+	case "basic", "ntlm", "negotiate":
+		if s.User == "" || s.Password == "" {
+			return server, fmt.Errorf("User and password are required by %s auth", auth)
+		}
+		if auth != "basic" {
+			params.TransportDecorator = func() winrm.Transporter { return winrm.NewClientNTLMWithDial(server.conns.dial) }
+		}
+	case "cert":
+		if scheme != "https" {
+			return server, errors.New("Client certificate auth requires https")
+		}
+		path := s.Option("cert_file", "")
+		if path == "" || s.Key == "" {
+			return server, errors.New("Key and cert_file option are required by cert auth")
+		}
+		cert, err = ioutil.ReadFile(path)
+		if err != nil {
+			return server, fmt.Errorf("Fail to read certificate %s: %w", path, err)
+		}
+		key = []byte(s.Key)
+		if !strings.Contains(s.Key, "PRIVATE KEY") {
+			key, err = ioutil.ReadFile(s.Key)
+			if err != nil {
+				return server, fmt.Errorf("Fail to read private key %s: %w", s.Key, err)
+			}
+		}
+		params.TransportDecorator = func() winrm.Transporter { return winrm.NewClientAuthRequestWithDial(server.conns.dial) }
+	default:
+		return server, fmt.Errorf("Invalid auth option %s, valid options are basic, ntlm, negotiate and cert", auth)
+	}
+
+	endpoint := winrm.NewEndpoint(s.Host, s.Port, scheme == "https", insecure, ca, cert, key, time.Duration(timeout+10)*time.Second)
+	client, err := winrm.NewClientWithParameters(endpoint, s.User, s.Password, params)
+	if err != nil {
+		log.Errorf("Fail to create client for %s due to %s", server.Server, err)
 		return server, err
 	}
 
@@ -72,6 +215,10 @@ func (win Server) GetCPUUsage() (float64, error) {
 		return ret, err
 	}
 
+	if len(cpus) == 0 {
+		return ret, fmt.Errorf("%w: no processor found", probe.ErrParse)
+	}
+
 	var total float64
 	for _, cpu := range cpus {
 		total += cpu.Load
@@ -96,6 +243,9 @@ func (win Server) GetMemUsage() (float64, error) {
 	if err != nil {
 		return ret, err
 	}
+	if len(mems) == 0 || mems[0].Total == 0 {
+		return ret, fmt.Errorf("%w: no memory information found", probe.ErrParse)
+	}
 	return (1 - mems[0].Free/mems[0].Total) * 100, nil
 }
 
@@ -151,6 +301,14 @@ func (win Server) GetNICUsage() (map[string]map[string]float64, error) {
 	return ret, nil
 }
 
+// Close implement interface, connections opened by WinRM are closed
+func (win Server) Close() error {
+	if win.conns != nil {
+		win.conns.close()
+	}
+	return nil
+}
+
 func (win Server) extractStats(cmd string, stats interface{}) error {
 	output, err := win.runCmd(cmd)
 	if err != nil {
@@ -166,7 +324,7 @@ func (win Server) extractStats(cmd string, stats interface{}) error {
 	err = json.Unmarshal([]byte(output), stats)
 	if err != nil {
 		log.Errorf("Fail to extract stats %s with error %s", output, err)
-		return err
+		return fmt.Errorf("%w: %s", probe.ErrParse, err)
 	}
 	return nil
 }
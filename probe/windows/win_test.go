@@ -0,0 +1,31 @@
+package windows
+
+import (
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		options map[string]string
+		want    bool
+	}{
+		{name: "basic auth", user: "Administrator", want: true},
+		{name: "basic auth without a user"},
+		{name: "NTLM without a user", options: map[string]string{"auth": "ntlm"}},
+		{name: "negotiate without a user", options: map[string]string{"auth": "negotiate"}},
+		{name: "client certificate without a user", options: map[string]string{"auth": "cert"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := probe.Server{Host: "win1", User: tt.user, Key: "client.key", Password: "secret", Port: 5986, Type: "windows", Options: tt.options}
+			if got := s.Valid(); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kckecheng/osprobe/report"
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+)
+
+// runReport rank servers by sustained idleness, the return value is used as the exit code
+func runReport(args []string) int {
+	var history, prom, format string
+	var days int
+	var c report.Criteria
+	var all bool
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.StringVar(&history, "history", "", "Local history file recorded by osprobe --history")
+	fs.StringVar(&prom, "prometheus", "", "Prometheus URL to query the history instead of the local file, e.g., http://127.0.0.1:9090")
+	fs.IntVarP(&days, "days", "d", 14, "Window(days) over which the servers should stay idle")
+	fs.Float64VarP(&c.Quantile, "quantile", "q", 0.95, "Quantile of utilization compared with the thresholds")
+	fs.Float64Var(&c.CPU, "cpu", 5, "CPU utilization threshold(percent)")
+	fs.Float64Var(&c.Mem, "mem", 0, "Memory utilization threshold(percent), 0 means not checked")
+	fs.Float64Var(&c.NIC, "nic", 0, "NIC throughput threshold(bytes/s), 0 means not checked")
+	fs.BoolVarP(&all, "all", "a", false, "List all servers instead of reclaimable ones only")
+	fs.StringVarP(&format, "format", "f", "table", "Report format: table or json")
+	fs.Parse(args)
+
+	if (history == "") == (prom == "") || days <= 0 || c.Quantile <= 0 || c.Quantile > 1 || (format != "table" && format != "json") {
+		fmt.Fprintln(os.Stderr, "Usage: osprobe report --history <file> | --prometheus <url> [options]")
+		fs.PrintDefaults()
+		return 1
+	}
+	c.Window = time.Duration(days) * 24 * time.Hour
+
+	var hosts []report.Host
+	if prom != "" {
+		var err error
+		hosts, err = report.FromPrometheus(prom, c)
+		if err != nil {
+			log.Errorf("Fail to query Prometheus %s due to %s", prom, err)
+			return 1
+		}
+	} else {
+		samples, err := report.LoadHistory(history, time.Now().Add(-c.Window))
+		if err != nil {
+			log.Errorf("Fail to load history %s due to %s", history, err)
+			return 1
+		}
+		hosts = report.FromSamples(samples, c)
+	}
+	report.Rank(hosts)
+
+	if !all {
+		var reclaimable []report.Host
+		for _, h := range hosts {
+			if h.Reclaimable {
+				reclaimable = append(reclaimable, h)
+			}
+		}
+		hosts = reclaimable
+	}
+
+	var err error
+	if format == "json" {
+		err = writeJSONRanking(os.Stdout, hosts)
+	} else {
+		err = writeTableRanking(os.Stdout, hosts)
+	}
+	if err != nil {
+		log.Errorf("Fail to write the report due to %s", err)
+		return 1
+	}
+	return 0
+}
+
+func writeJSONRanking(w io.Writer, hosts []report.Host) error {
+	if hosts == nil {
+		hosts = []report.Host{}
+	}
+	bytes, err := json.MarshalIndent(hosts, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(bytes))
+	return err
+}
+
+func writeTableRanking(w io.Writer, hosts []report.Host) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tHOST\tTYPE\tOWNER\tTEAM\tCPU(%)\tMEM(%)\tNIC(B/s)\tCONTAINER STARTED\tSAMPLES\tRECLAIMABLE")
+	for i, h := range hosts {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%t\n",
+			i+1,
+			h.Host,
+			h.Type,
+			h.Owner(),
+			h.Labels["team"],
+			formatKnown(h.CPU),
+			formatKnown(h.Mem),
+			formatKnown(h.NIC),
+			formatTime(h.ContainerStarted),
+			h.Samples,
+			h.Reclaimable,
+		)
+	}
+	return tw.Flush()
+}
+
+// formatTime print a point of time, - is used for nil (not known)
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// formatKnown print a computed value, - is used for -1 (not known)
+func formatKnown(v float64) string {
+	if v < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", v)
+}
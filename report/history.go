@@ -0,0 +1,122 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kckecheng/osprobe/collector"
+	log "github.com/sirupsen/logrus"
+)
+
+// Sample values of a server recorded in the local history
+type Sample struct {
+	Host      string             `json:"host"`
+	Type      string             `json:"type"`
+	Labels    map[string]string  `json:"labels,omitempty"`
+	Timestamp time.Time          `json:"timestamp"`
+	Values    map[string]float64 `json:"values"`
+}
+
+// AppendHistory append probe results to a history file, one json sample per line
+func AppendHistory(path string, results []collector.Result) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, r := range results {
+		// Servers never accessible carry no value for idleness
+		if len(r.Values) == 0 {
+			continue
+		}
+
+		sample := Sample{
+			Host:      r.Host,
+			Type:      r.Type,
+			Labels:    r.Labels,
+			Timestamp: r.Timestamp,
+			Values:    map[string]float64{},
+		}
+		for k, v := range r.Values {
+			sample.Values[k] = v.Value
+		}
+		// Backend specific metrics without labels are values of the server, e.g., container_last_started_timestamp
+		for _, m := range r.Extra {
+			if len(m.Labels) == 0 {
+				sample.Values[m.Name] = m.Value
+			}
+		}
+		if err := enc.Encode(sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadHistory load samples recorded since a point of time, malformed lines (e.g., truncated by a crash) are skipped
+func LoadHistory(path string, since time.Time) ([]Sample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []Sample
+	var skipped int
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var sample Sample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			skipped++
+			continue
+		}
+		if sample.Timestamp.Before(since) {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	if skipped > 0 {
+		log.Warnf("%d malformed lines of history %s are skipped", skipped, path)
+	}
+	return samples, scanner.Err()
+}
+
+// PruneHistory drop samples recorded before a point of time to keep the history rolling
+func PruneHistory(path string, before time.Time) error {
+	samples, err := LoadHistory(path, before)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	enc := json.NewEncoder(tmp)
+	for _, sample := range samples {
+		if err := enc.Encode(sample); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
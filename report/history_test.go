@@ -0,0 +1,37 @@
+package report
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	history := `{"host": "web1", "type": "linux", "timestamp": "2021-01-01T00:00:00Z", "values": {"cpu_utilization": 1}}
+{"host": "web1", "type": "linux", "timestamp": "2021-01-02T00:00:00Z", "values": {"cpu_utilization": 2}}
+not json
+
+{"host": "web2", "type": "linux", "timestamp": "2021-01-03T00:00:00Z", "values": {"cpu_utilization": 3}}
+{"host": "web2", "type": "linux", "timestamp": "2021-01-04T00:00:00Z", "val`
+	if err := ioutil.WriteFile(path, []byte(history), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	samples, err := LoadHistory(path, time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []float64
+	for _, s := range samples {
+		got = append(got, s.Values["cpu_utilization"])
+	}
+	if len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Errorf("got samples with CPU utilization %v, want [2 3]", got)
+	}
+
+	if _, err := LoadHistory(filepath.Join(t.TempDir(), "missing"), time.Time{}); err == nil {
+		t.Error("a missing history is loaded")
+	}
+}
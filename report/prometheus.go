@@ -0,0 +1,137 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kckecheng/osprobe/collector"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// ignoredLabels labels added by Prometheus and Pushgateway which are not user defined
+var ignoredLabels = map[model.LabelName]bool{
+	model.MetricNameLabel: true,
+	model.JobLabel:        true,
+	model.InstanceLabel:   true,
+	"host":                true,
+	"type":                true,
+	"exported_job":        true,
+	"exported_instance":   true,
+}
+
+// FromPrometheus compute idleness statistics with the Prometheus HTTP query API
+func FromPrometheus(address string, c Criteria) ([]Host, error) {
+	client, err := api.NewClient(api.Config{Address: address})
+	if err != nil {
+		return nil, err
+	}
+	papi := v1.NewAPI(client)
+	now := time.Now()
+	window := model.Duration(c.Window).String()
+
+	queries := map[string]string{
+		"cpu":     fmt.Sprintf("max by (host, type) (quantile_over_time(%g, cpu_utilization[%s]))", c.Quantile, window),
+		"mem":     fmt.Sprintf("max by (host, type) (quantile_over_time(%g, mem_utilization[%s]))", c.Quantile, window),
+		"nic":     fmt.Sprintf("max by (host, type) (rate(nic_received_bytes_total[%s]) + rate(nic_sent_bytes_total[%s]))", window, window),
+		"samples": fmt.Sprintf("max by (host, type) (count_over_time(cpu_utilization[%s]))", window),
+		"first":   fmt.Sprintf("min by (host, type) (min_over_time(probe_timestamp[%s]))", window),
+		"last":    fmt.Sprintf("max by (host, type) (max_over_time(probe_timestamp[%s]))", window),
+		// Last container start before the window is not known, Prometheus keeps the latest value anyway
+		"containers": fmt.Sprintf("max by (host, type) (max_over_time(container_last_started_timestamp[%s]))", window),
+	}
+
+	hosts := map[string]*Host{}
+	var order []string
+	get := func(metric model.Metric) *Host {
+		key := collector.Key(string(metric["host"]), string(metric["type"]))
+		h, ok := hosts[key]
+		if !ok {
+			h = &Host{Host: string(metric["host"]), Type: string(metric["type"]), CPU: -1, Mem: -1, NIC: -1}
+			hosts[key] = h
+			order = append(order, key)
+		}
+		return h
+	}
+
+	for _, key := range []string{"cpu", "mem", "nic", "samples", "first", "last", "containers"} {
+		vector, err := query(papi, queries[key], now)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sample := range vector {
+			h := get(sample.Metric)
+			v := float64(sample.Value)
+			switch key {
+			case "cpu":
+				h.CPU = v
+			case "mem":
+				h.Mem = v
+			case "nic":
+				h.NIC = v
+			case "samples":
+				h.Samples = int(v)
+			case "first":
+				h.First = time.Unix(int64(v), 0)
+			case "last":
+				h.Last = time.Unix(int64(v), 0)
+			case "containers":
+				t := time.Unix(int64(v), 0)
+				h.ContainerStarted = &t
+			}
+		}
+	}
+
+	// Owners and other user defined labels
+	vector, err := query(papi, "server_info", now)
+	if err != nil {
+		return nil, err
+	}
+	for _, sample := range vector {
+		h, ok := hosts[collector.Key(string(sample.Metric["host"]), string(sample.Metric["type"]))]
+		if !ok {
+			continue
+		}
+		h.Labels = map[string]string{}
+		for k, v := range sample.Metric {
+			if ignoredLabels[k] || v == "" {
+				continue
+			}
+			h.Labels[string(k)] = string(v)
+		}
+	}
+
+	var ret []Host
+	for _, key := range order {
+		h := hosts[key]
+		h.evaluate(c)
+		ret = append(ret, *h)
+	}
+	return ret, nil
+}
+
+func query(papi v1.API, q string, ts time.Time) (model.Vector, error) {
+	log.Debugf("Query Prometheus: %s", q)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	value, warnings, err := papi.Query(ctx, q, ts)
+	if err != nil {
+		log.Errorf("Fail to query %s due to %s", q, err)
+		return nil, err
+	}
+	for _, w := range warnings {
+		log.Warnf("Query %s warning: %s", q, w)
+	}
+
+	vector, ok := value.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected result type %s for query %s", value.Type(), q)
+	}
+	return vector, nil
+}
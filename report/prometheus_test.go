@@ -0,0 +1,83 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// vector a query result of the Prometheus HTTP API
+func vector(series ...string) string {
+	return fmt.Sprintf(`{"status": "success", "data": {"resultType": "vector", "result": [%s]}}`, strings.Join(series, ","))
+}
+
+func series(labels string, v float64) string {
+	return fmt.Sprintf(`{"metric": {%s}, "value": [1610668800, "%g"]}`, labels, v)
+}
+
+func TestFromPrometheus(t *testing.T) {
+	web1 := `"host": "web1", "type": "linux"`
+	web2 := `"host": "web2", "type": "linux"`
+	last := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	responses := map[string]string{
+		"quantile_over_time(0.95, cpu_utilization[2w])":       vector(series(web1, 5), series(web2, 50)),
+		"quantile_over_time(0.95, mem_utilization[2w])":       vector(series(web1, 20)),
+		"rate(nic_received_bytes_total[2w])":                  vector(series(web1, 100)),
+		"count_over_time(cpu_utilization[2w])":                vector(series(web1, 4032), series(web2, 4032)),
+		"min_over_time(probe_timestamp[2w])":                  vector(series(web1, float64(last.Add(-14*24*time.Hour).Unix())), series(web2, float64(last.Add(-time.Hour).Unix()))),
+		"max_over_time(probe_timestamp[2w])":                  vector(series(web1, float64(last.Unix())), series(web2, float64(last.Unix()))),
+		"max_over_time(container_last_started_timestamp[2w])": vector(),
+		"server_info": vector(
+			series(web1+`, "owner": "alice", "job": "osprobe", "instance": "gw:9091", "rack": ""`, 1),
+			series(`"host": "db1", "type": "linux", "owner": "bob"`, 1),
+		),
+	}
+
+	var queries []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			http.NotFound(w, r)
+			return
+		}
+		r.ParseForm()
+		q := r.Form.Get("query")
+		queries = append(queries, q)
+		// Each query is matched by the part telling its metric, function and window
+		for k, body := range responses {
+			if strings.Contains(q, k) {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, body)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"status": "error", "errorType": "bad_data", "error": "unexpected query %s"}`, q)
+	}))
+	defer ts.Close()
+
+	hosts, err := FromPrometheus(ts.URL, Criteria{Window: 14 * 24 * time.Hour, Quantile: 0.95, CPU: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != len(responses) {
+		t.Errorf("got queries %v", queries)
+	}
+
+	got, _ := json.Marshal(hosts)
+	want := []Host{
+		{Host: "web1", Type: "linux", Labels: map[string]string{"owner": "alice"}, Samples: 4032, First: last.Add(-14 * 24 * time.Hour).Local(), Last: last.Local(), CPU: 5, Mem: 20, NIC: 100, Score: 95, Reclaimable: true},
+		{Host: "web2", Type: "linux", Samples: 4032, First: last.Add(-time.Hour).Local(), Last: last.Local(), CPU: 50, Mem: -1, NIC: -1, Score: 50},
+	}
+	wanted, _ := json.Marshal(want)
+	if string(got) != string(wanted) {
+		t.Errorf("got\n%s\nwant\n%s", got, wanted)
+	}
+
+	if _, err := FromPrometheus(ts.URL, Criteria{Window: time.Hour, Quantile: 0.95, CPU: 10}); err == nil {
+		t.Error("a failed query is not reported")
+	}
+}
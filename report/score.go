@@ -0,0 +1,175 @@
+package report
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/kckecheng/osprobe/collector"
+)
+
+// Criteria what sustained idleness means
+type Criteria struct {
+	Window   time.Duration // how long the server should stay idle
+	Quantile float64       // quantile of utilization compared with the thresholds, e.g., 0.95
+	CPU      float64       // CPU utilization threshold in percent
+	Mem      float64       // memory utilization threshold in percent, 0 means not checked
+	NIC      float64       // NIC throughput threshold in bytes/s, 0 means not checked
+}
+
+// Host idleness statistics of a server over the report window
+type Host struct {
+	Host        string            `json:"host"`
+	Type        string            `json:"type"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Samples     int               `json:"samples"`
+	First       time.Time         `json:"first"`
+	Last        time.Time         `json:"last"`
+	CPU         float64           `json:"cpu"` // -1 if not known
+	Mem         float64           `json:"mem"` // -1 if not known
+	NIC         float64           `json:"nic"` // average bytes/s, -1 if not known
+	Score       float64           `json:"score"`
+	Reclaimable bool              `json:"reclaimable"`
+
+	// ContainerStarted last time any container was started, nil if containers are not listed
+	ContainerStarted *time.Time `json:"container_started,omitempty"`
+}
+
+// Owner of the server based on the owner label
+func (h Host) Owner() string {
+	return h.Labels["owner"]
+}
+
+// minCoverage part of the window which should be covered by samples before a server is judged
+const minCoverage = 0.9
+
+// evaluate score a server and decide if it is reclaimable
+func (h *Host) evaluate(c Criteria) {
+	// Higher score means more idle
+	h.Score = 0
+	if h.CPU >= 0 {
+		h.Score = 100 - h.CPU
+	}
+
+	covered := h.Last.Sub(h.First) >= time.Duration(float64(c.Window)*minCoverage)
+	h.Reclaimable = covered && h.Samples > 0 && h.CPU >= 0 && h.CPU < c.CPU
+	if c.Mem > 0 && (h.Mem < 0 || h.Mem >= c.Mem) {
+		h.Reclaimable = false
+	}
+	if c.NIC > 0 && (h.NIC < 0 || h.NIC >= c.NIC) {
+		h.Reclaimable = false
+	}
+	// Hosts reserved to run a few containers are in use as long as containers are started within the window
+	if h.ContainerStarted != nil && h.Last.Sub(*h.ContainerStarted) < c.Window {
+		h.Reclaimable = false
+	}
+}
+
+// Rank sort servers with the reclaimable and the most idle ones first
+func Rank(hosts []Host) {
+	sort.SliceStable(hosts, func(i, j int) bool {
+		if hosts[i].Reclaimable != hosts[j].Reclaimable {
+			return hosts[i].Reclaimable
+		}
+		if hosts[i].Score != hosts[j].Score {
+			return hosts[i].Score > hosts[j].Score
+		}
+		return hosts[i].Mem < hosts[j].Mem
+	})
+}
+
+// FromSamples compute idleness statistics based on the local history, a host probed as several types is
+// evaluated once per type
+func FromSamples(samples []Sample, c Criteria) []Host {
+	grouped := map[string][]Sample{}
+	var order []string
+	for _, s := range samples {
+		key := collector.Key(s.Host, s.Type)
+		if _, ok := grouped[key]; !ok {
+			order = append(order, key)
+		}
+		grouped[key] = append(grouped[key], s)
+	}
+
+	var hosts []Host
+	for _, key := range order {
+		ss := grouped[key]
+		sort.Slice(ss, func(i, j int) bool { return ss[i].Timestamp.Before(ss[j].Timestamp) })
+
+		latest := ss[len(ss)-1]
+		h := Host{
+			Host:   latest.Host,
+			Type:   latest.Type,
+			Labels: latest.Labels,
+			First:  ss[0].Timestamp,
+			Last:   latest.Timestamp,
+		}
+
+		var cpus, mems []float64
+		for _, s := range ss {
+			if v, ok := s.Values["cpu_utilization"]; ok {
+				cpus = append(cpus, v)
+			}
+			if v, ok := s.Values["mem_utilization"]; ok {
+				mems = append(mems, v)
+			}
+		}
+		h.Samples = len(cpus)
+		h.CPU = orUnknown(collector.Quantile(cpus, c.Quantile))
+		h.Mem = orUnknown(collector.Quantile(mems, c.Quantile))
+		h.NIC = nicThroughput(ss)
+		h.ContainerStarted = containerStarted(ss)
+		h.evaluate(c)
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+// nicThroughput average bytes/s between samples, counter resets caused by reboot are skipped
+func nicThroughput(ss []Sample) float64 {
+	var bytes, seconds float64
+	var prev *Sample
+	for i := range ss {
+		s := &ss[i]
+		if _, ok := s.Values["nic_received_bytes"]; !ok {
+			continue
+		}
+		if prev != nil {
+			delta := s.Values["nic_received_bytes"] + s.Values["nic_sent_bytes"] -
+				prev.Values["nic_received_bytes"] - prev.Values["nic_sent_bytes"]
+			if delta >= 0 {
+				bytes += delta
+				seconds += s.Timestamp.Sub(prev.Timestamp).Seconds()
+			}
+		}
+		prev = s
+	}
+
+	if seconds == 0 {
+		return -1
+	}
+	return bytes / seconds
+}
+
+// containerStarted the latest container_last_started_timestamp, containers may have been removed since
+func containerStarted(ss []Sample) *time.Time {
+	var last float64
+	for _, s := range ss {
+		if v, ok := s.Values["container_last_started_timestamp"]; ok && v > last {
+			last = v
+		}
+	}
+	if last == 0 {
+		return nil
+	}
+	t := time.Unix(int64(last), 0)
+	return &t
+}
+
+// orUnknown use -1 for values which cannot be computed
+func orUnknown(v float64) float64 {
+	if math.IsNaN(v) {
+		return -1
+	}
+	return v
+}
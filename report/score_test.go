@@ -0,0 +1,56 @@
+package report
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluate(t *testing.T) {
+	window := 14 * 24 * time.Hour
+	last := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := last.Add(-d)
+		return &t
+	}
+	idle := func(modify func(h *Host)) Host {
+		h := Host{Host: "web1", Samples: 100, First: last.Add(-window), Last: last, CPU: 5, Mem: 20, NIC: 100}
+		if modify != nil {
+			modify(&h)
+		}
+		return h
+	}
+
+	tests := []struct {
+		name        string
+		host        Host
+		mem         float64
+		nic         float64
+		score       float64
+		reclaimable bool
+	}{
+		{name: "idle", host: idle(nil), score: 95, reclaimable: true},
+		{name: "busy CPU", host: idle(func(h *Host) { h.CPU = 10 }), score: 90},
+		{name: "unknown CPU", host: idle(func(h *Host) { h.CPU = -1 }), score: 0},
+		{name: "no sample", host: idle(func(h *Host) { h.Samples = 0 }), score: 95},
+		{name: "window covered at 90%", host: idle(func(h *Host) { h.First = last.Add(-window * 9 / 10) }), score: 95, reclaimable: true},
+		{name: "window covered below 90%", host: idle(func(h *Host) { h.First = last.Add(-window*9/10 + time.Second) }), score: 95},
+		{name: "memory below the threshold", host: idle(nil), mem: 30, score: 95, reclaimable: true},
+		{name: "memory above the threshold", host: idle(func(h *Host) { h.Mem = 30 }), mem: 30, score: 95},
+		{name: "unknown memory with a threshold", host: idle(func(h *Host) { h.Mem = -1 }), mem: 30, score: 95},
+		{name: "unknown memory without a threshold", host: idle(func(h *Host) { h.Mem = -1 }), score: 95, reclaimable: true},
+		{name: "NIC above the threshold", host: idle(nil), nic: 100, score: 95},
+		{name: "unknown NIC with a threshold", host: idle(func(h *Host) { h.NIC = -1 }), nic: 1000, score: 95},
+		{name: "container started within the window", host: idle(func(h *Host) { h.ContainerStarted = at(window - time.Second) }), score: 95},
+		{name: "container started before the window", host: idle(func(h *Host) { h.ContainerStarted = at(window) }), score: 95, reclaimable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.host
+			h.evaluate(Criteria{Window: window, Quantile: 0.95, CPU: 10, Mem: tt.mem, NIC: tt.nic})
+			if h.Score != tt.score || h.Reclaimable != tt.reclaimable {
+				t.Errorf("got score %v and reclaimable %v, want %v and %v", h.Score, h.Reclaimable, tt.score, tt.reclaimable)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kckecheng/osprobe/probe"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// guess the OS type of a host with a confidence between 0 and 1
+type guess struct {
+	Type       string
+	Confidence float64
+	Evidence   string
+	// Unreachable none of the detection ports is open
+	Unreachable bool
+	// Port port of the protocol which tells the type, 0 if the default port of the type is used
+	Port int
+}
+
+var unknownGuess = guess{Type: "unknown", Confidence: 0, Evidence: "no protocol is recognized"}
+
+// fingerprintVSphere retrieve ServiceContent.About from the vSphere /sdk endpoint, no login is required
+func fingerprintVSphere(host string, port int) (guess, bool) {
+	u := &url.URL{
+		Scheme: "https",
+		Host:   fmt.Sprintf("%s:%d", host, port),
+		Path:   "/sdk",
+	}
+	sc := soap.NewClient(u, true)
+	sc.Timeout = dialTimeout
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	c, err := vim25.NewClient(ctx, sc)
+	if err != nil {
+		return guess{}, false
+	}
+
+	return classifyVSphere(c.ServiceContent.About)
+}
+
+// classifyVSphere tell ESXi from vCenter by the product line, or by the API type for other implementations
+func classifyVSphere(about types.AboutInfo) (guess, bool) {
+	evidence := fmt.Sprintf("vSphere API: %s (productLineId %s)", about.FullName, about.ProductLineId)
+	switch about.ProductLineId {
+	case "embeddedEsx", "esx":
+		return guess{Type: "esxi", Confidence: 0.99, Evidence: evidence}, true
+	case "vpx":
+		return guess{Type: "vcenter", Confidence: 0.99, Evidence: evidence}, true
+	}
+
+	// Other vSphere API implementations
+	switch about.ApiType {
+	case "HostAgent":
+		return guess{Type: "esxi", Confidence: 0.8, Evidence: evidence}, true
+	case "VirtualCenter":
+		return guess{Type: "vcenter", Confidence: 0.8, Evidence: evidence}, true
+	}
+	return guess{}, false
+}
+
+const winrmIdentify = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:wsmid="http://schemas.dmtf.org/wbem/wsman/identity/1/wsmanidentity.xsd">
+<s:Header/>
+<s:Body><wsmid:Identify/></s:Body>
+</s:Envelope>`
+
+var winrmVendorRegex = regexp.MustCompile(`<(?:\w+:)?ProductVendor>([^<]*)</`)
+
+// fingerprintWinRM send an unauthenticated WS-Management identify request
+func fingerprintWinRM(host string, port int, https bool) (guess, bool) {
+	scheme := "http"
+	if https {
+		scheme = "https"
+	}
+
+	client := http.Client{
+		Timeout: dialTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s://%s:%d/wsman", scheme, host, port), bytes.NewBufferString(winrmIdentify))
+	if err != nil {
+		return guess{}, false
+	}
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	req.Header.Set("WSMANIDENTIFY", "unauthenticated")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return guess{}, false
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return guess{}, false
+	}
+
+	m := winrmVendorRegex.FindSubmatch(body)
+	if m == nil {
+		// WinRM answers but identify is not allowed
+		if resp.StatusCode == http.StatusUnauthorized && strings.Contains(resp.Header.Get("Server"), "Microsoft-HTTPAPI") {
+			return guess{Type: "windows", Confidence: 0.8, Evidence: "WinRM: Microsoft-HTTPAPI", Port: port}, true
+		}
+		return guess{}, false
+	}
+
+	vendor := string(m[1])
+	evidence := fmt.Sprintf("WinRM identify: %s", vendor)
+	if strings.Contains(vendor, "Microsoft") {
+		return guess{Type: "windows", Confidence: 0.95, Evidence: evidence, Port: port}, true
+	}
+	// e.g. OMI on Linux
+	return guess{Type: "linux", Confidence: 0.5, Evidence: evidence}, true
+}
+
+// fingerprintSSH read the SSH protocol banner, e.g. SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1
+func fingerprintSSH(host string, port int) (guess, bool) {
+	con, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", host, port), dialTimeout)
+	if err != nil {
+		return guess{}, false
+	}
+	defer con.Close()
+
+	con.SetReadDeadline(time.Now().Add(dialTimeout))
+	banner, err := bufio.NewReader(con).ReadString('\n')
+	if err != nil || !strings.HasPrefix(banner, "SSH-") {
+		return guess{}, false
+	}
+	banner = strings.TrimSpace(banner)
+	return classifyBanner(banner), true
+}
+
+// classifyBanner match banner hints of registered backends, the most confident one wins
+func classifyBanner(banner string) guess {
+	evidence := fmt.Sprintf("SSH banner: %s", banner)
+	best := guess{Type: "unknown", Confidence: 0, Evidence: evidence}
+	for _, b := range probe.Backends() {
+		for keyword, confidence := range b.Hints.Banners {
+			if strings.Contains(banner, keyword) && confidence > best.Confidence {
+				best = guess{Type: b.Type, Confidence: confidence, Evidence: evidence}
+			}
+		}
+	}
+	return best
+}
+
+// protocolPorts ports used by protocol fingerprints
+var protocolPorts = []int{22, 443, 5985, 5986}
+
+// detectPorts ports used by protocol fingerprints and port hints of registered backends
+func detectPorts() []int {
+	seen := map[int]bool{}
+	var ret []int
+	add := func(port int) {
+		if !seen[port] {
+			seen[port] = true
+			ret = append(ret, port)
+		}
+	}
+	for _, port := range protocolPorts {
+		add(port)
+	}
+	for _, b := range probe.Backends() {
+		for _, group := range b.Hints.Ports {
+			for _, port := range group {
+				add(port)
+			}
+		}
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// scanPorts check ports concurrently instead of dialing them one by one
+func scanPorts(host string, ports []int) map[int]bool {
+	ret := map[int]bool{}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, port := range ports {
+		wg.Add(1)
+		go func(port int) {
+			defer wg.Done()
+			open := scanTPort(host, port)
+			mutex.Lock()
+			ret[port] = open
+			mutex.Unlock()
+		}(port)
+	}
+	wg.Wait()
+	return ret
+}
+
+// guessByPorts fall back to port hints of registered backends when no protocol can be recognized,
+// e.g., 443 and 902/903 for ESXi, 3389 and 5985/5986/445 for Windows, 22 for Linux
+func guessByPorts(open map[int]bool) guess {
+	best := unknownGuess
+	for _, b := range probe.Backends() {
+		if len(b.Hints.Ports) == 0 || b.Hints.PortConfidence <= best.Confidence {
+			continue
+		}
+
+		var matched []string
+		for _, group := range b.Hints.Ports {
+			var ports []string
+			for _, port := range group {
+				if open[port] {
+					ports = append(ports, strconv.Itoa(port))
+				}
+			}
+			if len(ports) == 0 {
+				matched = nil
+				break
+			}
+			matched = append(matched, strings.Join(ports, "/"))
+		}
+		if matched != nil {
+			evidence := fmt.Sprintf("ports %s are open", strings.Join(matched, " and "))
+			if len(matched) == 1 && !strings.Contains(matched[0], "/") {
+				evidence = fmt.Sprintf("port %s is open", matched[0])
+			}
+			best = guess{Type: b.Type, Confidence: b.Hints.PortConfidence, Evidence: evidence}
+		}
+	}
+	return best
+}
+
+// fingerprint detect the OS type with protocol exchanges, the most confident guess wins
+func fingerprint(host string) guess {
+	open := scanPorts(host, detectPorts())
+	reachable := false
+	for _, ok := range open {
+		reachable = reachable || ok
+	}
+	if !reachable {
+		return guess{Type: "unknown", Confidence: 0, Evidence: "no port is open", Unreachable: true}
+	}
+
+	var guesses []guess
+	if open[443] {
+		if g, ok := fingerprintVSphere(host, 443); ok {
+			// The vSphere API is authoritative
+			return g
+		}
+	}
+	// HTTPS is preferred since WinRM over HTTP is only probed if it is allowed explicitly
+	for _, p := range []int{5986, 5985} {
+		if !open[p] {
+			continue
+		}
+		if g, ok := fingerprintWinRM(host, p, p == 5986); ok {
+			guesses = append(guesses, g)
+			break
+		}
+	}
+	if open[22] {
+		if g, ok := fingerprintSSH(host, 22); ok {
+			guesses = append(guesses, g)
+		}
+	}
+
+	best := unknownGuess
+	for _, g := range guesses {
+		if g.Confidence > best.Confidence {
+			best = g
+		}
+	}
+	if best.Type != "unknown" {
+		return best
+	}
+	return guessByPorts(open)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestClassifyVSphere(t *testing.T) {
+	tests := []struct {
+		name       string
+		about      types.AboutInfo
+		typ        string
+		confidence float64
+		ok         bool
+	}{
+		{name: "ESXi", about: types.AboutInfo{FullName: "VMware ESXi 7.0.3", ProductLineId: "embeddedEsx", ApiType: "HostAgent"}, typ: "esxi", confidence: 0.99, ok: true},
+		{name: "ESX", about: types.AboutInfo{ProductLineId: "esx", ApiType: "HostAgent"}, typ: "esxi", confidence: 0.99, ok: true},
+		{name: "vCenter", about: types.AboutInfo{FullName: "VMware vCenter Server 7.0.3", ProductLineId: "vpx", ApiType: "VirtualCenter"}, typ: "vcenter", confidence: 0.99, ok: true},
+		{name: "other host agent", about: types.AboutInfo{ProductLineId: "sim", ApiType: "HostAgent"}, typ: "esxi", confidence: 0.8, ok: true},
+		{name: "other vCenter", about: types.AboutInfo{ProductLineId: "sim", ApiType: "VirtualCenter"}, typ: "vcenter", confidence: 0.8, ok: true},
+		{name: "unknown API", about: types.AboutInfo{ProductLineId: "sim", ApiType: "Other"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, ok := classifyVSphere(tt.about)
+			if ok != tt.ok || g.Type != tt.typ || g.Confidence != tt.confidence {
+				t.Errorf("got %s with confidence %v (%v), want %s with %v (%v)", g.Type, g.Confidence, ok, tt.typ, tt.confidence, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxExpand the max. num. of hosts a single definition can be expanded to
+const maxExpand = 65536
+
+var (
+	ipRangeRegex = regexp.MustCompile(`^(\d+\.\d+\.\d+\.\d+)-(\d+(?:\.\d+\.\d+\.\d+)?)$`)
+	patternRegex = regexp.MustCompile(`\[([\d,-]+)\]`)
+)
+
+/*
+	Host definitions can be either a json array or a json object with exclusions:
+	[
+		"192.168.10.11",
+		"192.168.10.0/24",
+		"192.168.11.10-80",
+		"lab-node[01-64].example"
+	]
+
+	{
+		"hosts": ["192.168.10.0/24", ...],
+		"exclude": ["192.168.10.1", "192.168.10.250-254", ...]
+	}
+*/
+func loadHosts(path string) ([]string, []string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var hosts []string
+	if err := json.Unmarshal(contents, &hosts); err == nil {
+		return hosts, nil, nil
+	}
+
+	var defs struct {
+		Hosts   []string `json:"hosts"`
+		Exclude []string `json:"exclude"`
+	}
+	if err := json.Unmarshal(contents, &defs); err != nil {
+		return nil, nil, err
+	}
+	return defs.Hosts, defs.Exclude, nil
+}
+
+// expandHosts expand host definitions into individual hosts, duplicated and excluded hosts are removed
+func expandHosts(defs, excludes []string) ([]string, error) {
+	excluded := map[string]bool{}
+	for _, def := range excludes {
+		hosts, err := expandHost(def)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			excluded[host] = true
+		}
+	}
+
+	var ret []string
+	seen := map[string]bool{}
+	for _, def := range defs {
+		hosts, err := expandHost(def)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			if excluded[host] || seen[host] {
+				continue
+			}
+			seen[host] = true
+			ret = append(ret, host)
+		}
+	}
+	return ret, nil
+}
+
+// expandHost expand a CIDR block, an IP range, a hostname pattern or a literal host
+func expandHost(def string) ([]string, error) {
+	def = strings.TrimSpace(def)
+	switch {
+	case def == "":
+		return nil, nil
+	case strings.Contains(def, "/"):
+		return expandCIDR(def)
+	case ipRangeRegex.MatchString(def):
+		return expandIPRange(def)
+	case patternRegex.MatchString(def):
+		return expandPattern(def)
+	}
+	return []string{def}, nil
+}
+
+// expandCIDR e.g. 192.168.10.0/24, network and broadcast addresses are skipped
+func expandCIDR(def string) ([]string, error) {
+	_, ipnet, err := net.ParseCIDR(def)
+	if err != nil {
+		return nil, err
+	}
+	if ipnet.IP.To4() == nil {
+		return nil, fmt.Errorf("Only IPv4 CIDR is supported: %s", def)
+	}
+
+	ones, bits := ipnet.Mask.Size()
+	size := uint64(1) << uint(bits-ones)
+	if size > maxExpand {
+		return nil, fmt.Errorf("CIDR %s contains more than %d hosts", def, maxExpand)
+	}
+
+	start := binary.BigEndian.Uint32(ipnet.IP.To4())
+	first, last := uint64(0), size-1
+	// /31 and /32 have no network and broadcast addresses
+	if size > 2 {
+		first, last = 1, size-2
+	}
+
+	var ret []string
+	for i := first; i <= last; i++ {
+		ret = append(ret, uint32ToIP(start+uint32(i)))
+	}
+	return ret, nil
+}
+
+// expandIPRange e.g. 192.168.10.10-80 or 192.168.10.10-192.168.11.80
+func expandIPRange(def string) ([]string, error) {
+	m := ipRangeRegex.FindStringSubmatch(def)
+	first := net.ParseIP(m[1]).To4()
+	if first == nil {
+		return nil, fmt.Errorf("Invalid IP range: %s", def)
+	}
+
+	end := m[2]
+	if !strings.Contains(end, ".") {
+		// Only the last octet is specified
+		end = fmt.Sprintf("%d.%d.%d.%s", first[0], first[1], first[2], end)
+	}
+	last := net.ParseIP(end).To4()
+	if last == nil {
+		return nil, fmt.Errorf("Invalid IP range: %s", def)
+	}
+
+	start := binary.BigEndian.Uint32(first)
+	stop := binary.BigEndian.Uint32(last)
+	if stop < start {
+		return nil, fmt.Errorf("Invalid IP range: %s", def)
+	}
+	if uint64(stop-start)+1 > maxExpand {
+		return nil, fmt.Errorf("IP range %s contains more than %d hosts", def, maxExpand)
+	}
+
+	var ret []string
+	for i := uint64(start); i <= uint64(stop); i++ {
+		ret = append(ret, uint32ToIP(uint32(i)))
+	}
+	return ret, nil
+}
+
+// expandPattern e.g. lab-node[01-64].example or rack[1-4]-node[1,3,5-8], zero padding is kept
+func expandPattern(def string) ([]string, error) {
+	loc := patternRegex.FindStringSubmatchIndex(def)
+	if loc == nil {
+		return []string{def}, nil
+	}
+	prefix, body, suffix := def[:loc[0]], def[loc[2]:loc[3]], def[loc[1]:]
+
+	var values []string
+	for _, part := range strings.Split(body, ",") {
+		// e.g., [1,,2] or [1,], which would expand to the bare prefix
+		if part == "" {
+			return nil, fmt.Errorf("Invalid hostname pattern: %s", def)
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		if len(bounds) == 1 {
+			values = append(values, bounds[0])
+			continue
+		}
+
+		start, err1 := strconv.Atoi(bounds[0])
+		stop, err2 := strconv.Atoi(bounds[1])
+		if err1 != nil || err2 != nil || stop < start {
+			return nil, fmt.Errorf("Invalid hostname pattern: %s", def)
+		}
+		if len(values)+stop-start+1 > maxExpand {
+			return nil, fmt.Errorf("Hostname pattern %s contains more than %d hosts", def, maxExpand)
+		}
+
+		width := 0
+		if strings.HasPrefix(bounds[0], "0") {
+			width = len(bounds[0])
+		}
+		for i := start; i <= stop; i++ {
+			values = append(values, fmt.Sprintf("%0*d", width, i))
+		}
+	}
+
+	// Expand the rest of the patterns recursively
+	rests, err := expandPattern(suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	// The size is checked before the product is built, e.g., [1-60000]-[1-60000] would not fit in memory
+	if len(values)*len(rests) > maxExpand {
+		return nil, fmt.Errorf("Hostname pattern %s contains more than %d hosts", def, maxExpand)
+	}
+	var ret []string
+	for _, v := range values {
+		for _, rest := range rests {
+			ret = append(ret, prefix+v+rest)
+		}
+	}
+	return ret, nil
+}
+
+func uint32ToIP(v uint32) string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, v)
+	return ip.String()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestExpandHost(t *testing.T) {
+	tests := []struct {
+		def  string
+		want []string
+		n    int // num. of hosts if want is not listed
+		err  bool
+	}{
+		{def: "web1.example.com", want: []string{"web1.example.com"}},
+		{def: "  ", want: nil},
+		{def: "192.168.10.0/30", want: []string{"192.168.10.1", "192.168.10.2"}},
+		{def: "192.168.10.4/31", want: []string{"192.168.10.4", "192.168.10.5"}},
+		{def: "192.168.10.7/32", want: []string{"192.168.10.7"}},
+		{def: "10.0.0.0/16", n: 65534},
+		{def: "10.0.0.0/15", err: true},
+		{def: "fd00::/120", err: true},
+		{def: "192.168.10.0/33", err: true},
+		{def: "192.168.10.10-12", want: []string{"192.168.10.10", "192.168.10.11", "192.168.10.12"}},
+		{def: "192.168.10.255-192.168.11.1", want: []string{"192.168.10.255", "192.168.11.0", "192.168.11.1"}},
+		{def: "192.168.10.12-10", err: true},
+		{def: "192.168.10.10-300", err: true},
+		{def: "10.0.0.0-10.1.0.0", err: true},
+		{def: "10.0.0.0-10.0.255.255", n: maxExpand},
+		{def: "node[08-10].lab", want: []string{"node08.lab", "node09.lab", "node10.lab"}},
+		{def: "rack[1-2]-node[1,3]", want: []string{"rack1-node1", "rack1-node3", "rack2-node1", "rack2-node3"}},
+		{def: "node[1,3-4]", want: []string{"node1", "node3", "node4"}},
+		{def: "node[3-1]", err: true},
+		{def: "node[1,,2]", err: true},
+		{def: "node[1,]", err: true},
+		{def: "node[,1]", err: true},
+		{def: "node[1-]", err: true},
+		{def: "node[0-65535]", n: maxExpand},
+		{def: "node[0-65536]", err: true},
+		{def: "r[1-300]n[1-300]", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.def, func(t *testing.T) {
+			got, err := expandHost(tt.def)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if tt.n > 0 {
+				if len(got) != tt.n {
+					t.Errorf("got %d hosts, want %d", len(got), tt.n)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandHosts(t *testing.T) {
+	got, err := expandHosts([]string{"192.168.10.0/29", "192.168.10.3", "lab[1-3]"}, []string{"192.168.10.2-4", "lab2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.168.10.1", "192.168.10.5", "192.168.10.6", "lab1", "lab3"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := expandHosts([]string{"web1"}, []string{"node[1,,2]"}); err == nil {
+		t.Error("an invalid exclusion is accepted")
+	}
+}
@@ -1,30 +1,33 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"os"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/kckecheng/osprobe/credential"
 	"github.com/kckecheng/osprobe/probe"
-	"github.com/kckecheng/osprobe/probe/linux"
-	"github.com/kckecheng/osprobe/probe/vmware"
-	"github.com/kckecheng/osprobe/probe/windows"
+	_ "github.com/kckecheng/osprobe/probe/all"
+	"github.com/kckecheng/osprobe/vault"
 	flag "github.com/spf13/pflag"
 )
 
 // const definitions
 const (
-	ERREXIT               = 1
-	TIMEOUT time.Duration = 3
+	ERREXIT = 1
+)
+
+// Timeouts of each scan phase and max. login attempts per host, can be changed with CLI options
+var (
+	dialTimeout  = 3 * time.Second
+	loginTimeout = 30 * time.Second
+	maxAttempts  = 3
 )
 
 func scanTPort(host string, port int) bool {
-	con, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", host, port), TIMEOUT*time.Second)
+	con, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", host, port), dialTimeout)
 	if err != nil {
 		return false
 	}
@@ -32,163 +35,308 @@ func scanTPort(host string, port int) bool {
 	return true
 }
 
-/*
-	Detect the most likely OS based on opened ports:
-	- VMware:
-		- 902: vSphere client to VM for ESXi 5.x and later
-		- 903: vSphere client to VM for ESXi 3.5 and 4.x
-		- 443: vSphere client to ESX/ESXi
-	- Windows:
-		- 445: Windows sharing
-		- 3389: RDP
-		- 5985: WinRM HTTP
-		- 5986: WinRM HTTPS
-	- Linux:
-		- 22: SSH
-*/
-func guessOS(host string) string {
-	if scanTPort(host, 443) && (scanTPort(host, 902) || scanTPort(host, 903)) {
-		return "esxi"
+// login check if a server can be probed with a credential within the login timeout. Connections established
+// after the timeout are closed, closing the probe also aborts a verification still running.
+func login(server probe.Server, cred credential.Credential) bool {
+	server.User = cred.User
+	server.Password = cred.Password
+	server.Key = cred.Key
+	server, err := server.Resolve()
+	if err != nil {
+		return false
 	}
 
-	if scanTPort(host, 3389) && (scanTPort(host, 5985) || scanTPort(host, 5986) || scanTPort(host, 445)) {
-		return "windows"
+	type result struct {
+		p   probe.Probe
+		err error
 	}
+	connected := make(chan result, 1)
+	go func() {
+		p, err := probe.Connect(server)
+		connected <- result{p, err}
+	}()
 
-	if scanTPort(host, 22) {
-		return "linux"
+	timer := time.NewTimer(loginTimeout)
+	defer timer.Stop()
+
+	var p probe.Probe
+	select {
+	case r := <-connected:
+		if r.err != nil {
+			return false
+		}
+		p = r.p
+	case <-timer.C:
+		go func() {
+			if r := <-connected; r.err == nil {
+				r.p.Close()
+			}
+		}()
+		return false
 	}
+	defer p.Close()
 
-	return "unknown"
-}
+	verified := make(chan error, 1)
+	go func() {
+		verified <- probe.Verify(p, server)
+	}()
 
-/*
-	cbd: credentails map as below
-	{
-		"linux": ["user1:password1", "user2:password2", ...],
-		"windows": [...],
-		"esxi": [...],
+	select {
+	case err := <-verified:
+		return err == nil
+	case <-timer.C:
+		return false
 	}
-*/
-func matchCredential(server probe.Server, cdb map[string][]string) (string, string) {
-	for _, upcomb := range cdb[server.Type] {
-		up := strings.Split(upcomb, ":")
-
-		var p probe.Probe
-		var err error
-		switch server.Type {
-		case "linux":
-			p, err = linux.NewServer(server.Host, up[0], up[1], server.Port)
-		case "windows":
-			p, err = windows.NewServer(server.Host, up[0], up[1], server.Port)
-		case "esxi":
-			p, err = vmware.NewServer(server.Host, up[0], up[1], server.Port)
-		}
+}
 
-		if err != nil {
-			continue
+// matchCredential try credentials scoped to the host, at most maxAttempts credentials are tried to avoid account lockout
+func matchCredential(server probe.Server, cdb *credential.DB) (credential.Credential, bool) {
+	for i, cred := range cdb.Match(server.Type, server.Host) {
+		if maxAttempts > 0 && i >= maxAttempts {
+			break
 		}
 
-		_, err = p.GetCPUUsage()
-		if err != nil {
-			continue
-		} else {
-			return up[0], up[1]
+		if login(server, cred) {
+			return cred, true
 		}
 	}
 
-	return "", ""
+	return credential.Credential{}, false
 }
 
 /*
-	host: IP/FQDN
-	cdbpath: credentials json file with a format as below
-	{
-		"linux": ["user1:password1", "user2:password2", ...],
-		"windows": [...],
-		"esxi": [...],
-	}
+	server: IP/FQDN, with the labels and options of an existing definition if any
+	cdb: credential database, the matched credential is recorded by name
 */
-func fillServer(host, cdbpath string) probe.Server {
-	server := probe.Server{
-		Host: host,
-	}
-
-	osType := guessOS(host)
+func fillServer(server probe.Server, cdb *credential.DB) (probe.Server, guess) {
+	g := fingerprint(server.Host)
+	osType := g.Type
 	server.Type = osType
 
-	switch osType {
-	case "linux":
-		server.Port = 22
-	case "windows":
-		server.Port = 5985
-	case "esxi":
-		server.Port = 443
+	// The port is left unset for unknown types
+	if b, ok := probe.Lookup(osType); ok {
+		server.Port = b.Port
 	}
-
-	contents, err := ioutil.ReadFile(cdbpath)
-	if err != nil {
-		panic(err)
+	if g.Port != 0 {
+		server.Port = g.Port
 	}
-
-	var cdb map[string][]string
-	err = json.Unmarshal(contents, &cdb)
-	if err != nil {
-		panic(err)
+	// Credentials are not sent in clear text, WinRM over HTTP has to be allowed explicitly in the configuration
+	if unencryptedWinRM(server) {
+		return server, g
 	}
 
-	user, password := matchCredential(server, cdb)
-	server.User = user
-	server.Password = password
-	return server
+	if cred, ok := matchCredential(server, cdb); ok {
+		server.Credential = cred.Name
+	}
+	return server, g
 }
 
 func main() {
 	// Parse CLI options
-	var hfpath, cfpath, ofpath string
-	flag.StringVarP(&hfpath, "server", "s", "hosts.json", "Host IP/FQDB definitions json")
-	flag.StringVarP(&cfpath, "password", "p", "credentials.json", "Credential database json")
+	var hfpath, cfpath, ofpath, rfpath, vpath string
+	var excludes []string
+	var merge bool
+	var workers int
+	flag.StringVarP(&hfpath, "server", "s", "hosts.json", "Host IP/FQDN definitions json, CIDR blocks, IP ranges and hostname patterns are supported")
+	flag.StringSliceVarP(&excludes, "exclude", "x", nil, "Hosts to exclude, CIDR blocks, IP ranges and hostname patterns are supported")
+	flag.StringVarP(&cfpath, "password", "p", "credentials.json", "Credential database json, can be empty if a vault is used")
+	flag.StringVarP(&vpath, "vault", "V", "", "Encrypted credential vault, credentials in the vault are tried and matched credentials are saved into it")
 	flag.StringVarP(&ofpath, "output", "o", "servers.json", "Output json")
+	flag.BoolVarP(&merge, "merge", "m", false, "Merge into the existing output json: add new hosts, refresh credentials stopped working and keep other fields")
+	flag.StringVarP(&rfpath, "report", "r", "", "Report json listing the unreachable and unmatched hosts")
+	flag.IntVarP(&workers, "workers", "w", 32, "Num. of hosts scanned concurrently")
+	flag.DurationVar(&dialTimeout, "dial-timeout", dialTimeout, "Timeout of each TCP dial and protocol fingerprint")
+	flag.DurationVar(&loginTimeout, "login-timeout", loginTimeout, "Timeout of each login attempt")
+	flag.IntVar(&maxAttempts, "max-attempts", maxAttempts, "Max. num. of credentials tried per host, 0 means no limit")
 	flag.Parse()
 
-	if hfpath == "" || cfpath == "" {
+	if hfpath == "" || (cfpath == "" && vpath == "") || workers <= 0 || dialTimeout <= 0 || loginTimeout <= 0 {
 		flag.Usage()
 		os.Exit(ERREXIT)
 	}
 
 	// Parse host IP/FQDN definitions
-	contents, err := ioutil.ReadFile(hfpath)
+	defs, fexcludes, err := loadHosts(hfpath)
 	if err != nil {
 		panic(err)
 	}
-
-	var hosts []string
-	err = json.Unmarshal(contents, &hosts)
+	hosts, err := expandHosts(defs, append(fexcludes, excludes...))
 	if err != nil {
 		panic(err)
 	}
 
-	var servers []probe.Server
-	var mutex sync.Mutex
+	// Credentials are parsed once for all hosts
+	cdb := &credential.DB{}
+	if cfpath != "" {
+		cdb, err = credential.Load(cfpath)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var v *vault.Vault
+	if vpath != "" {
+		// Matched credentials are saved into the vault, it is created if it does not exist
+		passphrase, err := vault.Passphrase(!vault.Exists(vpath))
+		if err != nil {
+			panic(err)
+		}
+		v, err = vault.Open(vpath, passphrase)
+		if err != nil {
+			panic(err)
+		}
+		cdb.Merge(v.DB())
+	}
+
+	var entries []entry
+	if merge {
+		entries, err = loadServers(ofpath)
+		if err != nil {
+			panic(err)
+		}
+	}
+	existing := map[string]int{}
+	for i, e := range entries {
+		existing[e.server.Host] = i
+	}
+
+	// Hosts are scanned by a pool of workers
+	outcomes := make([]outcome, len(hosts))
+	jobs := make(chan int)
+	prog := newProgress(len(hosts))
 	var wg sync.WaitGroup
-	for _, host := range hosts {
+	for w := 0; w < workers; w++ {
 		wg.Add(1)
-		go func(host string) {
+		go func() {
 			defer wg.Done()
-			server := fillServer(host, cfpath)
-			mutex.Lock()
-			servers = append(servers, server)
-			mutex.Unlock()
-		}(host)
+			for i := range jobs {
+				outcomes[i] = scanHost(hosts[i], cdb, entries, existing, merge)
+				prog.done(outcomes[i])
+			}
+		}()
+	}
+	for i := range hosts {
+		jobs <- i
 	}
+	close(jobs)
 	wg.Wait()
 
-	bytes, err := json.MarshalIndent(servers, "", "  ")
-	if err != nil {
-		panic(err)
+	for _, o := range outcomes {
+		switch o.status {
+		case statusAdded:
+			e, err := newEntry(o.server)
+			if err != nil {
+				panic(err)
+			}
+			entries = append(entries, e)
+		case statusRefreshed:
+			if err := entries[existing[o.server.Host]].refresh(o.server.Credential); err != nil {
+				panic(err)
+			}
+		case statusRedetected:
+			e, err := newEntry(o.server)
+			if err != nil {
+				panic(err)
+			}
+			entries[existing[o.server.Host]] = e
+		}
 	}
-	err = ioutil.WriteFile(ofpath, bytes, 0644)
-	if err != nil {
+
+	if err := saveServers(ofpath, entries); err != nil {
 		panic(err)
 	}
+	if rfpath != "" {
+		if err := saveUnmatched(rfpath, outcomes); err != nil {
+			panic(err)
+		}
+	}
+	if v != nil {
+		if err := saveToVault(v, cdb, outcomes); err != nil {
+			panic(err)
+		}
+	}
+	printDiff(outcomes)
+	printSummary(outcomes)
+}
+
+// saveToVault save matched credentials into the vault so that they can be resolved by name
+func saveToVault(v *vault.Vault, cdb *credential.DB, outcomes []outcome) error {
+	for _, o := range outcomes {
+		if o.server.Credential == "" || (o.status != statusAdded && o.status != statusRefreshed && o.status != statusRedetected) {
+			continue
+		}
+		c, ok := cdb.Get(o.server.Credential)
+		if !ok {
+			continue
+		}
+		if err := v.DB().Put(c); err != nil {
+			return err
+		}
+	}
+	return v.Save()
+}
+
+// scanHost verify an existing host or detect a new one, existing hosts without a known type or port are detected again
+func scanHost(host string, cdb *credential.DB, entries []entry, existing map[string]int, merge bool) outcome {
+	idx, ok := existing[host]
+	if ok && !undetected(entries[idx].server) {
+		return verifyServer(entries[idx].server, cdb)
+	}
+
+	server := probe.Server{Host: host}
+	if ok {
+		// Only labels and options set by users are kept
+		server.Labels = entries[idx].server.Labels
+		server.Options = entries[idx].server.Options
+	}
+	server, g := fillServer(server, cdb)
+	o := outcome{server: server, guess: g, status: statusAdded}
+	if ok {
+		o.status = statusRedetected
+	}
+	o.reason = unmatchedReason(server, g)
+	// Unusable hosts are only added when the output is generated from scratch
+	if o.reason != "" && (merge || ok) {
+		o.status = statusFailed
+	}
+	return o
+}
+
+// undetected tell if the type or the port of an existing server is still to be detected
+func undetected(server probe.Server) bool {
+	b, ok := probe.Lookup(server.Type)
+	return !ok || (server.Port == 0 && b.Port != 0)
+}
+
+// verifyServer check the credential of an existing server, a new credential is matched if it stops working
+func verifyServer(server probe.Server, cdb *credential.DB) outcome {
+	o := outcome{
+		server: server,
+		guess:  guess{Type: server.Type, Confidence: 1, Evidence: "existing definition"},
+		status: statusUnchanged,
+	}
+
+	current := credential.Credential{User: server.User, Password: server.Password, Key: server.Key}
+	if server.Credential != "" {
+		current, _ = cdb.Get(server.Credential)
+	}
+	if (current.Password != "" || current.Key != "") && login(server, current) {
+		return o
+	}
+	if !scanTPort(server.Host, server.Port) {
+		o.status = statusFailed
+		o.reason = reasonUnreachable
+		return o
+	}
+
+	cred, ok := matchCredential(server, cdb)
+	if !ok {
+		o.status = statusFailed
+		o.reason = reasonCredStopped
+		return o
+	}
+
+	o.server.Credential = cred.Name
+	o.status = statusRefreshed
+	return o
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+func TestUndetected(t *testing.T) {
+	tests := []struct {
+		name   string
+		server probe.Server
+		want   bool
+	}{
+		{name: "detected", server: probe.Server{Host: "web1", Type: "linux", Port: 22}},
+		{name: "unknown type", server: probe.Server{Host: "web1", Type: "unknown"}, want: true},
+		{name: "unknown type with a port", server: probe.Server{Host: "web1", Type: "unknown", Port: 22}, want: true},
+		{name: "no type", server: probe.Server{Host: "web1", Port: 22}, want: true},
+		{name: "no port", server: probe.Server{Host: "web1", Type: "linux"}, want: true},
+		{name: "type without a port", server: probe.Server{Host: "web1", Type: "local"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := undetected(tt.server); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
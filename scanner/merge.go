@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+// Scan status of a host
+const (
+	statusAdded      = "added"
+	statusRefreshed  = "refreshed"
+	statusRedetected = "redetected"
+	statusUnchanged  = "unchanged"
+	statusFailed     = "failed"
+)
+
+// Reasons why a host is listed in the unmatched report
+const (
+	reasonUnreachable  = "unreachable"
+	reasonUnknownType  = "unknown type"
+	reasonNoCredential = "no matching credential"
+	reasonCredStopped  = "credential stopped working"
+	reasonUnencrypted  = "WinRM over HTTPS is not enabled"
+)
+
+// entry a server definition, fields added by users are kept as is in raw
+type entry struct {
+	server probe.Server
+	raw    json.RawMessage
+}
+
+// outcome the result of scanning a host
+type outcome struct {
+	server probe.Server
+	guess  guess
+	status string
+	reason string
+}
+
+// unmatched a host which cannot be added or refreshed
+type unmatched struct {
+	Host       string  `json:"host"`
+	Type       string  `json:"type"`
+	Confidence float64 `json:"confidence"`
+	Reason     string  `json:"reason"`
+}
+
+// loadServers load an existing server definitions json, an empty list is returned if the file does not exist
+func loadServers(path string) ([]entry, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var raws []json.RawMessage
+	if err := json.Unmarshal(contents, &raws); err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	for _, raw := range raws {
+		var server probe.Server
+		if err := json.Unmarshal(raw, &server); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{server: server, raw: raw})
+	}
+	return entries, nil
+}
+
+// newEntry build an entry for a newly discovered server
+func newEntry(server probe.Server) (entry, error) {
+	raw, err := json.Marshal(server)
+	if err != nil {
+		return entry{}, err
+	}
+	return entry{server: server, raw: raw}, nil
+}
+
+// refresh refer to a new credential without touching fields other than the credential ones
+func (e *entry) refresh(name string) error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(e.raw, &fields); err != nil {
+		return err
+	}
+	delete(fields, "user")
+	delete(fields, "password")
+	delete(fields, "key")
+	fields["credential"] = name
+
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	e.server.User = ""
+	e.server.Password = ""
+	e.server.Key = ""
+	e.server.Credential = name
+	e.raw = raw
+	return nil
+}
+
+func saveServers(path string, entries []entry) error {
+	raws := []json.RawMessage{}
+	for _, e := range entries {
+		raws = append(raws, e.raw)
+	}
+
+	bytes, err := json.MarshalIndent(raws, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bytes, 0644)
+}
+
+func saveUnmatched(path string, outcomes []outcome) error {
+	ret := []unmatched{}
+	for _, o := range outcomes {
+		if o.reason == "" {
+			continue
+		}
+		ret = append(ret, unmatched{
+			Host:       o.server.Host,
+			Type:       o.server.Type,
+			Confidence: o.guess.Confidence,
+			Reason:     o.reason,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Host < ret[j].Host })
+
+	bytes, err := json.MarshalIndent(ret, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bytes, 0644)
+}
+
+// printDiff print a summary of what is changed in the server definitions
+func printDiff(outcomes []outcome) {
+	grouped := map[string][]string{}
+	for _, o := range outcomes {
+		desc := o.server.Host
+		if o.reason != "" {
+			desc = fmt.Sprintf("%s (%s)", o.server.Host, o.reason)
+		}
+		grouped[o.status] = append(grouped[o.status], desc)
+	}
+
+	for _, status := range []string{statusAdded, statusRefreshed, statusRedetected, statusUnchanged, statusFailed} {
+		hosts := grouped[status]
+		sort.Strings(hosts)
+		fmt.Printf("%s (%d)", strings.Title(status), len(hosts))
+		if len(hosts) > 0 && status != statusUnchanged {
+			fmt.Printf(": %s", strings.Join(hosts, ", "))
+		}
+		fmt.Println()
+	}
+}
+
+// unencryptedWinRM tell if a Windows server only answers WinRM over HTTP, which is refused unless allow_unencrypted is set
+func unencryptedWinRM(server probe.Server) bool {
+	return server.Type == "windows" && server.Port == 5985 && server.Option("allow_unencrypted", "false") != "true"
+}
+
+// unmatchedReason tell why a newly scanned server cannot be used
+func unmatchedReason(server probe.Server, g guess) string {
+	switch {
+	case g.Unreachable:
+		return reasonUnreachable
+	case server.Port == 0:
+		return reasonUnknownType
+	case unencryptedWinRM(server):
+		return reasonUnencrypted
+	case server.Credential == "":
+		return reasonNoCredential
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kckecheng/osprobe/probe"
+)
+
+func TestUnmatchedReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		server probe.Server
+		guess  guess
+		want   string
+	}{
+		{name: "matched", server: probe.Server{Host: "web1", Type: "linux", Port: 22, Credential: "ops"}},
+		{name: "unreachable", server: probe.Server{Host: "web1", Type: "unknown"}, guess: guess{Unreachable: true}, want: reasonUnreachable},
+		{name: "unknown type", server: probe.Server{Host: "web1", Type: "unknown"}, want: reasonUnknownType},
+		{name: "no credential", server: probe.Server{Host: "web1", Type: "linux", Port: 22}, want: reasonNoCredential},
+		{name: "WinRM over HTTPS", server: probe.Server{Host: "win1", Type: "windows", Port: 5986, Credential: "admin"}},
+		{name: "WinRM over HTTP", server: probe.Server{Host: "win1", Type: "windows", Port: 5985}, want: reasonUnencrypted},
+		{
+			name:   "WinRM over HTTP allowed",
+			server: probe.Server{Host: "win1", Type: "windows", Port: 5985, Credential: "admin", Options: map[string]string{"allow_unencrypted": "true"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unmatchedReason(tt.server, tt.guess); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progress report the scan progress with an ETA
+type progress struct {
+	total    int
+	finished int
+	start    time.Time
+	mutex    sync.Mutex
+}
+
+func newProgress(total int) *progress {
+	return &progress{total: total, start: time.Now()}
+}
+
+// done record a scanned host and print the progress to stderr
+func (p *progress) done(o outcome) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.finished++
+	elapsed := time.Since(p.start)
+	eta := time.Duration(float64(elapsed) / float64(p.finished) * float64(p.total-p.finished))
+
+	detail := fmt.Sprintf("%s (confidence %.2f, %s)", o.guess.Type, o.guess.Confidence, o.guess.Evidence)
+	if o.reason != "" {
+		detail += ", " + o.reason
+	}
+	fmt.Fprintf(os.Stderr, "[%d/%d %3.0f%% ETA %s] %s: %s\n",
+		p.finished,
+		p.total,
+		float64(p.finished)*100/float64(p.total),
+		eta.Round(time.Second),
+		o.server.Host,
+		detail,
+	)
+}
+
+// printSummary print the num. of hosts per detected type and per failure reason
+func printSummary(outcomes []outcome) {
+	types := map[string]int{}
+	failures := map[string]int{}
+	for _, o := range outcomes {
+		types[o.guess.Type]++
+		if o.reason != "" {
+			failures[o.reason]++
+		}
+	}
+
+	fmt.Printf("Detected: %s\n", joinCounts(types))
+	fmt.Printf("Failures: %s\n", joinCounts(failures))
+}
+
+func joinCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ret []string
+	for _, k := range keys {
+		ret = append(ret, fmt.Sprintf("%s %d", k, counts[k]))
+	}
+	return strings.Join(ret, ", ")
+}
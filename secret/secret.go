@@ -0,0 +1,111 @@
+package secret
+
+/*
+	Secrets such as passwords and SSH keys can be given as references resolved at probe time:
+	- env:VAR: the value of an environment variable;
+	- file:/path: the contents of a file, e.g., a mounted Kubernetes Secret;
+	- exec:command args...: the output of a helper command, e.g., a password manager CLI.
+
+	Values without a known prefix are plain text secrets. Resolved values must never be logged.
+*/
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ExecTimeout how long an exec helper can run
+var ExecTimeout = 30 * time.Second
+
+// ErrUnresolvable a secret reference cannot be resolved
+var ErrUnresolvable = errors.New("Fail to resolve secret reference")
+
+// resolvers resolve references based on their prefix
+var resolvers = map[string]func(string) (string, error){
+	"env":  resolveEnv,
+	"file": resolveFile,
+	"exec": resolveExec,
+}
+
+// Register add a resolver for references with a prefix, e.g., vault:name
+func Register(prefix string, resolver func(string) (string, error)) {
+	resolvers[prefix] = resolver
+}
+
+// IsRef check if a value is a secret reference instead of a plain text secret
+func IsRef(value string) bool {
+	prefix, _, ok := split(value)
+	if !ok {
+		return false
+	}
+	_, ok = resolvers[prefix]
+	return ok
+}
+
+// Resolve resolve a secret reference, plain text secrets are returned as is
+func Resolve(value string) (string, error) {
+	prefix, ref, ok := split(value)
+	if !ok {
+		return value, nil
+	}
+	resolver, ok := resolvers[prefix]
+	if !ok {
+		return value, nil
+	}
+
+	secret, err := resolver(ref)
+	if err != nil {
+		// Only the reference is reported, never the secret
+		return "", fmt.Errorf("%w %s:%s: %s", ErrUnresolvable, prefix, ref, err)
+	}
+	return secret, nil
+}
+
+func split(value string) (string, string, bool) {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return "", "", false
+	}
+	return value[:i], value[i+1:], true
+}
+
+func resolveEnv(name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errors.New("environment variable is not set")
+	}
+	return v, nil
+}
+
+func resolveFile(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
+
+func resolveExec(command string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", errors.New("no command is specified")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExecTimeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(out.String(), "\r\n"), nil
+}
@@ -0,0 +1,97 @@
+package secret
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	os.Setenv("OSPROBE_TEST_SECRET", "from env")
+	defer os.Unsetenv("OSPROBE_TEST_SECRET")
+	os.Unsetenv("OSPROBE_TEST_MISSING")
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(file, []byte("pass:word\r\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	Register("vault", func(name string) (string, error) {
+		if name == "lab-root" {
+			return "from vault", nil
+		}
+		return "", errors.New("credential is not defined in the vault")
+	})
+	defer delete(resolvers, "vault")
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"plain text", "password", "password", false},
+		{"plain text with a colon", "pass:word", "pass:word", false},
+		{"leading colon", ":password", ":password", false},
+		{"env", "env:OSPROBE_TEST_SECRET", "from env", false},
+		{"env not set", "env:OSPROBE_TEST_MISSING", "", true},
+		{"file without trailing newlines", "file:" + file, "pass:word", false},
+		{"missing file", "file:" + filepath.Join(dir, "missing"), "", true},
+		{"exec", "exec:printf from\\040exec\\n", "from exec", false},
+		{"exec with empty output", "exec:sh -c true", "", false},
+		{"exec failing", "exec:sh -c false", "", true},
+		{"exec without command", "exec: ", "", true},
+		{"exec not found", "exec:osprobe-no-such-helper", "", true},
+		{"vault", "vault:lab-root", "from vault", false},
+		{"vault missing credential", "vault:missing", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve(%s) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrUnresolvable) {
+				t.Errorf("Resolve(%s) error = %v, want ErrUnresolvable", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve(%s) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveErrorHidesSecret(t *testing.T) {
+	Register("test", func(string) (string, error) {
+		return "s3cret", errors.New("failed")
+	})
+	defer delete(resolvers, "test")
+
+	_, err := Resolve("test:ref")
+	if err == nil || strings.Contains(err.Error(), "s3cret") || !strings.Contains(err.Error(), "test:ref") {
+		t.Errorf("Resolve error = %v, want the reference without the secret", err)
+	}
+}
+
+func TestIsRef(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"env:HOME", true},
+		{"file:/etc/secret", true},
+		{"exec:pass show lab", true},
+		{"vault:lab-root", false},
+		{"pass:word", false},
+		{"password", false},
+	}
+	for _, tt := range tests {
+		if got := IsRef(tt.value); got != tt.want {
+			t.Errorf("IsRef(%s) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
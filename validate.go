@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/kckecheng/osprobe/collector"
+	"github.com/kckecheng/osprobe/config"
+	"github.com/kckecheng/osprobe/credential"
+	"github.com/kckecheng/osprobe/probe"
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+)
+
+// dryRun connectivity check result of a server
+type dryRun struct {
+	server probe.Server
+	online bool
+	login  bool
+	reason string
+	err    error
+}
+
+// runValidate check a configuration before it is rolled out, the return value is used as the exit code
+func runValidate(args []string) int {
+	var cfg, creds, vpath string
+	var dry bool
+	var workers int
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.StringVarP(&cfg, "config", "c", "servers.json", "Configuration in JSON, YAML or TOML, can be overwritten by setting OSPROBE_CONFIG")
+	fs.StringVarP(&creds, "credentials", "C", "", "Credential database to check credentials referred by name, can be overwritten by setting OSPROBE_CREDENTIALS")
+	fs.StringVarP(&vpath, "vault", "V", "", "Encrypted credential vault to check credentials referred by name, can be overwritten by setting OSPROBE_VAULT")
+	fs.BoolVar(&dry, "dry-run", false, "Check reachability and login of each server without pushing anything")
+	fs.IntVarP(&workers, "workers", "w", 16, "Num. of servers checked concurrently during the dry run")
+	fs.Parse(args)
+
+	ecfg := getEnvVar("OSPROBE_CONFIG")
+	if ecfg != "" {
+		cfg = ecfg
+	}
+	ecreds := getEnvVar("OSPROBE_CREDENTIALS")
+	if ecreds != "" {
+		creds = ecreds
+	}
+	evpath := getEnvVar("OSPROBE_VAULT")
+	if evpath != "" {
+		vpath = evpath
+	}
+	if cfg == "" || workers <= 0 {
+		fmt.Fprintln(os.Stderr, "Usage: osprobe validate -c <config> [options]")
+		fs.PrintDefaults()
+		return 1
+	}
+
+	// Credentials of the database and the vault are checked together
+	var db *credential.DB
+	if creds != "" || vpath != "" {
+		db = &credential.DB{}
+	}
+	if creds != "" {
+		var err error
+		db, err = credential.Load(creds)
+		if err != nil {
+			log.Errorf("Fail to load credentials %s due to %s", creds, err)
+			return 1
+		}
+	}
+	if vpath != "" {
+		v, err := openVault(vpath)
+		if err != nil {
+			log.Errorf("Fail to open vault %s due to %s", vpath, err)
+			return 1
+		}
+		registerVault(v)
+		db.Merge(v.DB())
+	}
+
+	problems, err := config.Validate(cfg, db)
+	if err != nil {
+		log.Errorf("Fail to read configuration %s due to %s", cfg, err)
+		return 1
+	}
+	for _, p := range problems {
+		// Reported as <file>:<line>: <field>: <message> like compilers so that editors can jump to the line
+		location := cfg
+		if p.Line > 0 {
+			location = fmt.Sprintf("%s:%d", cfg, p.Line)
+		}
+		p.Line = 0
+		fmt.Printf("%s: %s\n", location, p)
+	}
+	if len(problems) == 0 {
+		fmt.Printf("%s: no problem found\n", cfg)
+	}
+
+	ret := 0
+	if len(problems) > 0 {
+		ret = 1
+	}
+	if !dry {
+		return ret
+	}
+
+	// Servers are checked even if problems are found, unusable servers just fail
+	conf, err := config.Load(cfg)
+	if err != nil {
+		return 1
+	}
+	servers, err := conf.Expand()
+	if err != nil {
+		log.Errorf("Fail to expand the configuration due to %s", err)
+		return 1
+	}
+	sc := collector.New(servers)
+	if db != nil {
+		sc.ResolveCredentials(db)
+	}
+
+	results := make([]dryRun, len(sc.Servers))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = checkServer(sc.Servers[i])
+			}
+		}()
+	}
+	for i := range sc.Servers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tTYPE\tONLINE\tLOGIN\tREASON\tERROR")
+	for _, r := range results {
+		var msg string
+		if r.err != nil {
+			msg = r.err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%t\t%s\t%s\n", r.server.Host, r.server.Type, r.online, r.login, r.reason, msg)
+		if !r.login {
+			ret = 1
+		}
+	}
+	tw.Flush()
+	return ret
+}
+
+// checkServer check if a server is reachable and can be logged in, a metric is read to make sure the credential works
+func checkServer(server probe.Server) dryRun {
+	r := dryRun{server: server}
+	if !server.Online() {
+		r.reason = probe.ReasonOffline
+		return r
+	}
+	r.online = true
+
+	server, err := server.Resolve()
+	if err == nil {
+		var p probe.Probe
+		p, err = probe.Connect(server)
+		if err == nil {
+			err = probe.Verify(p, server)
+			p.Close()
+		}
+	}
+	if err != nil {
+		r.reason = probe.Reason(err)
+		r.err = err
+		return r
+	}
+	r.login = true
+	return r
+}
@@ -0,0 +1,212 @@
+package vault
+
+/*
+	Encrypted local credential vault: a credential database (refer to the credential package)
+	encrypted with NaCl secretbox, the key is derived from a passphrase with scrypt:
+	{
+		"version": 1,
+		"salt": "<base64>",
+		"nonce": "<base64>",
+		"data": "<base64>"
+	}
+*/
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kckecheng/osprobe/credential"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const version = 1
+
+// scrypt parameters recommended for interactive logins
+const (
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrDecrypt the passphrase is wrong or the vault is corrupted
+var ErrDecrypt = errors.New("Fail to decrypt the vault, please check the passphrase")
+
+type sealed struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// Vault an encrypted credential database
+type Vault struct {
+	path       string
+	passphrase []byte
+	db         *credential.DB
+}
+
+// Open decrypt a vault, an empty vault is returned if the file does not exist
+func Open(path string, passphrase []byte) (*Vault, error) {
+	v := Vault{
+		path:       path,
+		passphrase: passphrase,
+		db:         &credential.DB{},
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &v, nil
+		}
+		return nil, err
+	}
+
+	var s sealed
+	if err := json.Unmarshal(contents, &s); err != nil {
+		return nil, err
+	}
+	if s.Version != version || len(s.Nonce) != 24 {
+		return nil, fmt.Errorf("Vault %s is not supported", path)
+	}
+
+	key, err := deriveKey(passphrase, s.Salt)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	copy(nonce[:], s.Nonce)
+	plain, ok := secretbox.Open(nil, s.Data, &nonce, key)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+
+	v.db, err = credential.Parse(plain)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// DB the decrypted credential database
+func (v *Vault) DB() *credential.DB {
+	return v.db
+}
+
+// Save encrypt the credential database with a new salt and nonce and write it atomically
+func (v *Vault) Save() error {
+	creds := v.db.Credentials
+	if creds == nil {
+		creds = []credential.Credential{}
+	}
+	plain, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+
+	s := sealed{
+		Version: version,
+		Salt:    make([]byte, 32),
+		Nonce:   make([]byte, 24),
+	}
+	if _, err := io.ReadFull(rand.Reader, s.Salt); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(rand.Reader, s.Nonce); err != nil {
+		return err
+	}
+
+	key, err := deriveKey(v.passphrase, s.Salt)
+	if err != nil {
+		return err
+	}
+	var nonce [24]byte
+	copy(nonce[:], s.Nonce)
+	s.Data = secretbox.Seal(nil, plain, &nonce, key)
+
+	contents, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(v.path), filepath.Base(v.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), v.path)
+}
+
+func deriveKey(passphrase, salt []byte) (*[32]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("Vault passphrase is empty")
+	}
+
+	k, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], k)
+	return &key, nil
+}
+
+// Exists check if the vault file exists, a new vault is created otherwise
+func Exists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+// Passphrase get the vault passphrase from OSPROBE_VAULT_PASSPHRASE, a key file
+// set with OSPROBE_VAULT_KEY_FILE, or a prompt if stdin is a terminal.
+// The prompt asks twice with confirm, e.g., for a new vault, so that a typo does not lock it.
+func Passphrase(confirm bool) ([]byte, error) {
+	if v, ok := os.LookupEnv("OSPROBE_VAULT_PASSPHRASE"); ok && v != "" {
+		return []byte(v), nil
+	}
+
+	if path, ok := os.LookupEnv("OSPROBE_VAULT_KEY_FILE"); ok && path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(contents), "\r\n")), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, errors.New("Vault passphrase is required, please set OSPROBE_VAULT_PASSPHRASE or OSPROBE_VAULT_KEY_FILE")
+	}
+	fmt.Fprint(os.Stderr, "Vault passphrase: ")
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil || !confirm {
+		return passphrase, err
+	}
+
+	fmt.Fprint(os.Stderr, "Confirm vault passphrase: ")
+	again, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if string(again) != string(passphrase) {
+		return nil, errors.New("Vault passphrases do not match")
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,185 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kckecheng/osprobe/credential"
+)
+
+var passphrase = []byte("correct horse battery staple")
+
+// seal a vault with one credential and return its path
+func seal(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "vault.json")
+	v, err := Open(path, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.DB().Put(credential.Credential{Name: "lab-root", Type: "linux", User: "root", Password: "pass:word"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRoundTrip(t *testing.T) {
+	path := seal(t)
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(contents, []byte("pass:word")) {
+		t.Error("Password is saved in plain text")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Vault mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+	files, _ := ioutil.ReadDir(filepath.Dir(path))
+	if len(files) != 1 {
+		t.Errorf("%d files are left next to the vault, want the vault only", len(files))
+	}
+
+	v, err := Open(path, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, ok := v.DB().Get("lab-root")
+	if !ok || c.User != "root" || c.Password != "pass:word" {
+		t.Errorf("Credential = %+v, %v after reopening the vault", c, ok)
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	v, err := Open(filepath.Join(t.TempDir(), "missing.json"), passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.DB().Credentials) != 0 {
+		t.Errorf("A missing vault has %d credentials, want 0", len(v.DB().Credentials))
+	}
+}
+
+func TestOpenFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase []byte
+		corrupt    func(t *testing.T, path string)
+		wantErr    error
+	}{
+		{"wrong passphrase", []byte("wrong"), nil, ErrDecrypt},
+		{"empty passphrase", nil, nil, nil},
+		{"truncated file", passphrase, func(t *testing.T, path string) {
+			contents, _ := ioutil.ReadFile(path)
+			write(t, path, contents[:len(contents)/2])
+		}, nil},
+		{"truncated data", passphrase, func(t *testing.T, path string) {
+			edit(t, path, func(s *sealed) { s.Data = s.Data[:len(s.Data)-1] })
+		}, ErrDecrypt},
+		{"tampered data", passphrase, func(t *testing.T, path string) {
+			edit(t, path, func(s *sealed) { s.Data[0] ^= 0xff })
+		}, ErrDecrypt},
+		{"short nonce", passphrase, func(t *testing.T, path string) {
+			edit(t, path, func(s *sealed) { s.Nonce = s.Nonce[:12] })
+		}, nil},
+		{"unknown version", passphrase, func(t *testing.T, path string) {
+			edit(t, path, func(s *sealed) { s.Version = 2 })
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := seal(t)
+			if tt.corrupt != nil {
+				tt.corrupt(t, path)
+			}
+
+			v, err := Open(path, tt.passphrase)
+			if err == nil {
+				t.Fatalf("Open succeeded with %d credentials, want an error", len(v.DB().Credentials))
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Open error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	write(t, keyFile, []byte("from file\r\n"))
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"environment", map[string]string{"OSPROBE_VAULT_PASSPHRASE": "from env", "OSPROBE_VAULT_KEY_FILE": keyFile}, "from env", false},
+		{"key file without the trailing newline", map[string]string{"OSPROBE_VAULT_KEY_FILE": keyFile}, "from file", false},
+		{"missing key file", map[string]string{"OSPROBE_VAULT_KEY_FILE": keyFile + ".missing"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"OSPROBE_VAULT_PASSPHRASE", "OSPROBE_VAULT_KEY_FILE"} {
+				setenv(t, k, tt.env[k])
+			}
+
+			got, err := Passphrase(false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Passphrase error = %v, want error %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Passphrase = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func write(t *testing.T, path string, contents []byte) {
+	if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// edit rewrite the sealed fields of a vault
+func edit(t *testing.T, path string, f func(s *sealed)) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s sealed
+	if err := json.Unmarshal(contents, &s); err != nil {
+		t.Fatal(err)
+	}
+	f(&s)
+	contents, err = json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, path, contents)
+}
+
+// setenv set or unset an environment variable for the duration of a test
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}